@@ -0,0 +1,212 @@
+package orange
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxBraceExpansion is the largest number of values ExpandBraces will return,
+// so a small expression such as "{1..1000000000}" cannot exhaust memory.
+const MaxBraceExpansion = 1 << 20
+
+// ExpandBraces expands the brace expressions in expression into discrete
+// values without contacting a range server, which is useful for offline use
+// or to validate an expression before sending it.
+//
+// A brace expression is either a comma separated list of alternatives, such as
+// "{web,db}", or an inclusive numeric range, such as "{01..03}", and may be
+// nested, as in "{web{1..2},db}".  Numeric ranges preserve zero padding when
+// either endpoint is zero padded, and may count down.  Commas outside of braces
+// separate values.  Values are returned in order of expansion, with duplicates
+// removed.
+//
+//     values, err := orange.ExpandBraces("host{01..03}.{east,west}")
+//     // values: host01.east, host01.west, host02.east, host02.west, host03.east, host03.west
+//
+// ExpandBraces returns ErrInvalidQuery when the braces are unbalanced, or when
+// expression uses range operators or functions that can only be evaluated by a
+// range server, such as cluster lookups with '%'.
+func ExpandBraces(expression string) ([]string, error) {
+	if expression == "" {
+		return nil, nil
+	}
+	e := &braceExpander{expression: expression}
+	values, err := e.alternatives(false)
+	if err != nil {
+		return nil, err
+	}
+	if e.pos < len(e.expression) {
+		return nil, e.errorf("unmatched '}' at offset %d", e.pos)
+	}
+
+	// Remove duplicate values, preserving the order of first appearance.
+	seen := make(map[string]struct{}, len(values))
+	unique := values[:0]
+	for _, v := range values {
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			unique = append(unique, v)
+		}
+	}
+	return unique, nil
+}
+
+// braceExpander is a recursive descent parser for brace expressions.
+type braceExpander struct {
+	expression string
+	pos        int
+}
+
+func (e *braceExpander) errorf(format string, a ...interface{}) error {
+	return ErrInvalidQuery{Expression: e.expression, Reason: fmt.Sprintf(format, a...)}
+}
+
+// alternatives parses a comma separated list of sequences, stopping at the
+// end of the expression, or at a closing brace when nested.
+func (e *braceExpander) alternatives(nested bool) ([]string, error) {
+	var values []string
+	for {
+		sequence, err := e.sequence(nested)
+		if err != nil {
+			return nil, err
+		}
+		if len(values)+len(sequence) > MaxBraceExpansion {
+			return nil, e.errorf("expands to more than %d values", MaxBraceExpansion)
+		}
+		values = append(values, sequence...)
+		if e.pos == len(e.expression) || e.expression[e.pos] != ',' {
+			return values, nil
+		}
+		e.pos++ // consume comma
+	}
+}
+
+// sequence parses literal text and brace expressions up to the next comma, the
+// end of the expression, or a closing brace when nested, and returns their
+// product.
+func (e *braceExpander) sequence(nested bool) ([]string, error) {
+	values := []string{""}
+	start := e.pos
+
+	for e.pos < len(e.expression) {
+		switch c := e.expression[e.pos]; c {
+		case ',':
+			return values, nil
+		case '}':
+			if !nested {
+				return nil, e.errorf("unmatched '}' at offset %d", e.pos)
+			}
+			return values, nil
+		case '{':
+			open := e.pos
+			e.pos++ // consume opening brace
+			group, err := e.group()
+			if err != nil {
+				return nil, err
+			}
+			if e.pos == len(e.expression) {
+				return nil, e.errorf("unmatched '{' at offset %d", open)
+			}
+			e.pos++ // consume closing brace
+			if len(values)*len(group) > MaxBraceExpansion {
+				return nil, e.errorf("expands to more than %d values", MaxBraceExpansion)
+			}
+			product := make([]string, 0, len(values)*len(group))
+			for _, prefix := range values {
+				for _, suffix := range group {
+					product = append(product, prefix+suffix)
+				}
+			}
+			values = product
+		case '%', '@', '&', '^', '(', ')', '/', '$', '*', '?', ';', ':', '#', '\\', '"', '\'', ' ', '\t', '\n', '\r':
+			return nil, e.errorf("cannot expand %q at offset %d without a range server", c, e.pos)
+		default:
+			if c == '-' && e.pos == start {
+				return nil, e.errorf("cannot expand set difference at offset %d without a range server", e.pos)
+			}
+			for i := range values {
+				values[i] += string(c)
+			}
+			e.pos++
+		}
+	}
+
+	return values, nil
+}
+
+// group parses the contents of a brace expression, leaving the position at
+// its closing brace.
+func (e *braceExpander) group() ([]string, error) {
+	if end := strings.IndexAny(e.expression[e.pos:], "{},"); end >= 0 && e.expression[e.pos+end] == '}' {
+		if values, ok, err := e.numericRange(e.expression[e.pos : e.pos+end]); ok {
+			if err != nil {
+				return nil, err
+			}
+			e.pos += end
+			return values, nil
+		}
+	}
+	if e.pos < len(e.expression) && e.expression[e.pos] == '}' {
+		return nil, e.errorf("empty braces at offset %d", e.pos-1)
+	}
+	return e.alternatives(true)
+}
+
+// numericRange returns the values of an inclusive numeric range such as
+// "01..10", and false when body is not a numeric range.
+func (e *braceExpander) numericRange(body string) ([]string, bool, error) {
+	i := strings.Index(body, "..")
+	if i < 0 {
+		return nil, false, nil
+	}
+	first, last := body[:i], body[i+2:]
+	if !isDigits(first) || !isDigits(last) {
+		return nil, false, nil
+	}
+	from, err := strconv.Atoi(first)
+	if err != nil {
+		return nil, true, e.errorf("cannot parse range %q: %s", body, err)
+	}
+	to, err := strconv.Atoi(last)
+	if err != nil {
+		return nil, true, e.errorf("cannot parse range %q: %s", body, err)
+	}
+
+	step, count := 1, to-from+1
+	if to < from {
+		step, count = -1, from-to+1
+	}
+	if count > MaxBraceExpansion {
+		return nil, true, e.errorf("expands to more than %d values", MaxBraceExpansion)
+	}
+
+	// Zero pad values to the width of the widest endpoint when either endpoint
+	// is zero padded.
+	var width int
+	if (len(first) > 1 && first[0] == '0') || (len(last) > 1 && last[0] == '0') {
+		width = len(first)
+		if len(last) > width {
+			width = len(last)
+		}
+	}
+
+	values := make([]string, 0, count)
+	for n := from; len(values) < count; n += step {
+		values = append(values, fmt.Sprintf("%0*d", width, n))
+	}
+	return values, true, nil
+}
+
+// isDigits returns true when s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
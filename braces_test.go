@@ -0,0 +1,60 @@
+package orange
+
+import "testing"
+
+func TestExpandBraces(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		cases := []struct {
+			expression string
+			want       []string
+		}{
+			{"", nil},
+			{"host", []string{"host"}},
+			{"foo,bar", []string{"foo", "bar"}},
+			{"host{a,b}", []string{"hosta", "hostb"}},
+			{"{web,db}.example.com", []string{"web.example.com", "db.example.com"}},
+			{"host{1..3}", []string{"host1", "host2", "host3"}},
+			{"host{01..03}", []string{"host01", "host02", "host03"}},
+			{"host{8..010}", []string{"host008", "host009", "host010"}},
+			{"host{3..1}", []string{"host3", "host2", "host1"}},
+			{"host{01..02}.{east,west}", []string{"host01.east", "host01.west", "host02.east", "host02.west"}},
+			{"{web{1..2},db}", []string{"web1", "web2", "db"}},
+			{"{a{b,c{d,e}},f}g", []string{"abg", "acdg", "aceg", "fg"}},
+			{"{a,a},a", []string{"a"}},
+			{"host{a}", []string{"hosta"}},
+			{"host-{1..2}", []string{"host-1", "host-2"}},
+		}
+		for _, c := range cases {
+			got, err := ExpandBraces(c.expression)
+			if err != nil {
+				t.Errorf("%q: %s", c.expression, err)
+				continue
+			}
+			ensureStringSlicesMatch(t, got, c.want)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		cases := []struct {
+			expression string
+			reason     string
+		}{
+			{"host{1,2", "unmatched '{'"},
+			{"host}", "unmatched '}'"},
+			{"{a,b}}", "unmatched '}'"},
+			{"host{}", "empty braces"},
+			{"%cluster", "without a range server"},
+			{"foo,-bar", "set difference"},
+			{"host{1..2000000}", "more than"},
+			{"{1..1024}{1..1025}", "more than"},
+		}
+		for _, c := range cases {
+			_, err := ExpandBraces(c.expression)
+			if _, ok := err.(ErrInvalidQuery); !ok {
+				t.Errorf("%q: GOT: %T; WANT: %T", c.expression, err, ErrInvalidQuery{})
+				continue
+			}
+			ensureError(t, err, c.reason)
+		}
+	})
+}
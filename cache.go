@@ -0,0 +1,135 @@
+package orange
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is used when caching is enabled but no CacheSize is
+// provided to control the maximum number of cached query responses.
+const DefaultCacheSize = 1024
+
+// queryCache is a concurrency safe, size bounded cache of query responses,
+// whose entries expire after a fixed time to live.  When negativeTTL is
+// positive, empty responses and errors that mean the expression does not exist
+// expire after it instead.  When full, adding a new entry evicts the least
+// recently used entry.
+type queryCache struct {
+	clock       Clock
+	ttl         time.Duration
+	negativeTTL time.Duration // negativeTTL is 0 unless negative results are cached
+	size        int
+
+	lock    sync.Mutex
+	lru     *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	values  []string
+	err     error // err is nil unless the entry caches a negative result
+	expires time.Time
+}
+
+func newQueryCache(clock Clock, ttl, negativeTTL time.Duration, size int) *queryCache {
+	return &queryCache{
+		clock:       clock,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		size:        size,
+		lru:         list.New(),
+		entries:     make(map[string]*list.Element),
+	}
+}
+
+// Get returns a copy of the cached values for key, or the cached error, and
+// whether an unexpired entry was found.
+func (qc *queryCache) Get(key string) ([]string, error, bool) {
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+	element, ok := qc.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !qc.clock.Now().Before(entry.expires) {
+		qc.lru.Remove(element)
+		delete(qc.entries, key)
+		return nil, nil, false
+	}
+	qc.lru.MoveToFront(element)
+	return copyStrings(entry.values), entry.err, true
+}
+
+// Put stores a copy of values for key, evicting the least recently used entry
+// when the cache is full.  Empty values expire after negativeTTL when it is
+// positive.
+func (qc *queryCache) Put(key string, values []string) {
+	ttl := qc.ttl
+	if len(values) == 0 && qc.negativeTTL > 0 {
+		ttl = qc.negativeTTL
+	}
+	qc.store(key, copyStrings(values), nil, ttl)
+}
+
+// PutError stores err for key when negative results are cached and err means
+// the expression does not exist, and returns whether it did.  Other errors,
+// such as timeouts and 5xx responses, are transient and never cached.
+func (qc *queryCache) PutError(key string, err error) bool {
+	if qc.negativeTTL <= 0 || !IsNoSuchCluster(err) {
+		return false
+	}
+	qc.store(key, nil, err, qc.negativeTTL)
+	return true
+}
+
+func (qc *queryCache) store(key string, values []string, err error, ttl time.Duration) {
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+	expires := qc.clock.Now().Add(ttl)
+	if element, ok := qc.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.values = values
+		entry.err = err
+		entry.expires = expires
+		qc.lru.MoveToFront(element)
+		return
+	}
+	for qc.lru.Len() >= qc.size {
+		oldest := qc.lru.Back()
+		qc.lru.Remove(oldest)
+		delete(qc.entries, oldest.Value.(*cacheEntry).key)
+	}
+	qc.entries[key] = qc.lru.PushFront(&cacheEntry{key: key, values: values, err: err, expires: expires})
+}
+
+// Len returns the number of entries in the cache, including expired entries
+// not yet removed.
+func (qc *queryCache) Len() int {
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+	return qc.lru.Len()
+}
+
+// cacheKey returns the key under which the response to the query expression
+// is cached.  The expression itself is always sent to range servers unchanged.
+func (c *Client) cacheKey(expression string) string {
+	if c.normalizeCacheKeys {
+		expression = collapseSpace(expression)
+	}
+	if c.lowercaseCacheKeys {
+		expression = strings.ToLower(expression)
+	}
+	return expression
+}
+
+// copyStrings returns a copy of values so callers cannot modify cached data.
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(values)), values...)
+}
@@ -0,0 +1,261 @@
+package orange
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueryCache(t *testing.T) {
+	t.Run("expires", func(t *testing.T) {
+		fc := newFakeClock()
+		qc := newQueryCache(fc, time.Minute, 0, 2)
+
+		qc.Put("foo", []string{"result1"})
+		fc.Advance(59 * time.Second)
+
+		values, _, ok := qc.Get("foo")
+		if got, want := ok, true; got != want {
+			t.Fatalf("GOT: %v; WANT: %v", got, want)
+		}
+		ensureStringSlicesMatch(t, values, []string{"result1"})
+
+		fc.Advance(time.Second)
+		if _, _, ok = qc.Get("foo"); ok {
+			t.Errorf("GOT: %v; WANT: %v", ok, false)
+		}
+		if got, want := qc.Len(), 0; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("evicts least recently used", func(t *testing.T) {
+		qc := newQueryCache(newFakeClock(), time.Minute, 0, 2)
+
+		qc.Put("one", []string{"1"})
+		qc.Put("two", []string{"2"})
+		qc.Get("one") // two is now least recently used
+		qc.Put("three", []string{"3"})
+
+		if _, _, ok := qc.Get("two"); ok {
+			t.Errorf("GOT: %v; WANT: %v", ok, false)
+		}
+		for _, key := range []string{"one", "three"} {
+			if _, _, ok := qc.Get(key); !ok {
+				t.Errorf("%s: GOT: %v; WANT: %v", key, ok, true)
+			}
+		}
+	})
+
+	t.Run("returns copies", func(t *testing.T) {
+		qc := newQueryCache(newFakeClock(), time.Minute, 0, 2)
+
+		values := []string{"result1"}
+		qc.Put("foo", values)
+		values[0] = "modified"
+
+		values, _, _ = qc.Get("foo")
+		values[0] = "modified"
+
+		values, _, _ = qc.Get("foo")
+		ensureStringSlicesMatch(t, values, []string{"result1"})
+	})
+}
+
+func TestClientCache(t *testing.T) {
+	var invocations int32
+	h := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&invocations, 1)
+		switch r.URL.RawQuery {
+		case "bad":
+			w.Header().Set("RangeException", "some error")
+		default:
+			w.Write([]byte("result1\nresult2\n"))
+		}
+	}
+
+	fc := newFakeClock()
+	configure := func(config *Config) {
+		config.CacheTTL = time.Minute
+		config.Clock = fc
+	}
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		for i := 0; i < 3; i++ {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+		}
+		if got, want := atomic.LoadInt32(&invocations), int32(1); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+
+		fc.Advance(time.Minute)
+		_, err := client.Query("foo")
+		ensureError(t, err)
+		if got, want := atomic.LoadInt32(&invocations), int32(2); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+
+		// Errors are not cached.
+		for i := 0; i < 2; i++ {
+			_, err = client.Query("bad")
+			ensureError(t, err, "some error")
+		}
+		if got, want := atomic.LoadInt32(&invocations), int32(4); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
+
+func TestClientCacheKeys(t *testing.T) {
+	var expressions []string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		expressions = append(expressions, r.URL.RawQuery)
+		w.Write([]byte("result1\n"))
+	}
+
+	t.Run("normalized", func(t *testing.T) {
+		expressions = nil
+		configure := func(config *Config) {
+			config.CacheTTL = time.Minute
+			config.NormalizeCacheKeys = true
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			for _, expression := range []string{" %foo  &  %bar", "%foo & %bar\t", "%FOO & %bar"} {
+				_, err := client.Query(expression)
+				ensureError(t, err)
+			}
+		})
+		// The first expression is sent unchanged, and only the expression that
+		// differs by case misses the cache.
+		ensureStringSlicesMatch(t, expressions, []string{"+%25foo++%26++%25bar", "%25FOO+%26+%25bar"})
+	})
+
+	t.Run("lowercased", func(t *testing.T) {
+		expressions = nil
+		configure := func(config *Config) {
+			config.CacheTTL = time.Minute
+			config.LowercaseCacheKeys = true
+			config.NormalizeCacheKeys = true
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			for _, expression := range []string{"%foo & %bar", "%FOO  & %Bar"} {
+				_, err := client.Query(expression)
+				ensureError(t, err)
+			}
+		})
+		ensureStringSlicesMatch(t, expressions, []string{"%25foo+%26+%25bar"})
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		expressions = nil
+		configure := func(config *Config) { config.CacheTTL = time.Minute }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			for _, expression := range []string{"%foo & %bar", "%foo  & %bar"} {
+				_, err := client.Query(expression)
+				ensureError(t, err)
+			}
+		})
+		ensureStringSlicesMatch(t, expressions, []string{"%25foo+%26+%25bar", "%25foo++%26+%25bar"})
+	})
+}
+
+func TestClientCacheNegative(t *testing.T) {
+	var invocations int32
+	h := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&invocations, 1)
+		switch r.URL.RawQuery {
+		case "%25missing":
+			w.Header().Set("RangeException", "NO_SUCH_CLUSTER: missing")
+		case "%25syntax":
+			w.Header().Set("RangeException", "cannot parse query")
+		case "%25down":
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		case "%25empty":
+		default:
+			w.Write([]byte("result1\n"))
+		}
+	}
+
+	fc := newFakeClock()
+	configure := func(config *Config) {
+		config.CacheNegativeTTL = 5 * time.Second
+		config.CacheTTL = time.Minute
+		config.Clock = fc
+	}
+
+	ensureInvocations := func(t *testing.T, want int32) {
+		t.Helper()
+		if got := atomic.SwapInt32(&invocations, 0); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	}
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		t.Run("empty", func(t *testing.T) {
+			for i := 0; i < 2; i++ {
+				values, err := client.Query("%empty")
+				ensureError(t, err)
+				if got, want := len(values), 0; got != want {
+					t.Errorf("GOT: %v; WANT: %v", got, want)
+				}
+			}
+			ensureInvocations(t, 1)
+
+			fc.Advance(5 * time.Second)
+			_, err := client.Query("%empty")
+			ensureError(t, err)
+			ensureInvocations(t, 1)
+		})
+
+		t.Run("positive", func(t *testing.T) {
+			_, err := client.Query("%foo")
+			ensureError(t, err)
+			fc.Advance(5 * time.Second)
+			_, err = client.Query("%foo")
+			ensureError(t, err)
+			ensureInvocations(t, 1)
+		})
+
+		t.Run("no such cluster", func(t *testing.T) {
+			for i := 0; i < 2; i++ {
+				_, err := client.Query("%missing")
+				if got, want := IsNoSuchCluster(err), true; got != want {
+					t.Errorf("GOT: %v; WANT: %v", err, ErrRangeException{Code: RangeExceptionNoSuchCluster})
+				}
+			}
+			ensureInvocations(t, 1)
+
+			fc.Advance(5 * time.Second)
+			_, err := client.Query("%missing")
+			ensureError(t, err, "NO_SUCH_CLUSTER")
+			ensureInvocations(t, 1)
+		})
+
+		t.Run("other errors", func(t *testing.T) {
+			for i := 0; i < 2; i++ {
+				_, err := client.Query("%syntax")
+				ensureError(t, err, "cannot parse query")
+			}
+			ensureInvocations(t, 2)
+		})
+	})
+
+	t.Run("transient errors", func(t *testing.T) {
+		configure := func(config *Config) {
+			config.CacheNegativeTTL = 5 * time.Second
+			config.CacheTTL = time.Minute
+			config.RetryCount = 0
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			atomic.StoreInt32(&invocations, 0)
+			for i := 0; i < 2; i++ {
+				_, err := client.Query("%down")
+				ensureError(t, err, "503")
+			}
+			ensureInvocations(t, 2)
+		})
+	})
+}
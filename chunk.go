@@ -0,0 +1,111 @@
+package orange
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// QueryChunked sends the query expression to range servers split into chunks
+// of at most maxLength bytes, and returns the union of their values.  It is a
+// convenience wrapper for QueryChunkedCtx using a background context.
+func (c *Client) QueryChunked(expression string, maxLength int) ([]string, error) {
+	return c.QueryChunkedCtx(context.Background(), expression, maxLength)
+}
+
+// QueryChunkedCtx resolves a comma separated list of expressions too long to
+// send as a single query, even using PUT, by splitting it between commas into
+// chunks of at most maxLength bytes, sending each chunk as a separate query
+// with the provided context, and returning the union of their values, with
+// duplicates removed.  Commas within braces, parentheses, regular expressions,
+// and quotes do not separate elements of the list, so each element is sent
+// whole.
+//
+// Because range applies subtraction and intersection to the values of the
+// elements that precede them, an expression with an element that begins with
+// '-' or '&' cannot be split, and results in ErrInvalidQuery, as does an
+// element that is longer than maxLength by itself.
+//
+//     hosts := strings.Join(thousandsOfHostnames, ",")
+//     values, err := client.QueryChunkedCtx(ctx, hosts, 4000)
+func (c *Client) QueryChunkedCtx(ctx context.Context, expression string, maxLength int) ([]string, error) {
+	chunks, err := chunkExpression(expression, maxLength)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, chunk := range chunks {
+		var values []string
+		if _, err := c.queryCallback(ctx, chunk, c.appendValues(&values)); err != nil {
+			return nil, err
+		}
+		lines = append(lines, values...)
+	}
+	return c.processResults(Union(lines, nil)), nil
+}
+
+// chunkExpression splits the comma separated list expression into chunks of
+// at most maxLength bytes, each a comma separated list of whole elements.
+func chunkExpression(expression string, maxLength int) ([]string, error) {
+	if maxLength < 1 {
+		return nil, fmt.Errorf("cannot split query into chunks of non-positive length: %d", maxLength)
+	}
+
+	var chunks []string
+	var chunk strings.Builder
+	for _, element := range splitElements(expression) {
+		if element == "" {
+			continue
+		}
+		if element[0] == '-' || element[0] == '&' {
+			return nil, ErrInvalidQuery{Expression: expression, Reason: fmt.Sprintf("cannot split expression with operator element into chunks: %q", element)}
+		}
+		if len(element) > maxLength {
+			return nil, ErrInvalidQuery{Expression: expression, Reason: fmt.Sprintf("element of %d bytes exceeds chunk length of %d", len(element), maxLength)}
+		}
+		if chunk.Len() > 0 && chunk.Len()+1+len(element) > maxLength {
+			chunks = append(chunks, chunk.String())
+			chunk.Reset()
+		}
+		if chunk.Len() > 0 {
+			chunk.WriteByte(',')
+		}
+		chunk.WriteString(element)
+	}
+	if chunk.Len() > 0 {
+		chunks = append(chunks, chunk.String())
+	}
+	return chunks, nil
+}
+
+// splitElements splits expression at each comma that is not within braces,
+// parentheses, a regular expression, or quotes.
+func splitElements(expression string) []string {
+	var elements []string
+	var depth int
+	var quote byte // quote is the byte that ends the current quoted section, or 0
+	start := 0
+	for i := 0; i < len(expression); i++ {
+		b := expression[i]
+		switch {
+		case quote != 0:
+			if b == '\\' {
+				i++ // skip the escaped byte
+			} else if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '/':
+			quote = b
+		case b == '{' || b == '(':
+			depth++
+		case b == '}' || b == ')':
+			if depth > 0 {
+				depth--
+			}
+		case b == ',' && depth == 0:
+			elements = append(elements, expression[start:i])
+			start = i + 1
+		}
+	}
+	return append(elements, expression[start:])
+}
@@ -0,0 +1,111 @@
+package orange
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSplitElements(t *testing.T) {
+	cases := []struct {
+		expression string
+		want       []string
+	}{
+		{"a", []string{"a"}},
+		{"a,b,c", []string{"a", "b", "c"}},
+		{"web{1,2},db", []string{"web{1,2}", "db"}},
+		{"%cluster(a,b),c", []string{"%cluster(a,b)", "c"}},
+		{"/web,db/,c", []string{"/web,db/", "c"}},
+		{`"a,b",c`, []string{`"a,b"`, "c"}},
+		{`"a\",b",c`, []string{`"a\",b"`, "c"}},
+		{"a,,b", []string{"a", "", "b"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expression, func(t *testing.T) {
+			got := splitElements(c.expression)
+			if g, w := strings.Join(got, "|"), strings.Join(c.want, "|"); g != w {
+				t.Errorf("GOT: %q; WANT: %q", g, w)
+			}
+		})
+	}
+}
+
+func TestChunkExpression(t *testing.T) {
+	t.Run("chunks", func(t *testing.T) {
+		chunks, err := chunkExpression("host1,host2,host3,web{1,2},host4", 11)
+		ensureError(t, err)
+		if got, want := strings.Join(chunks, "|"), "host1,host2|host3|web{1,2}|host4"; got != want {
+			t.Errorf("GOT: %q; WANT: %q", got, want)
+		}
+		for _, chunk := range chunks {
+			if len(chunk) > 11 {
+				t.Errorf("GOT: %v; WANT: <= %v", len(chunk), 11)
+			}
+		}
+	})
+
+	t.Run("empty elements", func(t *testing.T) {
+		chunks, err := chunkExpression(",a,,b,", 100)
+		ensureError(t, err)
+		if got, want := strings.Join(chunks, "|"), "a,b"; got != want {
+			t.Errorf("GOT: %q; WANT: %q", got, want)
+		}
+	})
+
+	t.Run("element too long", func(t *testing.T) {
+		_, err := chunkExpression("a,"+strings.Repeat("b", 20)+",c", 10)
+		if _, ok := err.(ErrInvalidQuery); !ok {
+			t.Fatalf("GOT: %v; WANT: %T", err, ErrInvalidQuery{})
+		}
+		ensureError(t, err, "element of 20 bytes exceeds chunk length of 10")
+	})
+
+	t.Run("operator", func(t *testing.T) {
+		_, err := chunkExpression("a,b,-c", 10)
+		ensureError(t, err, `operator element into chunks: "-c"`)
+		_, err = chunkExpression("a,b,&c", 10)
+		ensureError(t, err, `operator element into chunks: "&c"`)
+	})
+
+	t.Run("non-positive length", func(t *testing.T) {
+		_, err := chunkExpression("a", 0)
+		ensureError(t, err, "non-positive length")
+	})
+}
+
+func TestClientQueryChunked(t *testing.T) {
+	var lock sync.Mutex
+	var queries []string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		query, err := url.QueryUnescape(r.URL.RawQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lock.Lock()
+		queries = append(queries, query)
+		lock.Unlock()
+		// Resolve each element to itself, and each to a shared value.
+		for _, element := range strings.Split(query, ",") {
+			w.Write([]byte(element + "\nshared\n"))
+		}
+	}
+
+	configure := func(config *Config) { config.SortResults = true }
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		values, err := client.QueryChunked("host5,host4,host3,host2,host1", 11)
+		ensureError(t, err)
+		if got, want := strings.Join(values, ","), "host1,host2,host3,host4,host5,shared"; got != want {
+			t.Errorf("GOT: %q; WANT: %q", got, want)
+		}
+
+		_, err = client.QueryChunked("host1,"+strings.Repeat("x", 20), 11)
+		ensureError(t, err, "exceeds chunk length")
+	})
+
+	if got, want := strings.Join(queries, "|"), "host5,host4|host3,host2|host1"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+package orange
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitBreaker tracks consecutive failures for each range server.  After a
+// server fails threshold times in a row, its circuit opens and the server is
+// skipped until the cooldown elapses.  The circuit is then half-open: a single
+// query is allowed to probe the server, while others continue to skip it.
+// Success of the probe closes the circuit, and failure opens it for another
+// cooldown.
+type circuitBreaker struct {
+	clock     Clock
+	threshold int
+	cooldown  time.Duration
+
+	lock   sync.Mutex
+	states map[string]*breakerState
+}
+
+type breakerState struct {
+	failures  int       // consecutive failures
+	openUntil time.Time // zero unless circuit has been opened; extended while a probe is in flight
+}
+
+func newCircuitBreaker(clock Clock, threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		clock:     clock,
+		threshold: threshold,
+		cooldown:  cooldown,
+		states:    make(map[string]*breakerState),
+	}
+}
+
+// Allow returns true while the circuit for server is closed, and false while
+// it is open.  Once the cooldown elapses, it returns true to only the first
+// caller, whose query probes the server, and false to other callers until the
+// result of the probe is recorded.  When no result is recorded within another
+// cooldown, such as when the probe is canceled, another probe is allowed.
+func (cb *circuitBreaker) Allow(server string) bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	state, ok := cb.states[server]
+	if !ok || state.failures < cb.threshold {
+		return true
+	}
+	now := cb.clock.Now()
+	if now.Before(state.openUntil) {
+		return false
+	}
+	state.openUntil = now.Add(cb.cooldown) // half-open: refuse other queries while probing
+	return true
+}
+
+// Record updates the circuit for server based on the result of a query sent to
+// it.
+func (cb *circuitBreaker) Record(server string, err error) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	state, ok := cb.states[server]
+	if !isServerFailure(err) {
+		if ok {
+			delete(cb.states, server)
+		}
+		return
+	}
+	if !ok {
+		state = new(breakerState)
+		cb.states[server] = state
+	}
+	state.failures++
+	if state.failures >= cb.threshold {
+		state.openUntil = cb.clock.Now().Add(cb.cooldown)
+	}
+}
+
+// isServerFailure returns true when err indicates a problem with the range
+// server rather than with the query.
+func isServerFailure(err error) bool {
+	if err == nil || isQueryError(err) {
+		return false
+	}
+	switch e := err.(type) {
+	case ErrStatusNotOK:
+		return e.StatusCode >= 500
+	case streamError:
+		return !e.caller
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package orange
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	fc := newFakeClock()
+	cb := newCircuitBreaker(fc, 2, time.Minute)
+	failure := errors.New("connection refused")
+
+	t.Run("closed until threshold", func(t *testing.T) {
+		cb.Record("one", failure)
+		if got, want := cb.Allow("one"), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		cb.Record("one", failure)
+		if got, want := cb.Allow("one"), false; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := cb.Allow("two"), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("probe after cooldown", func(t *testing.T) {
+		fc.Advance(time.Minute)
+		if got, want := cb.Allow("one"), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := cb.Allow("one"), false; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want) // only one probe at a time
+		}
+		cb.Record("one", failure) // failed probe opens circuit again
+		if got, want := cb.Allow("one"), false; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("probe without result", func(t *testing.T) {
+		fc.Advance(time.Minute)
+		if got, want := cb.Allow("one"), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		fc.Advance(time.Minute)
+		if got, want := cb.Allow("one"), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want) // probe was never recorded
+		}
+		if got, want := cb.Allow("one"), false; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("recovers", func(t *testing.T) {
+		fc.Advance(time.Minute)
+		cb.Record("one", nil)
+		cb.Record("one", failure) // count restarts after success
+		if got, want := cb.Allow("one"), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("query errors are not failures", func(t *testing.T) {
+		cb.Record("three", ErrRangeException{Message: "NO_SUCH_CLUSTER"})
+		cb.Record("three", ErrStatusNotOK{StatusCode: http.StatusBadRequest})
+		cb.Record("three", ErrRangeException{Message: "NO_SUCH_CLUSTER"})
+		if got, want := cb.Allow("three"), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var failingCount int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failingCount, 1)
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("result1\n"))
+	}))
+	defer working.Close()
+
+	fc := newFakeClock()
+
+	client, err := NewClient(&Config{
+		BreakerCooldown:  time.Minute,
+		BreakerThreshold: 2,
+		Clock:            fc,
+		RetryCallback:    func(error) bool { return true },
+		RetryCount:       1,
+		Servers:          []string{strings.TrimLeft(failing.URL, "http://"), strings.TrimLeft(working.URL, "http://")},
+	})
+	ensureError(t, err)
+
+	query := func() {
+		t.Helper()
+		values, err := client.Query("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1"})
+	}
+
+	for i := 0; i < 6; i++ {
+		query()
+	}
+	if got, want := atomic.LoadInt32(&failingCount), int32(2); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+
+	// After the cooldown, the failing server is probed again.
+	fc.Advance(time.Minute)
+	for i := 0; i < 6; i++ {
+		query()
+	}
+	if got, want := atomic.LoadInt32(&failingCount), int32(3); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
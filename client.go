@@ -1,34 +1,106 @@
 package orange
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/time/rate"
+)
+
+// Paths of the range server endpoints.
+const (
+	countPath  = "/range/count"
+	expandPath = "/range/expand"
+	listPath   = "/range/list"
 )
 
 // defaultQueryURILengthThreshold defines the maximum length of the URI for an
-// outgoing GET query.  Queries that require a longer URI will automatically be
-// sent out via a PUT query.
+// outgoing GET query when no QueryLengthThreshold is provided.  Queries that
+// require a longer URI will automatically be sent out via a PUT query.
 const defaultQueryURILengthThreshold = 4096
 
 // Client provides a Query method that resolves range queries.
 type Client struct {
+	inFlight int64 // accessed atomically; first field for 64-bit alignment on 32-bit platforms
+
 	// The only thing that prevents us from exposing a structure with all public
 	// fields is the fact that we need to create the round robin list of
 	// servers, and validate other config parameters.
-	httpClient    Doer
-	servers       *roundRobinStrings
-	userAgent     string
-	retryCallback func(error) bool
-	retryCount    int
-	retryPause    time.Duration
+	acceptGzip             bool
+	basicAuthPassword      string
+	basicAuthUsername      string
+	bearerToken            string
+	breaker                *circuitBreaker
+	cache                  *queryCache
+	canonicalizeHTMLErrors bool
+	clock                  Clock
+	closed                 chan struct{} // closed is closed by Close
+	closeOnce              sync.Once
+	correlationIDHeader    string
+	correlationIDKey       interface{}
+	dedupeResults          bool
+	disablePut             bool
+	extraFormFields        string
+	flights                *flightGroup
+	gzipLongQueries        bool
+	gzipLongQueryThreshold int
+	headers                http.Header
+	hedgeDelay             time.Duration
+	hostHeader             string
+	httpClient             Doer
+	idempotencyKeyHeader   string
+	latencies              *latencyTracker
+	limiter                *rate.Limiter
+	logger                 Logger
+	longQueryMethod        string // longQueryMethod is either PUT or POST
+	lowercaseCacheKeys     bool
+	maxQueryDuration       time.Duration
+	maxResponseSize        int64
+	maxRetryAfter          time.Duration
+	maxServersPerQuery     int
+	newIdempotencyKey      func() string
+	normalizeCacheKeys     bool
+	observer               Observer
+	paginationPrefixes     []string // paginationPrefixes is nil unless PaginateQueries is true
+	perAttemptTimeout      time.Duration
+	pingTimeout            time.Duration
+	preserveLineEndings    bool
+	queryLengthThreshold   int
+	rejectLongQueries      bool
+	requestMiddleware      []func(*http.Request) error
+	responseFormat         ResponseFormat
+	responseTransform      func([]byte) ([]byte, error)
+	retryCallback          func(context.Context, int, string, error) bool
+	retryCount             int
+	retryDelay             func(int) time.Duration
+	retryJitter            time.Duration
+	scheme                 string                 // scheme is "https" when TLSConfig is provided, otherwise "http"
+	serverLimiters         *serverLimiters        // serverLimiters is nil unless ServerRateLimit is positive
+	serverLimits           map[string]ServerLimit // serverLimits is nil unless ServerLimits is provided
+	servers                *roundRobinStrings
+	slots                  chan struct{} // slots is nil unless MaxConcurrency is positive
+	sortResults            bool
+	srvRecord              string
+	srvResolver            SRVResolver
+	timeout                time.Duration   // timeout is the time limit for each query, recorded as the latency of failed attempts
+	transport              *http.Transport // transport is nil unless the client created its own http.Client
+	tryAllServers          bool
+	useCountEndpoint       bool
+	userAgent              string
+	validateQueries        bool
 }
 
 // NewClient returns a new instance that sends queries to one or more range
@@ -65,55 +137,543 @@ type Client struct {
 //         }
 //     }
 func NewClient(config *Config) (*Client, error) {
+	if config.MaxServersPerQuery < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative MaxServersPerQuery: %d", config.MaxServersPerQuery)
+	}
 	if config.RetryCount < 0 {
 		return nil, fmt.Errorf("cannot create Client with negative RetryCount: %d", config.RetryCount)
 	}
 	if config.RetryPause < 0 {
 		return nil, fmt.Errorf("cannot create Client with negative RetryPause: %s", config.RetryPause)
 	}
-	rrs, err := newRoundRobinStrings(config.Servers)
+	if config.RetryBackoffMax < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative RetryBackoffMax: %s", config.RetryBackoffMax)
+	}
+	if config.RetryJitter < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative RetryJitter: %s", config.RetryJitter)
+	}
+	if config.HedgeDelay < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative HedgeDelay: %s", config.HedgeDelay)
+	}
+	if config.RateLimit < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative RateLimit: %g", config.RateLimit)
+	}
+	if config.RateBurst < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative RateBurst: %d", config.RateBurst)
+	}
+	if config.ServerRateLimit < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative ServerRateLimit: %g", config.ServerRateLimit)
+	}
+	if config.ServerRateBurst < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative ServerRateBurst: %d", config.ServerRateBurst)
+	}
+	if config.MaxConcurrency < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative MaxConcurrency: %d", config.MaxConcurrency)
+	}
+	if config.MaxQueryDuration < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative MaxQueryDuration: %s", config.MaxQueryDuration)
+	}
+	if config.MaxResponseSize < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative MaxResponseSize: %d", config.MaxResponseSize)
+	}
+	if config.PerAttemptTimeout < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative PerAttemptTimeout: %s", config.PerAttemptTimeout)
+	}
+	if config.MaxRetryAfter < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative MaxRetryAfter: %s", config.MaxRetryAfter)
+	}
+	if config.BreakerThreshold < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative BreakerThreshold: %d", config.BreakerThreshold)
+	}
+	if config.BreakerCooldown < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative BreakerCooldown: %s", config.BreakerCooldown)
+	}
+	if config.CacheTTL < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative CacheTTL: %s", config.CacheTTL)
+	}
+	if config.CacheNegativeTTL < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative CacheNegativeTTL: %s", config.CacheNegativeTTL)
+	}
+	if config.CacheSize < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative CacheSize: %d", config.CacheSize)
+	}
+	if config.LatencyAlpha < 0 || config.LatencyAlpha > 1 {
+		return nil, fmt.Errorf("cannot create Client with LatencyAlpha outside range (0, 1]: %g", config.LatencyAlpha)
+	}
+	if config.CorrelationIDKey != nil && !reflect.TypeOf(config.CorrelationIDKey).Comparable() {
+		return nil, fmt.Errorf("cannot create Client with CorrelationIDKey that is not comparable: %T", config.CorrelationIDKey)
+	}
+	if config.LatencyProbeEvery < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative LatencyProbeEvery: %d", config.LatencyProbeEvery)
+	}
+	if config.LatencyRankInterval < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative LatencyRankInterval: %s", config.LatencyRankInterval)
+	}
+	if config.BearerToken != "" && (config.BasicAuthUsername != "" || config.BasicAuthPassword != "") {
+		// Never include credentials in error messages.
+		return nil, fmt.Errorf("cannot create Client with both BearerToken and BasicAuthUsername or BasicAuthPassword")
+	}
+	switch config.ResponseFormat {
+	case TextResponse, JSONResponse:
+	default:
+		return nil, fmt.Errorf("cannot create Client with unknown ResponseFormat: %s", config.ResponseFormat)
+	}
+
+	longQueryMethod := strings.ToUpper(config.LongQueryMethod)
+	switch longQueryMethod {
+	case "":
+		longQueryMethod = http.MethodPut
+	case http.MethodPut, http.MethodPost:
+	default:
+		return nil, fmt.Errorf("cannot create Client with unsupported LongQueryMethod: %q", config.LongQueryMethod)
+	}
+
+	gzipLongQueryThreshold := config.GzipLongQueryThreshold
+	if gzipLongQueryThreshold < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative GzipLongQueryThreshold: %d", gzipLongQueryThreshold)
+	}
+	if gzipLongQueryThreshold == 0 {
+		gzipLongQueryThreshold = DefaultGzipLongQueryThreshold
+	}
+
+	queryLengthThreshold := config.QueryLengthThreshold
+	if queryLengthThreshold < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative QueryLengthThreshold: %d", queryLengthThreshold)
+	}
+	if queryLengthThreshold == 0 {
+		queryLengthThreshold = defaultQueryURILengthThreshold
+	}
+
+	var serverLimits map[string]ServerLimit
+	if len(config.ServerLimits) > 0 {
+		serverLimits = make(map[string]ServerLimit, len(config.ServerLimits))
+		for server, limits := range config.ServerLimits {
+			if limits.MaxURILength < 0 {
+				return nil, fmt.Errorf("cannot create Client with negative MaxURILength for range server address: %q", server)
+			}
+			if limits.MaxBodySize < 0 {
+				return nil, fmt.Errorf("cannot create Client with negative MaxBodySize for range server address: %q", server)
+			}
+			if normalized, err := normalizeServer(server); err == nil {
+				server = normalized // so limits apply however the address is written
+			}
+			serverLimits[server] = limits
+		}
+	}
+	pingTimeout := config.PingTimeout
+	if pingTimeout < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative PingTimeout: %s", pingTimeout)
+	}
+	if pingTimeout == 0 {
+		pingTimeout = DefaultPingTimeout
+	}
+	if config.SRVRefreshInterval < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative SRVRefreshInterval: %s", config.SRVRefreshInterval)
+	}
+
+	servers, err := normalizeServers(config.Servers)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create Client with %s", err)
+	}
+	weights := make([]int, len(servers), len(servers)+len(config.WeightedServers))
+	for i := range weights {
+		weights[i] = 1
+	}
+	for _, sw := range config.WeightedServers {
+		if sw.Weight <= 0 {
+			return nil, fmt.Errorf("cannot create Client with non-positive Weight for range server address: %q", sw.Server)
+		}
+		if sw.Weight > MaxServerWeight {
+			return nil, fmt.Errorf("cannot create Client with Weight greater than %d for range server address: %q", MaxServerWeight, sw.Server)
+		}
+		server, err := normalizeServer(sw.Server)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create Client with invalid range server address: %q: %s", sw.Server, err)
+		}
+		servers = append(servers, server)
+		weights = append(weights, sw.Weight)
+	}
+
+	var hostHeader string
+	if config.HostHeader != "" {
+		if strings.Contains(config.HostHeader, "://") {
+			return nil, fmt.Errorf("cannot create Client with invalid HostHeader: %q: scheme not allowed", config.HostHeader)
+		}
+		if hostHeader, err = normalizeServer(config.HostHeader); err != nil {
+			return nil, fmt.Errorf("cannot create Client with invalid HostHeader: %q: %s", config.HostHeader, err)
+		}
+	}
+
+	if config.UnixSocket != "" && len(servers) == 0 && config.SRVRecord == "" {
+		servers, weights = []string{unixSocketHost}, []int{1}
+	}
+
+	srvResolver := config.SRVResolver
+	if config.SRVRecord != "" {
+		if srvResolver == nil {
+			srvResolver = net.DefaultResolver
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+		resolved, err := resolveSRV(ctx, srvResolver, config.SRVRecord)
+		cancel()
+		if err != nil && len(servers) == 0 {
+			return nil, fmt.Errorf("cannot create Client without resolving SRVRecord: %s", err)
+		}
+		if len(resolved) > 0 {
+			servers, weights = resolved, nil
+		}
+	}
+	rrs, err := newWeightedRoundRobinStrings(servers, weights)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create Client without at least one range server address")
 	}
+	selectionStrategy := config.SelectionStrategy
+	if config.PreferLowLatency {
+		if selectionStrategy != RoundRobin && selectionStrategy != LeastLatency {
+			return nil, fmt.Errorf("cannot create Client with both PreferLowLatency and SelectionStrategy: %s", selectionStrategy)
+		}
+		selectionStrategy = LeastLatency
+	}
+	switch selectionStrategy {
+	case RoundRobin:
+	case Random:
+		rrs.random = true
+	case LeastLatency:
+	default:
+		return nil, fmt.Errorf("cannot create Client with unknown SelectionStrategy: %s", selectionStrategy)
+	}
+
+	clock := config.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
 
-	retryCallback := config.RetryCallback
+	var breaker *circuitBreaker
+	if config.BreakerThreshold > 0 {
+		breaker = newCircuitBreaker(clock, config.BreakerThreshold, config.BreakerCooldown)
+	}
+
+	var cache *queryCache
+	if config.CacheTTL > 0 {
+		size := config.CacheSize
+		if size == 0 {
+			size = DefaultCacheSize
+		}
+		cache = newQueryCache(clock, config.CacheTTL, config.CacheNegativeTTL, size)
+	}
+
+	var flights *flightGroup
+	if config.CoalesceQueries {
+		flights = newFlightGroup()
+	}
+
+	var latencies *latencyTracker
+	if selectionStrategy == LeastLatency {
+		alpha := config.LatencyAlpha
+		if alpha == 0 {
+			alpha = DefaultLatencyAlpha
+		}
+		interval := config.LatencyRankInterval
+		if interval == 0 {
+			interval = DefaultLatencyRankInterval
+		}
+		probeEvery := config.LatencyProbeEvery
+		if probeEvery == 0 {
+			probeEvery = DefaultLatencyProbeEvery
+		}
+		latencies = newLatencyTracker(clock, alpha, interval, probeEvery)
+	}
+
+	observer := config.Observer
+	if observer == nil {
+		observer = NoopObserver{}
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = NoopLogger{}
+	}
+
+	retryCallback := config.RetryCallbackCtx
 	if retryCallback == nil {
-		retryCallback = makeRetryCallback(len(config.Servers))
+		simple := config.RetryCallback
+		if simple == nil {
+			simple = makeRetryCallback(len(servers))
+		}
+		retryCallback = func(_ context.Context, _ int, _ string, err error) bool { return simple(err) }
+	} else if config.RetryCallback != nil {
+		return nil, fmt.Errorf("cannot create Client with both RetryCallback and RetryCallbackCtx")
+	}
+
+	maxRetryAfter := config.MaxRetryAfter
+	if maxRetryAfter == 0 {
+		maxRetryAfter = DefaultMaxRetryAfter
+	}
+
+	retryDelay := config.RetryDelay
+	if retryDelay == nil {
+		retryDelay = makeRetryDelay(config.RetryPause, config.RetryBackoff, config.RetryBackoffMax)
+	}
+
+	// Encode the extra form fields once, in a stable order, never allowing
+	// them to overwrite the query field.
+	var extraFormFields string
+	if len(config.ExtraFormFields) > 0 {
+		values := make(url.Values, len(config.ExtraFormFields))
+		for k, v := range config.ExtraFormFields {
+			if k != "query" {
+				values.Set(k, v)
+			}
+		}
+		if len(values) > 0 {
+			extraFormFields = "&" + values.Encode()
+		}
+	}
+
+	// Canonicalize header keys so they may be compared with the headers set
+	// by the library, and copy values so later changes to the Config do not
+	// affect the Client.
+	headers := make(http.Header, len(config.Headers))
+	for key, values := range config.Headers {
+		key = http.CanonicalHeaderKey(key)
+		headers[key] = append(headers[key], values...)
 	}
 
 	userAgent := config.UserAgent
+	if userAgent == "" && headers.Get("User-Agent") == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	newIdempotencyKey := config.IdempotencyKeyGenerator
+	if newIdempotencyKey == nil {
+		newIdempotencyKey = func() string { return randomHex(16) }
+	}
+
+	var paginationPrefixes []string
+	if config.PaginateQueries {
+		paginationPrefixes = DefaultPaginationPrefixes
+		if len(config.PaginationPrefixes) > 0 {
+			paginationPrefixes = append([]string(nil), config.PaginationPrefixes...)
+		}
+		for _, prefix := range paginationPrefixes {
+			if prefix == "" {
+				return nil, fmt.Errorf("cannot create Client with empty PaginationPrefixes element")
+			}
+		}
+	}
+
+	var slots chan struct{}
+	if config.MaxConcurrency > 0 {
+		slots = make(chan struct{}, config.MaxConcurrency)
+	}
 
+	if config.UnixSocket != "" && config.HTTPClient != nil {
+		return nil, fmt.Errorf("cannot create Client with both HTTPClient and UnixSocket")
+	}
+	if config.WrapTransport != nil && config.HTTPClient != nil {
+		return nil, fmt.Errorf("cannot create Client with both HTTPClient and WrapTransport")
+	}
+	if config.IdleConnTimeout < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative IdleConnTimeout: %s", config.IdleConnTimeout)
+	}
+	if config.MaxConnsPerHost < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative MaxConnsPerHost: %d", config.MaxConnsPerHost)
+	}
+	if config.MaxIdleConns < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative MaxIdleConns: %d", config.MaxIdleConns)
+	}
+	if config.MaxIdleConnsPerHost < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative MaxIdleConnsPerHost: %d", config.MaxIdleConnsPerHost)
+	}
+	if config.UnixSocket != "" && config.Proxy != nil {
+		return nil, fmt.Errorf("cannot create Client with both Proxy and UnixSocket")
+	}
+	if config.HTTPClient != nil && (config.MaxRedirects != 0 || config.CheckRedirect != nil) {
+		return nil, fmt.Errorf("cannot create Client with both HTTPClient and a redirect policy")
+	}
+	if config.MaxRedirects != 0 && config.CheckRedirect != nil {
+		return nil, fmt.Errorf("cannot create Client with both CheckRedirect and MaxRedirects")
+	}
+
+	tlsConfig := config.TLSConfig
+	if config.ClientCertFile != "" || config.ClientKeyFile != "" {
+		if config.ClientCertFile == "" || config.ClientKeyFile == "" {
+			return nil, fmt.Errorf("cannot create Client with only one of ClientCertFile and ClientKeyFile")
+		}
+		if config.HTTPClient != nil {
+			return nil, fmt.Errorf("cannot create Client with both HTTPClient and ClientCertFile")
+		}
+		certificate, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create Client without loading client certificate: %s", err)
+		}
+		if tlsConfig == nil {
+			tlsConfig = new(tls.Config)
+		} else {
+			tlsConfig = tlsConfig.Clone()
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, certificate)
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	timeout := config.Timeout
+	if timeout < 0 {
+		return nil, fmt.Errorf("cannot create Client with negative Timeout: %s", timeout)
+	}
+	if timeout == 0 {
+		timeout = DefaultQueryTimeout
+	}
+
+	var transport *http.Transport
 	httpClient := config.HTTPClient
 	if httpClient == nil {
+		idleConnTimeout := config.IdleConnTimeout
+		if idleConnTimeout == 0 {
+			idleConnTimeout = DefaultIdleConnTimeout
+		}
+		maxIdleConns := config.MaxIdleConns
+		if maxIdleConns == 0 {
+			maxIdleConns = DefaultMaxIdleConns
+		}
+		maxIdleConnsPerHost := config.MaxIdleConnsPerHost
+		if maxIdleConnsPerHost == 0 {
+			maxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+		}
+		transport = &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   DefaultDialTimeout,
+				KeepAlive: DefaultDialKeepAlive,
+			}).DialContext,
+			ForceAttemptHTTP2:   config.EnableHTTP2,
+			IdleConnTimeout:     idleConnTimeout,
+			MaxConnsPerHost:     config.MaxConnsPerHost,
+			MaxIdleConns:        maxIdleConns,
+			MaxIdleConnsPerHost: maxIdleConnsPerHost,
+			Proxy:               config.Proxy,
+		}
+		if tlsConfig != nil {
+			transport.TLSClientConfig = tlsConfig.Clone()
+		}
+		if config.UnixSocket != "" {
+			transport.DialContext = dialUnixSocket(config.UnixSocket)
+		}
+		checkRedirect := config.CheckRedirect
+		if checkRedirect == nil {
+			maxRedirects := config.MaxRedirects
+			if maxRedirects == 0 {
+				maxRedirects = DefaultMaxRedirects
+			}
+			checkRedirect = limitRedirects(maxRedirects)
+		}
+		var roundTripper http.RoundTripper = transport
+		if config.WrapTransport != nil {
+			if roundTripper = config.WrapTransport(transport); roundTripper == nil {
+				return nil, fmt.Errorf("cannot create Client with WrapTransport that returns nil")
+			}
+		}
 		httpClient = &http.Client{
 			// WARNING: Using http.Client instance without a Timeout will cause
 			// resource leaks and may render your program inoperative if the
 			// client connects to a buggy range server, or over a poor network
 			// connection.
-			Timeout: time.Duration(DefaultQueryTimeout),
+			Timeout: timeout,
 
-			Transport: &http.Transport{
-				Dial: (&net.Dialer{
-					Timeout:   DefaultDialTimeout,
-					KeepAlive: DefaultDialKeepAlive,
-				}).Dial,
-				MaxIdleConnsPerHost: int(DefaultMaxIdleConnsPerHost),
-			},
+			CheckRedirect: checkRedirect,
+			Transport:     roundTripper,
 		}
 	}
 
 	client := &Client{
-		httpClient:    httpClient,
-		retryCallback: retryCallback,
-		retryCount:    config.RetryCount,
-		retryPause:    config.RetryPause,
-		servers:       rrs,
-		userAgent:     userAgent,
+		acceptGzip:             config.AcceptGzip,
+		basicAuthPassword:      config.BasicAuthPassword,
+		basicAuthUsername:      config.BasicAuthUsername,
+		bearerToken:            config.BearerToken,
+		breaker:                breaker,
+		cache:                  cache,
+		canonicalizeHTMLErrors: config.CanonicalizeHTMLErrors,
+		clock:                  clock,
+		closed:                 make(chan struct{}),
+		correlationIDHeader:    config.CorrelationIDHeader,
+		correlationIDKey:       config.CorrelationIDKey,
+		dedupeResults:          config.DedupeResults,
+		disablePut:             config.DisablePut,
+		extraFormFields:        extraFormFields,
+		flights:                flights,
+		gzipLongQueries:        config.GzipLongQueries,
+		gzipLongQueryThreshold: gzipLongQueryThreshold,
+		headers:                headers,
+		hedgeDelay:             config.HedgeDelay,
+		hostHeader:             hostHeader,
+		idempotencyKeyHeader:   http.CanonicalHeaderKey(config.IdempotencyKeyHeader),
+		newIdempotencyKey:      newIdempotencyKey,
+		httpClient:             httpClient,
+		latencies:              latencies,
+		limiter:                newRateLimiter(config.RateLimit, config.RateBurst),
+		longQueryMethod:        longQueryMethod,
+		lowercaseCacheKeys:     config.LowercaseCacheKeys,
+		maxQueryDuration:       config.MaxQueryDuration,
+		maxResponseSize:        config.MaxResponseSize,
+		maxRetryAfter:          maxRetryAfter,
+		normalizeCacheKeys:     config.NormalizeCacheKeys,
+		perAttemptTimeout:      config.PerAttemptTimeout,
+		logger:                 logger,
+		observer:               observer,
+		paginationPrefixes:     paginationPrefixes,
+		pingTimeout:            pingTimeout,
+		preserveLineEndings:    config.PreserveLineEndings,
+		queryLengthThreshold:   queryLengthThreshold,
+		rejectLongQueries:      config.RejectLongQueries,
+		requestMiddleware:      append([]func(*http.Request) error(nil), config.RequestMiddleware...),
+		responseFormat:         config.ResponseFormat,
+		responseTransform:      config.ResponseTransform,
+		retryCallback:          retryCallback,
+		maxServersPerQuery:     config.MaxServersPerQuery,
+		retryCount:             config.RetryCount,
+		retryDelay:             retryDelay,
+		retryJitter:            config.RetryJitter,
+		serverLimiters:         newServerLimiters(config.ServerRateLimit, config.ServerRateBurst),
+		servers:                rrs,
+		sortResults:            config.SortResults,
+		srvRecord:              config.SRVRecord,
+		srvResolver:            srvResolver,
+		slots:                  slots,
+		timeout:                timeout,
+		transport:              transport,
+		scheme:                 scheme,
+		serverLimits:           serverLimits,
+		tryAllServers:          config.TryAllServers,
+		useCountEndpoint:       config.UseCountEndpoint,
+		userAgent:              userAgent,
+		validateQueries:        config.ValidateQueries,
+	}
+
+	if config.SRVRecord != "" {
+		interval := config.SRVRefreshInterval
+		if interval == 0 {
+			interval = DefaultSRVRefreshInterval
+		}
+		go client.refreshSRV(interval)
 	}
 
 	return client, nil
 }
 
+// Close stops resolving the client's SRVRecord, and releases the idle
+// keep-alive connections held by the http.Client the Client created when
+// Config.HTTPClient was nil.  It does not close the idle connections of a
+// configured HTTPClient, because that client belongs to the caller.  A Client
+// must not be used after it has been closed.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	if c.transport != nil {
+		c.transport.CloseIdleConnections()
+	}
+	return nil
+}
+
 // Query sends out a query and returns either a slice of strings corresponding
 // to the query response or an error.
 //
@@ -125,6 +685,12 @@ func NewClient(config *Config) (*Client, error) {
 // If a query's response HTTP status code is not okay, it returns
 // ErrStatusNotOK.
 //
+// Each line of the response body is one value, and lines that are empty or
+// contain only white space are not values.  When the query resolves to no
+// values, such as when the response body is empty, it returns an empty,
+// non-nil slice and a nil error, so callers may distinguish an empty result
+// from an error by testing the error alone.
+//
 //     func main() {
 //         // Create a range client.  Programs can list more than one server and
 //         // include other options.  See Config structure documentation for specifics.
@@ -187,7 +753,7 @@ func (c *Client) Query(expression string) ([]string, error) {
 //         }
 //
 //         if flag.NArg() == 0 {
-//             fmt.Fprintf(os.Stderr, "USAGE: %s [-timeout DURATION] q1 q2\n")
+//             fmt.Fprintf(os.Stderr, "USAGE: %s [-timeout DURATION] q1 q2\n", os.Args[0])
 //             os.Exit(1)
 //         }
 //
@@ -199,15 +765,96 @@ func (c *Client) Query(expression string) ([]string, error) {
 //
 //         fmt.Println(values)
 //     }
-func (c *Client) QueryCtx(ctx context.Context, expression string) (lines []string, err error) {
-	err = c.QueryCallback(ctx, expression, func(ior io.Reader) error {
-		s := bufio.NewScanner(ior)
+func (c *Client) QueryCtx(ctx context.Context, expression string) ([]string, error) {
+	var key string
+	if c.cache != nil {
+		key = c.cacheKey(expression)
+		if lines, err, ok := c.cache.Get(key); ok {
+			return lines, err
+		}
+	}
+	var lines []string
+	var err error
+	if c.flights != nil {
+		lines, err = c.flights.Do(ctx, flightKey(ctx, expression), func(ctx context.Context) ([]string, error) {
+			return c.queryLines(ctx, expression)
+		})
+	} else {
+		lines, err = c.queryLines(ctx, expression)
+	}
+	if err != nil {
+		if c.cache != nil {
+			c.cache.PutError(key, err)
+		}
+		return nil, err
+	}
+	if c.cache != nil {
+		c.cache.Put(key, lines)
+	}
+	return lines, nil
+}
+
+// queryLines sends the query and returns the lines of the response body.
+func (c *Client) queryLines(ctx context.Context, expression string) ([]string, error) {
+	if c.paginationPrefixes != nil {
+		lines, err := c.paginatedLines(ctx, expression)
+		if err != nil {
+			return nil, err
+		}
+		return c.processResults(lines), nil
+	}
+	var lines []string
+	if err := c.QueryCallback(ctx, expression, c.appendValues(&lines)); err != nil {
+		return nil, err
+	}
+	return c.processResults(lines), nil
+}
+
+// Expand sends the query expression to the expand endpoint of a range server,
+// rather than the list endpoint used by Query, and returns either a slice of
+// strings corresponding to the response or an error.  It is a convenience
+// wrapper for ExpandCtx using a background context.
+func (c *Client) Expand(expression string) ([]string, error) {
+	return c.ExpandCtx(context.Background(), expression)
+}
+
+// ExpandCtx sends the query expression to the expand endpoint of a range
+// server with the provided context.  Servers are selected and the query is
+// retried just as they are for QueryCtx, and it returns the same error types.
+// Responses to Expand are neither cached nor coalesced.
+func (c *Client) ExpandCtx(ctx context.Context, expression string) ([]string, error) {
+	var lines []string
+	if _, err := c.endpointCallback(ctx, expandPath, expression, c.appendValues(&lines)); err != nil {
+		return nil, err
+	}
+	return c.processResults(lines), nil
+}
+
+// QueryWithServer sends out a query and returns either a slice of strings
+// corresponding to the query response and the address of the range server that
+// provided the response, or an error.  When the query is retried, the returned
+// server is the one that answered the successful attempt.
+func (c *Client) QueryWithServer(expression string) (lines []string, server string, err error) {
+	server, err = c.queryCallback(context.Background(), expression, c.appendValues(&lines))
+	if err == nil {
+		lines = c.processResults(lines)
+	}
+	return
+}
+
+// appendLines returns a callback that appends each line of the response body
+// that is not blank to lines, preserving line endings and byte order marks when
+// preserve is true.
+func appendLines(lines *[]string, preserve bool) func(io.Reader) error {
+	return func(ior io.Reader) error {
+		s := newLineScanner(ior, preserve)
 		for s.Scan() {
-			lines = append(lines, s.Text())
+			if line := s.Text(); strings.TrimSpace(line) != "" {
+				*lines = append(*lines, line)
+			}
 		}
 		return s.Err()
-	})
-	return
+	}
 }
 
 // QueryCallback sends the query expression to the range client with the
@@ -215,10 +862,92 @@ func (c *Client) QueryCtx(ctx context.Context, expression string) (lines []strin
 // function with an io.Reader configured to read the response body from the
 // range server.
 func (c *Client) QueryCallback(ctx context.Context, expression string, callback func(io.Reader) error) error {
+	_, err := c.queryCallback(ctx, expression, callback)
+	return err
+}
+
+// queryCallback sends the query expression to one or more range servers, as
+// allowed by the client's Servers and Retry settings, and returns the address
+// of the server that was sent the final attempt.
+func (c *Client) queryCallback(ctx context.Context, expression string, callback func(io.Reader) error) (string, error) {
+	return c.endpointCallback(ctx, listPath, expression, callback)
+}
+
+// endpointCallback is like queryCallback, but sends the query expression to
+// the specified endpoint path of the range servers.
+func (c *Client) endpointCallback(ctx context.Context, path, expression string, callback func(io.Reader) error) (string, error) {
+	return c.observeQuery(ctx, expression, func(ctx context.Context, server string) error {
+		return c.query(ctx, path, expression, callback, server)
+	})
+}
+
+// sendFunc sends a single request for a query to the specified range server.
+type sendFunc func(ctx context.Context, server string) error
+
+// observeQuery notifies the observer before and after sending the query
+// expression using send, as allowed by the client's Retry settings.
+func (c *Client) observeQuery(ctx context.Context, expression string, send sendFunc) (string, error) {
+	ctx = c.withCorrelationID(ctx)
+	id := CorrelationID(ctx)
+	co, ok := c.observer.(ContextObserver)
+	if ok {
+		ctx = co.QueryStartedCtx(ctx, expression)
+	} else {
+		c.observer.QueryStarted(expression)
+	}
+	c.logger.Log(LogDebug, "query started", "correlation_id", id, "expression", expression)
+	server, err := c.budgetQuery(c.withIdempotencyKey(ctx), send)
+	if ok {
+		co.QueryFinishedCtx(ctx, expression, err)
+	} else {
+		c.observer.QueryFinished(expression, err)
+	}
+	if err != nil {
+		c.logger.Log(LogError, "query failed", "correlation_id", id, "expression", expression, "server", server, "error", err)
+	} else {
+		c.logger.Log(LogDebug, "query finished", "correlation_id", id, "expression", expression, "server", server)
+	}
+	return server, err
+}
+
+// budgetQuery sends the query using retryQuery, and when the client is
+// configured with a MaxQueryDuration, returns ErrQueryBudgetExceeded when the
+// duration elapses before the query succeeds.  The context of a query that
+// exceeds its budget is closed, which cancels any attempt still in flight.
+func (c *Client) budgetQuery(ctx context.Context, send sendFunc) (string, error) {
+	if c.maxQueryDuration <= 0 {
+		return c.retryQuery(ctx, send)
+	}
+	bctx, cancel := context.WithTimeout(ctx, c.maxQueryDuration)
+	defer cancel()
+	server, err := c.retryQuery(bctx, send)
+	if err != nil && bctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		err = ErrQueryBudgetExceeded{Budget: c.maxQueryDuration, Err: err}
+	}
+	return server, err
+}
+
+// retryQuery sends the query, retrying as allowed by the client's Retry
+// settings, and returns the address of the server that was sent the final
+// attempt.  When the context closes after an attempt completed, it returns the
+// result of that attempt rather than the context's error, because the result
+// is more informative.
+func (c *Client) retryQuery(ctx context.Context, send sendFunc) (string, error) {
 	done := ctx.Done()
 	ch := make(chan struct{})
+	var server string
 	var err error
 
+	// The result of the most recently completed attempt, for use when the
+	// context closes before the go-routine finishes.
+	var lock sync.Mutex
+	var completed bool
+	var lastServer string
+	var lastErr error
+
+	tried := newTriedServers(c.maxServersPerQuery)
+	retryCount := c.retryCountFor(ctx)
+
 	// Spawn a go-routine to send queries to one or more range servers, as
 	// allowed by the client's Servers and Retry settings.
 	go func() {
@@ -228,21 +957,31 @@ func (c *Client) QueryCallback(ctx context.Context, expression string, callback
 			// If not first attempt, and there is a retry pause, then wait.
 			// This logic will neither sleep on the first attempt nor after the
 			// final attempt.
-			if attempts > 0 && c.retryPause > 0 {
-				time.Sleep(c.retryPause)
-
-				// After wake-up, ensure context has not closed, and return
-				// early if it has without sending another query whose results
-				// will be simply thrown away.
-				select {
-				case <-done:
+			if attempts > 0 {
+				pause := c.pauseBefore(attempts, err)
+				c.logger.Log(LogInfo, "retrying query", "correlation_id", CorrelationID(ctx), "retry", attempts, "pause", pause, "error", err)
+				// Return early if the context closes during the pause, without
+				// sending another query whose results will be simply thrown
+				// away.
+				if pause > 0 && !sleep(c.clock, done, pause) {
 					return
-				default:
 				}
 			}
 
-			err = c.query(ctx, expression, callback, c.servers.Next())
-			if err == nil || attempts == c.retryCount || c.retryCallback(err) == false {
+			s, e := c.attempt(ctx, send, tried)
+			if e == errServerLimit {
+				// Keep the result of the previous attempt.
+				close(ch)
+				return
+			}
+			recordAttempt(ctx)
+			server, err = s, e
+			if !isContextError(err) {
+				lock.Lock()
+				completed, lastServer, lastErr = true, server, err
+				lock.Unlock()
+			}
+			if err == nil || attempts == retryCount || isStreamError(err) || c.retryCallback(ctx, attempts+1, server, err) == false {
 				close(ch)
 				return
 			}
@@ -255,35 +994,159 @@ func (c *Client) QueryCallback(ctx context.Context, expression string, callback
 	// caller.
 	select {
 	case <-done:
-		return ctx.Err()
+		lock.Lock()
+		defer lock.Unlock()
+		if completed {
+			return lastServer, lastErr
+		}
+		return "", ctx.Err()
 	case <-ch:
+		return server, err
+	}
+}
+
+// attempt sends the query to the next range server, preferring servers that
+// tried shows the query has not yet been sent to.  When the client is
+// configured to try all servers, a failed query is sent to each of the other
+// servers in turn until one succeeds.  It returns the address of the final
+// server it queried, or errServerLimit when tried does not allow sending the
+// query to another server.
+func (c *Client) attempt(ctx context.Context, send sendFunc, tried *triedServers) (string, error) {
+	if !c.tryAllServers {
+		if c.hedgeDelay > 0 {
+			return c.hedge(ctx, send, tried)
+		}
+		server := c.nextServer()
+		if tried.has(server) {
+			server = tried.preferUntried(c.serverSequence())[0]
+		}
+		if !tried.allow(server) {
+			return "", errServerLimit
+		}
+		return server, c.queryServer(ctx, send, server)
+	}
+
+	var server string
+	var err error
+
+	for i, s := range tried.preferUntried(c.serverSequence()) {
+		if i > 0 {
+			// Before trying another server, abort when context is already done.
+			select {
+			case <-ctx.Done():
+				return server, ctx.Err()
+			default:
+			}
+		}
+		if !tried.allow(s) {
+			if i == 0 {
+				return "", errServerLimit
+			}
+			break
+		}
+		server = s
+		err = c.queryServer(ctx, send, server)
+		if err == nil {
+			return server, nil
+		}
+		if isQueryError(err) {
+			break // other servers would return the same error
+		}
+		if isStreamError(err) {
+			break // values were already delivered
+		}
+	}
+
+	return server, err
+}
+
+// queryServer sends the query to the specified range server, notifies the
+// observer and logger, and records the result with the server's circuit
+// breaker and latency tracker.  Queries aborted because the context closed are
+// not held against the server, but attempts that exceed the client's
+// PerAttemptTimeout are.
+func (c *Client) queryServer(ctx context.Context, send sendFunc, server string) error {
+	actx := ctx
+	if c.perAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		actx, cancel = context.WithTimeout(ctx, c.perAttemptTimeout)
+		defer cancel()
+	}
+	started := c.clock.Now()
+	err := send(actx, server)
+	duration := c.clock.Now().Sub(started)
+	if err == nil || err == errHedgeLost {
+		c.logger.Log(LogDebug, "attempt finished", "correlation_id", CorrelationID(ctx), "server", server, "status", statusCode(err), "duration", duration)
+	} else {
+		c.logger.Log(LogWarn, "attempt failed", "correlation_id", CorrelationID(ctx), "server", server, "status", statusCode(err), "duration", duration, "error", err)
+	}
+	if err == errHedgeLost {
+		// The server answered successfully, only more slowly than another.
+		c.observer.AttemptFinished(server, statusCode(err), duration, err)
+		if c.latencies != nil {
+			c.latencies.Record(server, duration)
+		}
 		return err
 	}
+	c.observer.AttemptFinished(server, statusCode(err), duration, err)
+	if c.latencies != nil {
+		if isServerFailure(err) && duration < c.timeout {
+			duration = c.timeout // rank failed servers as though they timed out
+		}
+		c.latencies.Record(server, duration)
+	}
+	if c.breaker != nil && ctx.Err() == nil {
+		c.breaker.Record(server, err)
+	}
+	return err
 }
 
-// query attempts to fetch the results from querying a range server with the
-// specified range expression.
+// query attempts to fetch the results from querying the endpoint path of a
+// range server with the specified range expression.
 //
 // It prefers using the GET method when the resulting URI is fewer characters
-// than a configured limit, but will re-send the query using the PUT method if
-// the range server returns method not allowed response.  When the resulting URI
-// is or exceeds a configured limit, it prefers using the PUT method, but will
-// re-send the query using the GET method if the range server returns a Method
-// Not Allowed,
-func (c *Client) query(ctx context.Context, expression string, callback func(io.Reader) error, server string) error {
+// than a configured limit, but will re-send the query using the configured
+// LongQueryMethod, PUT by default, if the range server returns method not
+// allowed response.  When the resulting URI is or exceeds a configured limit,
+// it prefers using the LongQueryMethod, but will re-send the query using the
+// GET method if the range server returns a Method Not Allowed.  When the
+// caller chose the method using WithMethod, it uses only that method.
+func (c *Client) query(ctx context.Context, path, expression string, callback func(io.Reader) error, server string) error {
 	var err, prevErr error
 	var request *http.Request
-	var wasGetTried, wasPutTried bool
+	var wasGetTried, wasBodyTried bool
+
+	if err := c.admitRequest(ctx, server); err != nil {
+		return err
+	}
+	defer c.releaseSlot()
 
-	endpoint := "http://" + server + "/range/list"
+	endpoint := c.endpoint(server, path)
 	escaped := url.QueryEscape(expression)
 	uri := endpoint + "?" + escaped
 
+	if c.validateQueries {
+		if err := validateQuery(expression, uri); err != nil {
+			return err
+		}
+	}
+
 	// Default to using GET method because most servers support it. However, use
-	// PUT method when extremely long query length.
-	var method string
-	if len(uri) > defaultQueryURILengthThreshold {
-		method = http.MethodPut
+	// PUT or POST method when extremely long query length.
+	limits := c.serverLimits[server]
+	threshold := c.queryLengthThreshold
+	if limits.MaxURILength > 0 {
+		threshold = limits.MaxURILength
+	}
+	method, forced := ctx.Value(methodKey{}).(string)
+	if forced {
+		// The caller chose the method, so send the query using exactly that
+		// method, without falling back to another.
+	} else if len(uri) > threshold {
+		if c.rejectLongQueries || c.disablePut {
+			return ErrURITooLong{Length: len(uri), Threshold: threshold, PutDisabled: c.disablePut}
+		}
+		method = c.longQueryMethod
 	} else {
 		method = http.MethodGet
 	}
@@ -298,34 +1161,60 @@ func (c *Client) query(ctx context.Context, expression string, callback func(io.
 
 			request, err = http.NewRequest(method, uri, nil)
 			if err != nil {
-				method = http.MethodPut // try again using PUT
+				if c.disablePut || forced {
+					return err
+				}
+				method = c.longQueryMethod // try again using PUT or POST
 				prevErr = err
 				continue
 			}
-		case http.MethodPut:
-			if wasPutTried {
+		case http.MethodPut, http.MethodPost:
+			if wasBodyTried {
 				return prevErr
 			}
-			wasPutTried = true
+			wasBodyTried = true
 
-			request, err = http.NewRequest(method, endpoint, strings.NewReader("query="+escaped))
+			body := "query=" + escaped + c.extraFormFields
+			if limits.MaxBodySize > 0 && len(body) > limits.MaxBodySize {
+				return ErrRequestTooLarge{Length: len(body), Limit: limits.MaxBodySize}
+			}
+			var reader io.Reader = strings.NewReader(body)
+			compressed := c.gzipLongQueries && len(body) >= c.gzipLongQueryThreshold
+			if compressed {
+				// Compress the entire body before sending it, so the request
+				// has a Content-Length and may be sent again when redirected.
+				buf, err := gzipBytes(body)
+				if err != nil {
+					return err
+				}
+				reader = bytes.NewReader(buf)
+			}
+			request, err = http.NewRequest(method, endpoint, reader)
 			if err != nil {
+				if forced {
+					return err
+				}
 				method = http.MethodGet // try again using GET
 				prevErr = err
 				continue
 			}
 			request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			if compressed {
+				request.Header.Set("Content-Encoding", "gzip")
+			}
 		default:
 			panic(fmt.Errorf("this library should not have specified unsupported HTTP method: %q", method))
 		}
 
-		// Set the user agent so servers have more information about their clients
-		if c.userAgent != "" {
-			request.Header.Set("User-Agent", c.userAgent)
-		}
+		c.prepareRequest(ctx, request)
 
-		// Attach the context and dispatch the request.
-		response, err := c.httpClient.Do(request.WithContext(ctx))
+		// Attach the context, and dispatch the request after the middleware
+		// have seen it.
+		request = request.WithContext(ctx)
+		if err := c.runMiddleware(request); err != nil {
+			return err
+		}
+		response, err := c.httpClient.Do(request)
 		if err != nil {
 			return err
 		}
@@ -334,41 +1223,66 @@ func (c *Client) query(ctx context.Context, expression string, callback func(io.
 		// condition encoded in the response.
 		if response.StatusCode == http.StatusOK {
 			if message := response.Header.Get("RangeException"); message != "" {
-				return ErrRangeException{Message: message}
+				return newRangeException(message)
+			}
+			if !claimResponse(ctx) {
+				_ = response.Body.Close() // another hedged request already won
+				return errHedgeLost
 			}
 			//
 			// NORMAL EXIT PATH: range server provided non-error response
 			//
-			prevErr = callback(response.Body)
-			err = discard(response.Body)
+			recordContentType(ctx, response.Header.Get("Content-Type"))
+			decoded, err := c.decodeBody(response)
+			if err != nil {
+				_ = discard(response.Body)
+				return err
+			}
+			var body io.Reader = decoded
+			if c.maxResponseSize > 0 {
+				// Read one byte beyond the limit to detect an oversized body,
+				// which is limited after it is decompressed.
+				body = &sizeLimitedReader{r: io.LimitReader(decoded, c.maxResponseSize+1), limit: c.maxResponseSize}
+			}
+			if c.responseTransform != nil {
+				prevErr = c.transformResponse(body, callback)
+			} else {
+				prevErr = callback(body)
+			}
+			_ = decoded.Close()
+			if l, ok := body.(*sizeLimitedReader); ok && l.read > l.limit {
+				_ = response.Body.Close() // do not drain the remainder of an oversized body
+				if isStreamError(prevErr) {
+					return prevErr // values were already delivered
+				}
+				return ErrResponseTooLarge{Limit: l.limit}
+			}
 			if prevErr != nil {
+				_ = response.Body.Close() // do not drain the remainder of a body the callback abandoned
 				return prevErr
 			}
-			return err
+			return discard(response.Body)
 		}
 
-		switch response.StatusCode {
-		case http.StatusRequestURITooLong:
-			if wasPutTried {
+		switch {
+		case forced:
+			return c.statusNotOK(response)
+		case response.StatusCode == http.StatusRequestURITooLong:
+			if c.rejectLongQueries || c.disablePut {
+				_ = discard(response.Body)
+				return ErrURITooLong{Length: len(uri), PutDisabled: c.disablePut}
+			}
+			if wasBodyTried {
 				return prevErr
 			}
-			method = http.MethodPut // try again using PUT
-		case http.StatusMethodNotAllowed:
+			method = c.longQueryMethod // try again using PUT or POST
+		case response.StatusCode == http.StatusMethodNotAllowed:
 			if wasGetTried {
 				return prevErr
 			}
 			method = http.MethodGet // try again using GET
 		default:
-			e := ErrStatusNotOK{
-				Status:     response.Status,
-				StatusCode: response.StatusCode,
-			}
-			// Read response body and return its text in the error.
-			buf, err := bytesFromReadCloser(response.Body)
-			if l := len(buf); err == nil && l > 0 {
-				e.Body = buf
-			}
-			return e
+			return c.statusNotOK(response)
 		}
 
 		// Another attempt is warranted, so discard response body from this attempt,
@@ -386,6 +1300,108 @@ func (c *Client) query(ctx context.Context, expression string, callback func(io.
 	}
 }
 
+// statusNotOK returns ErrStatusNotOK for the unsuccessful response, including
+// the text of its body, and closes its body.
+func (c *Client) statusNotOK(response *http.Response) error {
+	e := ErrStatusNotOK{
+		Status:     response.Status,
+		StatusCode: response.StatusCode,
+	}
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		e.RetryAfter = parseRetryAfter(response.Header.Get("Retry-After"), c.clock.Now())
+	}
+	// Read response body and return its text in the error.
+	buf, err := c.readBody(response, MaxErrorBodySize)
+	if l := len(buf); err == nil && l > 0 {
+		e.Body = buf
+		if c.canonicalizeHTMLErrors && response.StatusCode >= 500 {
+			e.Message = summarizeHTML(response.Header.Get("Content-Type"), buf)
+		}
+	}
+	return e
+}
+
+// prepareRequest adds the configured headers, any query metadata attached to
+// the context, credentials, the user agent, and the Host override to request.
+func (c *Client) prepareRequest(ctx context.Context, request *http.Request) {
+	// Add the configured headers, but do not override headers the library
+	// already set on the request.
+	for key, values := range c.headers {
+		if _, ok := request.Header[key]; !ok {
+			request.Header[key] = append([]string(nil), values...)
+		}
+	}
+
+	// Add any query metadata the caller attached to the context, and the key
+	// and correlation ID shared by every attempt to send this query.
+	setMetadataHeaders(ctx, request)
+	c.setIdempotencyKeyHeader(ctx, request)
+	c.setCorrelationIDHeader(ctx, request)
+	c.setAcceptHeader(request)
+	c.setAcceptEncodingHeader(request)
+
+	// Set credentials for servers behind an authenticating proxy.
+	if c.bearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	} else if c.basicAuthUsername != "" || c.basicAuthPassword != "" {
+		request.SetBasicAuth(c.basicAuthUsername, c.basicAuthPassword)
+	}
+
+	// Set the user agent so servers have more information about their clients
+	if c.userAgent != "" {
+		request.Header.Set("User-Agent", c.userAgent)
+	}
+
+	// Address the virtual host, while still connecting to the server.
+	if c.hostHeader != "" {
+		request.Host = c.hostHeader
+	}
+}
+
+// validateQuery returns ErrInvalidQuery when expression contains characters no
+// range expression legitimately contains, or when the URI built from it either
+// does not parse or does not decode back to the original expression.
+func validateQuery(expression, uri string) error {
+	if !utf8.ValidString(expression) {
+		return ErrInvalidQuery{Expression: expression, Reason: "expression is not valid UTF-8"}
+	}
+	for i, r := range expression {
+		if unicode.IsControl(r) {
+			return ErrInvalidQuery{Expression: expression, Reason: fmt.Sprintf("expression has control character %U at offset %d", r, i)}
+		}
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ErrInvalidQuery{Expression: expression, Reason: err.Error()}
+	}
+	decoded, err := url.QueryUnescape(u.RawQuery)
+	if err != nil {
+		return ErrInvalidQuery{Expression: expression, Reason: err.Error()}
+	}
+	if decoded != expression {
+		return ErrInvalidQuery{Expression: expression, Reason: fmt.Sprintf("URI decodes to %q", decoded)}
+	}
+	return nil
+}
+
+// sizeLimitedReader returns ErrResponseTooLarge once more than limit bytes
+// have been read from r, which should be limited to one byte beyond limit.
+type sizeLimitedReader struct {
+	r     io.Reader
+	read  int64
+	limit int64
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n - int(l.read-l.limit), ErrResponseTooLarge{Limit: l.limit}
+	}
+	return n, err
+}
+
 func bytesFromReadCloser(iorc io.ReadCloser) ([]byte, error) {
 	buf, err1 := ioutil.ReadAll(iorc)
 	err2 := iorc.Close()
@@ -0,0 +1,70 @@
+package orange
+
+import "time"
+
+// Option changes the configuration of a client created by New.
+type Option func(*Config)
+
+// New returns a new instance that sends queries to one or more range servers,
+// configured by the provided options.  It is a convenience for the common
+// case, so a client may be created without building a Config.  Options are
+// applied in order to an empty Config, which is then validated just as
+// NewClient validates it, so an option provided more than once overrides the
+// earlier ones, except for WithServers, which appends.
+//
+//     client, err := orange.New(
+//         orange.WithServers("range.example.com"),
+//         orange.WithRetry(2, time.Second),
+//     )
+//     if err != nil {
+//         return err
+//     }
+func New(opts ...Option) (*Client, error) {
+	config := new(Config)
+	for _, opt := range opts {
+		opt(config)
+	}
+	return NewClient(config)
+}
+
+// WithConfig applies the function to the configuration being built by New, so
+// any setting of Config may be changed, including those without an Option of
+// their own.
+//
+//     client, err := orange.New(
+//         orange.WithServers("range.example.com"),
+//         orange.WithConfig(func(config *orange.Config) {
+//             config.SortResults = true
+//         }),
+//     )
+func WithConfig(f func(*Config)) Option {
+	return f
+}
+
+// WithHTTPClient causes the client to send queries using the provided Doer,
+// rather than the HTTP client it creates by default.  See Config.HTTPClient.
+func WithHTTPClient(doer Doer) Option {
+	return func(config *Config) { config.HTTPClient = doer }
+}
+
+// WithRetry causes each failed query to be retried up to count times, pausing
+// for the specified duration before each retry.  See Config.RetryCount and
+// Config.RetryPause.
+func WithRetry(count int, pause time.Duration) Option {
+	return func(config *Config) {
+		config.RetryCount = count
+		config.RetryPause = pause
+	}
+}
+
+// WithServers adds the range server addresses to those the client sends
+// queries to.  See Config.Servers.
+func WithServers(servers ...string) Option {
+	return func(config *Config) { config.Servers = append(config.Servers, servers...) }
+}
+
+// WithTimeout sets the time limit for each query sent using the HTTP client
+// the client creates by default.  See Config.Timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(config *Config) { config.Timeout = timeout }
+}
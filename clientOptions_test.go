@@ -0,0 +1,83 @@
+package orange
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("without servers", func(t *testing.T) {
+		_, err := New()
+		ensureError(t, err, "without at least one range server")
+	})
+
+	t.Run("negative timeout", func(t *testing.T) {
+		_, err := New(WithServers("range.example.com"), WithTimeout(-time.Second))
+		ensureError(t, err, "negative Timeout")
+	})
+
+	t.Run("negative retry count", func(t *testing.T) {
+		_, err := New(WithServers("range.example.com"), WithRetry(-1, 0))
+		ensureError(t, err, "negative RetryCount")
+	})
+
+	t.Run("servers appended", func(t *testing.T) {
+		client, err := New(WithServers("range1.example.com"), WithServers("range2.example.com", "range3.example.com"))
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, client.Servers(), []string{"range1.example.com", "range2.example.com", "range3.example.com"})
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		client, err := New(WithServers("range.example.com"), WithTimeout(time.Second))
+		ensureError(t, err)
+		hc, ok := client.httpClient.(*http.Client)
+		if !ok {
+			t.Fatalf("GOT: %T; WANT: %T", client.httpClient, hc)
+		}
+		if got, want := hc.Timeout, time.Second; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("default timeout", func(t *testing.T) {
+		client, err := New(WithServers("range.example.com"))
+		ensureError(t, err)
+		if got, want := client.httpClient.(*http.Client).Timeout, DefaultQueryTimeout; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("queries", func(t *testing.T) {
+		var requests int32
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) < 3 {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("result1\nresult2\n"))
+		}
+		withTestServer(t, h, func(server *httptest.Server) {
+			client, err := New(
+				WithServers(strings.TrimPrefix(server.URL, "http://")),
+				WithHTTPClient(server.Client()),
+				WithRetry(2, time.Millisecond),
+				WithConfig(func(config *Config) {
+					config.RetryCallback = func(error) bool { return true }
+				}),
+			)
+			ensureError(t, err)
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			if got, want := strings.Join(values, ","), "result1,result2"; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := atomic.LoadInt32(&requests), int32(3); got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+}
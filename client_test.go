@@ -2,9 +2,14 @@ package orange
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -16,13 +21,23 @@ func withTestServer(tb testing.TB, h func(w http.ResponseWriter, r *http.Request
 }
 
 func withClient(tb testing.TB, h func(w http.ResponseWriter, r *http.Request), callback func(*Client)) {
+	withConfiguredClient(tb, h, nil, callback)
+}
+
+// withConfiguredClient is like withClient, but invokes configure, when not nil,
+// to modify the Config prior to creating the Client.
+func withConfiguredClient(tb testing.TB, h func(w http.ResponseWriter, r *http.Request), configure func(*Config), callback func(*Client)) {
 	withTestServer(tb, h, func(server *httptest.Server) {
-		client, err := NewClient(&Config{
+		config := &Config{
 			HTTPClient: server.Client(),
 			RetryCount: 2,
 			Servers:    []string{strings.TrimLeft(server.URL, "http://")},
 			UserAgent:  "custom-user-agent",
-		})
+		}
+		if configure != nil {
+			configure(config)
+		}
+		client, err := NewClient(config)
 		if err != nil {
 			tb.Fatal(err)
 		}
@@ -92,6 +107,82 @@ func TestClient(t *testing.T) {
 				}
 			})
 		})
+		t.Run("PUT with extra form fields", func(t *testing.T) {
+			// Force initial use of PUT by creating very long query.
+			var expression strings.Builder
+			for i := 0; i < defaultQueryURILengthThreshold; i++ {
+				expression.WriteString("{")
+			}
+
+			h := func(w http.ResponseWriter, r *http.Request) {
+				if got, want := r.Method, http.MethodPut; got != want {
+					t.Errorf("GOT: %v; WANT: %v", got, want)
+				}
+				if err := r.ParseForm(); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := r.PostForm["query"], []string{expression.String()}; len(got) != 1 || got[0] != want[0] {
+					t.Errorf("GOT: %v; WANT: %v", len(got), len(want))
+				}
+				if got, want := r.PostForm.Get("caller"), "deploy-tool"; got != want {
+					t.Errorf("GOT: %v; WANT: %v", got, want)
+				}
+				if got, want := r.PostForm.Get("reason"), "rolling restart"; got != want {
+					t.Errorf("GOT: %v; WANT: %v", got, want)
+				}
+			}
+			configure := func(config *Config) {
+				config.ExtraFormFields = map[string]string{
+					"caller": "deploy-tool",
+					"query":  "should be ignored",
+					"reason": "rolling restart",
+				}
+			}
+			withConfiguredClient(t, h, configure, func(client *Client) {
+				_, err := client.Query(expression.String())
+				if err != nil {
+					t.Fatal(err)
+				}
+			})
+		})
+		t.Run("custom headers", func(t *testing.T) {
+			var expression strings.Builder
+			for i := 0; i < defaultQueryURILengthThreshold; i++ {
+				expression.WriteString(".")
+			}
+
+			h := func(w http.ResponseWriter, r *http.Request) {
+				if got, want := r.Header.Get("X-Auth-Token"), "secret"; got != want {
+					t.Errorf("GOT: %v; WANT: %v", got, want)
+				}
+				if got, want := r.Header.Get("X-Client-Name"), "test"; got != want {
+					t.Errorf("GOT: %v; WANT: %v", got, want)
+				}
+				if got, want := r.Header.Get("User-Agent"), "custom-user-agent"; got != want {
+					t.Errorf("GOT: %v; WANT: %v", got, want)
+				}
+				if r.Method == http.MethodPut {
+					if got, want := r.Header.Get("Content-Type"), "application/x-www-form-urlencoded"; got != want {
+						t.Errorf("GOT: %v; WANT: %v", got, want)
+					}
+				}
+			}
+			configure := func(config *Config) {
+				config.Headers = http.Header{
+					"x-auth-token":  []string{"secret"},
+					"X-Client-Name": []string{"test"},
+					"Content-Type":  []string{"text/plain"},
+					"User-Agent":    []string{"overridden"},
+				}
+			}
+			withConfiguredClient(t, h, configure, func(client *Client) {
+				for _, expression := range []string{"foo", expression.String()} {
+					if _, err := client.Query(expression); err != nil {
+						t.Fatal(err)
+					}
+				}
+			})
+		})
 	})
 	t.Run("normal", func(t *testing.T) {
 		t.Run("empty", func(t *testing.T) {
@@ -118,7 +209,7 @@ func TestClient(t *testing.T) {
 					if err != nil {
 						t.Fatal(err)
 					}
-					ensureStringSlicesMatch(t, values, []string{""})
+					ensureStringSlicesMatch(t, values, nil)
 				})
 			})
 		})
@@ -252,9 +343,8 @@ func TestClient(t *testing.T) {
 				response, err := client.Query("foo")
 				switch v := err.(type) {
 				case ErrStatusNotOK:
-					ensureError(t, err, http.StatusText(e))
-					if got, avoid := err.Error(), "body"; strings.Contains(got, avoid) {
-						t.Errorf("GOT: %v; AVOID: %v", got, avoid)
+					if got, want := err.Error(), "502 Bad Gateway: body1 body2"; got != want {
+						t.Errorf("GOT: %v; WANT: %v", got, want)
 					}
 					ensureStringSlicesMatch(t, lines(v.Body), []string{"body1", "body2"})
 				default:
@@ -264,6 +354,50 @@ func TestClient(t *testing.T) {
 			})
 		})
 
+		t.Run("not ok with HTML body", func(t *testing.T) {
+			const page = "<!DOCTYPE html>\n<html>\n<head>\n<title>502 Bad Gateway</title>\n</head>\n<body>\n<center><h1>502 Bad Gateway</h1></center>\n<hr><center>nginx</center>\n</body>\n</html>\n"
+			h := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(http.StatusBadGateway)
+				w.Write([]byte(page))
+			}
+
+			t.Run("disabled", func(t *testing.T) {
+				withClient(t, h, func(client *Client) {
+					_, err := client.Query("foo")
+					switch v := err.(type) {
+					case ErrStatusNotOK:
+						if got, want := v.Message, ""; got != want {
+							t.Errorf("GOT: %q; WANT: %q", got, want)
+						}
+						if got, want := string(v.Body), page; got != want {
+							t.Errorf("GOT: %q; WANT: %q", got, want)
+						}
+					default:
+						t.Errorf("GOT: %T; WANT: %T", err, ErrStatusNotOK{})
+					}
+				})
+			})
+
+			t.Run("enabled", func(t *testing.T) {
+				configure := func(config *Config) { config.CanonicalizeHTMLErrors = true }
+				withConfiguredClient(t, h, configure, func(client *Client) {
+					_, err := client.Query("foo")
+					switch v := err.(type) {
+					case ErrStatusNotOK:
+						if got, want := v.Message, "502 Bad Gateway"; got != want {
+							t.Errorf("GOT: %q; WANT: %q", got, want)
+						}
+						if got, want := err.Error(), "502 Bad Gateway: 502 Bad Gateway"; got != want {
+							t.Errorf("GOT: %q; WANT: %q", got, want)
+						}
+					default:
+						t.Errorf("GOT: %T; WANT: %T", err, ErrStatusNotOK{})
+					}
+				})
+			})
+		})
+
 		t.Run("not ok with carriage returns", func(t *testing.T) {
 			e := http.StatusBadGateway
 			h := func(w http.ResponseWriter, r *http.Request) {
@@ -274,9 +408,8 @@ func TestClient(t *testing.T) {
 				response, err := client.Query("foo")
 				switch v := err.(type) {
 				case ErrStatusNotOK:
-					ensureError(t, err, http.StatusText(e))
-					if got, avoid := err.Error(), "body"; strings.Contains(got, avoid) {
-						t.Errorf("GOT: %v; AVOID: %v", got, avoid)
+					if got, want := err.Error(), "502 Bad Gateway: body1 body2"; got != want {
+						t.Errorf("GOT: %v; WANT: %v", got, want)
 					}
 					ensureStringSlicesMatch(t, lines(v.Body), []string{"body1", "body2"})
 				default:
@@ -287,6 +420,128 @@ func TestClient(t *testing.T) {
 		})
 	})
 
+	t.Run("validates queries", func(t *testing.T) {
+		var invocationCount int32
+
+		h := func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&invocationCount, 1)
+			w.Write([]byte("result1\n"))
+		}
+		configure := func(config *Config) { config.ValidateQueries = true }
+
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			for _, expression := range []string{"foo\x00bar", "foo\nbar", "\x7f", "foo\xffbar"} {
+				_, err := client.Query(expression)
+				switch e := err.(type) {
+				case ErrInvalidQuery:
+					if got, want := e.Expression, expression; got != want {
+						t.Errorf("GOT: %q; WANT: %q", got, want)
+					}
+					ensureError(t, err, "invalid query")
+				default:
+					t.Errorf("%q: GOT: %T; WANT: %T", expression, err, ErrInvalidQuery{})
+				}
+			}
+
+			values, err := client.Query("%foo & /^bar\\d+$/,{baz}")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1"})
+		})
+
+		if got, want := atomic.LoadInt32(&invocationCount), int32(1); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("rejects long queries", func(t *testing.T) {
+		t.Run("over threshold", func(t *testing.T) {
+			var invocationCount int32
+
+			h := func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&invocationCount, 1)
+			}
+			configure := func(config *Config) { config.RejectLongQueries = true }
+
+			withConfiguredClient(t, h, configure, func(client *Client) {
+				var expression strings.Builder
+				for i := 0; i < defaultQueryURILengthThreshold; i++ {
+					expression.WriteString(".")
+				}
+
+				_, err := client.Query(expression.String())
+				switch e := err.(type) {
+				case ErrURITooLong:
+					if got, want := e.Threshold, defaultQueryURILengthThreshold; got != want {
+						t.Errorf("GOT: %v; WANT: %v", got, want)
+					}
+				default:
+					t.Errorf("GOT: %T; WANT: %T", err, ErrURITooLong{})
+				}
+			})
+
+			if got, want := atomic.LoadInt32(&invocationCount), int32(0); got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+		t.Run("server returns uri too long", func(t *testing.T) {
+			var getInvocationCount, putInvocationCount int32
+
+			h := func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					atomic.AddInt32(&getInvocationCount, 1)
+				case http.MethodPut:
+					atomic.AddInt32(&putInvocationCount, 1)
+				}
+				http.Error(w, r.RequestURI, http.StatusRequestURITooLong)
+			}
+			configure := func(config *Config) { config.RejectLongQueries = true }
+
+			withConfiguredClient(t, h, configure, func(client *Client) {
+				_, err := client.Query("foo")
+				if _, ok := err.(ErrURITooLong); !ok {
+					t.Errorf("GOT: %T; WANT: %T", err, ErrURITooLong{})
+				}
+			})
+
+			if got, want := atomic.LoadInt32(&getInvocationCount), int32(1); got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := atomic.LoadInt32(&putInvocationCount), int32(0); got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+
+	t.Run("limits response size", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1\nresult2\n"))
+		}
+		t.Run("within limit", func(t *testing.T) {
+			configure := func(config *Config) { config.MaxResponseSize = 16 }
+			withConfiguredClient(t, h, configure, func(client *Client) {
+				values, err := client.Query("foo")
+				ensureError(t, err)
+				ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+			})
+		})
+		t.Run("over limit", func(t *testing.T) {
+			configure := func(config *Config) { config.MaxResponseSize = 15 }
+			withConfiguredClient(t, h, configure, func(client *Client) {
+				values, err := client.Query("foo")
+				switch e := err.(type) {
+				case ErrResponseTooLarge:
+					if got, want := e.Limit, int64(15); got != want {
+						t.Errorf("GOT: %v; WANT: %v", got, want)
+					}
+				default:
+					t.Errorf("GOT: %T; WANT: %T", err, ErrResponseTooLarge{})
+				}
+				ensureStringSlicesMatch(t, values, nil)
+			})
+		})
+	})
+
 	t.Run("retries query", func(t *testing.T) {
 		t.Run("with PUT when server returns uri too long", func(t *testing.T) {
 			var getInvocationCount, putInvocationCount int
@@ -464,3 +719,805 @@ func BenchmarkLineSplitting(b *testing.B) {
 		})
 	})
 }
+
+func TestClientQueryWithServer(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("result1\nresult2\n"))
+	}))
+	defer working.Close()
+
+	workingAddress := strings.TrimLeft(working.URL, "http://")
+
+	client, err := NewClient(&Config{
+		RetryCallback: func(error) bool { return true },
+		RetryCount:    1,
+		Servers:       []string{strings.TrimLeft(failing.URL, "http://"), workingAddress},
+	})
+	ensureError(t, err)
+
+	values, server, err := client.QueryWithServer("foo")
+	ensureError(t, err)
+	ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+
+	if got, want := server, workingAddress; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
+
+func TestClientTryAllServers(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	deadAddress := strings.TrimLeft(dead.URL, "http://")
+	dead.Close() // connections to this address will be refused
+
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("result1\nresult2\n"))
+	}))
+	defer working.Close()
+
+	workingAddress := strings.TrimLeft(working.URL, "http://")
+
+	t.Run("disabled", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			Servers: []string{deadAddress, workingAddress},
+		})
+		ensureError(t, err)
+
+		_, err = client.Query("foo")
+		ensureError(t, err, "refused")
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			Servers:       []string{deadAddress, workingAddress},
+			TryAllServers: true,
+		})
+		ensureError(t, err)
+
+		values, server, err := client.QueryWithServer("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+
+		if got, want := server, workingAddress; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("range exception not sent to other servers", func(t *testing.T) {
+		var invocations int32
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&invocations, 1)
+			w.Header().Set("RangeException", "some error")
+		})
+		first := httptest.NewServer(h)
+		defer first.Close()
+		second := httptest.NewServer(h)
+		defer second.Close()
+
+		client, err := NewClient(&Config{
+			Servers:       []string{strings.TrimLeft(first.URL, "http://"), strings.TrimLeft(second.URL, "http://")},
+			TryAllServers: true,
+		})
+		ensureError(t, err)
+
+		_, err = client.Query("foo")
+		ensureError(t, err, "some error")
+
+		if got, want := atomic.LoadInt32(&invocations), int32(1); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
+
+func TestClientMaxServersPerQuery(t *testing.T) {
+	var lock sync.Mutex
+	hits := make(map[string]int)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits[r.Host]++
+		lock.Unlock()
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	})
+
+	var servers []string
+	for i := 0; i < 10; i++ {
+		server := httptest.NewServer(h)
+		defer server.Close()
+		servers = append(servers, strings.TrimLeft(server.URL, "http://"))
+	}
+
+	ensureHits := func(t *testing.T, wantServers, wantQueries int) {
+		t.Helper()
+		lock.Lock()
+		defer lock.Unlock()
+		var queries int
+		for _, n := range hits {
+			queries += n
+		}
+		if got, want := len(hits), wantServers; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := queries, wantQueries; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		hits = make(map[string]int)
+	}
+
+	t.Run("negative", func(t *testing.T) {
+		_, err := NewClient(&Config{Servers: servers, MaxServersPerQuery: -1})
+		ensureError(t, err, "negative MaxServersPerQuery")
+	})
+
+	t.Run("retries", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			Servers:            servers,
+			MaxServersPerQuery: 3,
+			RetryCallback:      func(error) bool { return true },
+			RetryCount:         9,
+		})
+		ensureError(t, err)
+
+		_, err = client.Query("foo")
+		ensureError(t, err, "Service Unavailable")
+		ensureHits(t, 3, 3)
+	})
+
+	t.Run("try all servers", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			Servers:            servers,
+			MaxServersPerQuery: 4,
+			RetryCallback:      func(error) bool { return true },
+			RetryCount:         2,
+			TryAllServers:      true,
+		})
+		ensureError(t, err)
+
+		_, err = client.Query("foo")
+		ensureError(t, err, "Service Unavailable")
+
+		// Each attempt starts at a different server, so later attempts stop
+		// at the first server not already tried.
+		lock.Lock()
+		defer lock.Unlock()
+		if got, want := len(hits), 4; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		hits = make(map[string]int)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			Servers:       servers,
+			RetryCallback: func(error) bool { return true },
+			RetryCount:    9,
+		})
+		ensureError(t, err)
+
+		_, err = client.Query("foo")
+		ensureError(t, err, "Service Unavailable")
+		ensureHits(t, 10, 10)
+	})
+}
+
+func TestClientQueryLengthThreshold(t *testing.T) {
+	var method string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		w.Write([]byte("result\n"))
+	}
+
+	t.Run("negative", func(t *testing.T) {
+		_, err := NewClient(&Config{Servers: []string{"localhost"}, QueryLengthThreshold: -1})
+		ensureError(t, err, "negative QueryLengthThreshold")
+	})
+
+	t.Run("lower", func(t *testing.T) {
+		configure := func(config *Config) { config.QueryLengthThreshold = 64 }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query(strings.Repeat("x", 100))
+			ensureError(t, err)
+			if got, want := method, http.MethodPut; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+
+	t.Run("higher", func(t *testing.T) {
+		configure := func(config *Config) { config.QueryLengthThreshold = 2 * defaultQueryURILengthThreshold }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query(strings.Repeat("x", defaultQueryURILengthThreshold))
+			ensureError(t, err)
+			if got, want := method, http.MethodGet; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+
+	t.Run("default", func(t *testing.T) {
+		withClient(t, h, func(client *Client) {
+			_, err := client.Query(strings.Repeat("x", defaultQueryURILengthThreshold))
+			ensureError(t, err)
+			if got, want := method, http.MethodPut; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+}
+
+func TestClientDisablePut(t *testing.T) {
+	var lock sync.Mutex
+	methods := make(map[string]int)
+	record := func(r *http.Request) {
+		lock.Lock()
+		methods[r.Method]++
+		lock.Unlock()
+	}
+	ensureNoPut := func(t *testing.T) {
+		t.Helper()
+		lock.Lock()
+		defer lock.Unlock()
+		if got, want := methods[http.MethodPut], 0; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	}
+	configure := func(config *Config) { config.DisablePut = true }
+
+	t.Run("over threshold", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			record(r)
+			w.Write([]byte("result\n"))
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query(strings.Repeat("x", defaultQueryURILengthThreshold))
+			e, ok := err.(ErrURITooLong)
+			if !ok {
+				t.Fatalf("GOT: %T; WANT: %T", err, e)
+			}
+			if got, want := e.PutDisabled, true; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			ensureError(t, err, "GET-only mode")
+
+			_, err = client.Query("short")
+			ensureError(t, err)
+		})
+		ensureNoPut(t)
+	})
+
+	t.Run("server returns uri too long", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			record(r)
+			http.Error(w, "too long", http.StatusRequestURITooLong)
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query("foo")
+			ensureError(t, err, "rejected by server, and the query cannot be sent using PUT")
+		})
+		ensureNoPut(t)
+	})
+}
+
+func TestClientLongQueryMethod(t *testing.T) {
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := NewClient(&Config{Servers: []string{"localhost"}, LongQueryMethod: http.MethodPatch})
+		ensureError(t, err, "unsupported LongQueryMethod")
+	})
+
+	t.Run("post", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				http.Error(w, "too long", http.StatusRequestURITooLong)
+			case http.MethodPost:
+				if got, want := r.Header.Get("Content-Type"), "application/x-www-form-urlencoded"; got != want {
+					t.Errorf("GOT: %v; WANT: %v", got, want)
+				}
+				if err := r.ParseForm(); err != nil {
+					t.Fatal(err)
+				}
+				w.Write([]byte(r.PostForm.Get("query") + "\n"))
+			default:
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		}
+		configure := func(config *Config) { config.LongQueryMethod = "post" }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			// Sent using GET first, then POST after the server rejects the URI.
+			values, err := client.Query("short")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"short"})
+
+			// Sent using POST without trying GET first.
+			long := strings.Repeat("x", defaultQueryURILengthThreshold)
+			values, err = client.Query(long)
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{long})
+		})
+	})
+}
+
+func TestClientServerLimits(t *testing.T) {
+	var lock sync.Mutex
+	methods := make(map[string]string)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		methods[r.Host] = r.Method
+		lock.Unlock()
+		w.Write([]byte("result\n"))
+	})
+	small := httptest.NewServer(h)
+	defer small.Close()
+	large := httptest.NewServer(h)
+	defer large.Close()
+
+	smallAddress := strings.TrimLeft(small.URL, "http://")
+	largeAddress := strings.TrimLeft(large.URL, "http://")
+
+	ensureMethod := func(t *testing.T, server, want string) {
+		t.Helper()
+		lock.Lock()
+		defer lock.Unlock()
+		if got := methods[server]; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	}
+
+	t.Run("negative", func(t *testing.T) {
+		_, err := NewClient(&Config{
+			Servers:      []string{smallAddress},
+			ServerLimits: map[string]ServerLimit{smallAddress: {MaxBodySize: -1}},
+		})
+		ensureError(t, err, "negative MaxBodySize")
+	})
+
+	t.Run("method per server", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			Servers: []string{smallAddress, largeAddress},
+			ServerLimits: map[string]ServerLimit{
+				smallAddress: {MaxURILength: 64},
+				largeAddress: {MaxURILength: 1024},
+			},
+		})
+		ensureError(t, err)
+
+		query := strings.Repeat("x", 100)
+		for i := 0; i < 2; i++ {
+			_, err = client.Query(query)
+			ensureError(t, err)
+		}
+		ensureMethod(t, smallAddress, http.MethodPut)
+		ensureMethod(t, largeAddress, http.MethodGet)
+	})
+
+	t.Run("reject per server", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			RejectLongQueries: true,
+			Servers:           []string{smallAddress},
+			ServerLimits:      map[string]ServerLimit{smallAddress: {MaxURILength: 64}},
+		})
+		ensureError(t, err)
+
+		_, err = client.Query(strings.Repeat("x", 100))
+		if e, ok := err.(ErrURITooLong); !ok || e.Threshold != 64 {
+			t.Errorf("GOT: %v; WANT: %v", err, ErrURITooLong{Threshold: 64})
+		}
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			Servers:       []string{smallAddress, largeAddress},
+			ServerLimits:  map[string]ServerLimit{smallAddress: {MaxURILength: 64, MaxBodySize: 64}},
+			TryAllServers: true,
+		})
+		ensureError(t, err)
+
+		lock.Lock()
+		methods = make(map[string]string)
+		lock.Unlock()
+
+		// The small server cannot accept the query, so it is only sent to the
+		// large server.
+		values, server, err := client.QueryWithServer(strings.Repeat("x", 100))
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result"})
+		if got, want := server, largeAddress; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		ensureMethod(t, smallAddress, "")
+		ensureMethod(t, largeAddress, http.MethodGet)
+
+		client, err = NewClient(&Config{
+			Servers:      []string{smallAddress},
+			ServerLimits: map[string]ServerLimit{smallAddress: {MaxURILength: 64, MaxBodySize: 64}},
+		})
+		ensureError(t, err)
+
+		_, err = client.Query(strings.Repeat("x", 100))
+		if _, ok := err.(ErrRequestTooLarge); !ok {
+			t.Errorf("GOT: %T; WANT: %T", err, ErrRequestTooLarge{})
+		}
+		ensureError(t, err, "request too large")
+	})
+}
+
+func TestClientAuthentication(t *testing.T) {
+	const secret = "s3cr3t"
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer " + secret:
+			w.Write([]byte("bearer\n"))
+		default:
+			if username, password, ok := r.BasicAuth(); ok && username == "user" && password == secret {
+				w.Write([]byte("basic\n"))
+				return
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+	}
+
+	t.Run("bearer token", func(t *testing.T) {
+		configure := func(config *Config) { config.BearerToken = secret }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"bearer"})
+		})
+	})
+
+	t.Run("basic auth", func(t *testing.T) {
+		configure := func(config *Config) {
+			config.BasicAuthUsername = "user"
+			config.BasicAuthPassword = secret
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"basic"})
+		})
+	})
+
+	t.Run("wrong credentials are not in error", func(t *testing.T) {
+		configure := func(config *Config) { config.BearerToken = "wrong-" + secret }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query("foo")
+			ensureError(t, err, http.StatusText(http.StatusUnauthorized))
+			if got, avoid := err.Error(), secret; strings.Contains(got, avoid) {
+				t.Errorf("GOT: %v; AVOID: %v", got, avoid)
+			}
+		})
+	})
+
+	t.Run("both", func(t *testing.T) {
+		_, err := NewClient(&Config{
+			BasicAuthUsername: "user",
+			BasicAuthPassword: secret,
+			BearerToken:       secret,
+			Servers:           []string{"localhost:8081"},
+		})
+		ensureError(t, err, "BearerToken")
+		if got, avoid := err.Error(), secret; strings.Contains(got, avoid) {
+			t.Errorf("GOT: %v; AVOID: %v", got, avoid)
+		}
+	})
+}
+
+func TestClientClose(t *testing.T) {
+	t.Run("closes idle connections of its own http client", func(t *testing.T) {
+		closed := make(chan struct{}, 1)
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1\n"))
+		}))
+		server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateClosed {
+				closed <- struct{}{}
+			}
+		}
+		server.Start()
+		defer server.Close()
+
+		client, err := NewClient(&Config{Servers: []string{strings.TrimLeft(server.URL, "http://")}})
+		ensureError(t, err)
+
+		_, err = client.Query("foo")
+		ensureError(t, err)
+		ensureError(t, client.Close())
+
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("idle connection was not closed")
+		}
+	})
+
+	t.Run("ignores configured http client", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1\n"))
+		}
+		withClient(t, h, func(client *Client) {
+			if client.transport != nil {
+				t.Errorf("GOT: %v; WANT: %v", client.transport, nil)
+			}
+			ensureError(t, client.Close())
+		})
+	})
+}
+
+func TestClientDialContext(t *testing.T) {
+	client, err := NewClient(&Config{Servers: []string{"range.example.com"}})
+	ensureError(t, err)
+	defer client.Close()
+
+	if client.transport.Dial != nil {
+		t.Fatalf("GOT: %v; WANT: %v", "Dial", "DialContext")
+	}
+
+	// Simulate a dial to a server that does not answer.
+	dialed := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	client.transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed <- struct{}{}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-release:
+			return nil, errors.New("connection refused")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err = client.QueryCtx(ctx, "foo")
+	ensureError(t, err, "deadline exceeded")
+	if got, limit := time.Since(started), time.Second; got > limit {
+		t.Errorf("GOT: %v; WANT: less than %v", got, limit)
+	}
+
+	select {
+	case <-dialed:
+	default:
+		t.Fatal("GOT: no dial; WANT: dial using DialContext")
+	}
+}
+
+func TestClientTransportTuning(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		client, err := NewClient(&Config{Servers: []string{"range.example.com"}})
+		ensureError(t, err)
+		defer client.Close()
+
+		if got, want := client.transport.IdleConnTimeout, DefaultIdleConnTimeout; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := client.transport.MaxConnsPerHost, 0; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := client.transport.MaxIdleConns, DefaultMaxIdleConns; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := client.transport.MaxIdleConnsPerHost, DefaultMaxIdleConnsPerHost; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			IdleConnTimeout:     time.Minute,
+			MaxConnsPerHost:     64,
+			MaxIdleConns:        256,
+			MaxIdleConnsPerHost: 32,
+			Servers:             []string{"range.example.com"},
+		})
+		ensureError(t, err)
+		defer client.Close()
+
+		if got, want := client.transport.IdleConnTimeout, time.Minute; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := client.transport.MaxConnsPerHost, 64; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := client.transport.MaxIdleConns, 256; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := client.transport.MaxIdleConnsPerHost, 32; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		configs := map[string]*Config{
+			"IdleConnTimeout":     {IdleConnTimeout: -time.Second},
+			"MaxConnsPerHost":     {MaxConnsPerHost: -1},
+			"MaxIdleConns":        {MaxIdleConns: -1},
+			"MaxIdleConnsPerHost": {MaxIdleConnsPerHost: -1},
+		}
+		for field, config := range configs {
+			config.Servers = []string{"range.example.com"}
+			_, err := NewClient(config)
+			ensureError(t, err, "negative "+field)
+		}
+	})
+}
+
+func TestClientProxy(t *testing.T) {
+	var lock sync.Mutex
+	var targets []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		targets = append(targets, r.URL.Host)
+		lock.Unlock()
+		w.Write([]byte("result1\n"))
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	ensureError(t, err)
+
+	t.Run("proxied", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			Proxy:   http.ProxyURL(proxyURL),
+			Servers: []string{"range.example.com"},
+		})
+		ensureError(t, err)
+		defer client.Close()
+
+		values, err := client.Query("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1"})
+		ensureStringSlicesMatch(t, targets, []string{"range.example.com"})
+	})
+
+	t.Run("unix socket", func(t *testing.T) {
+		_, err := NewClient(&Config{
+			Proxy:      http.ProxyURL(proxyURL),
+			UnixSocket: "/tmp/range.sock",
+		})
+		ensureError(t, err, "Proxy and UnixSocket")
+	})
+}
+
+func TestClientExpand(t *testing.T) {
+	t.Run("GET", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if got, want := r.URL.Path, "/range/expand"; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := r.URL.RawQuery, "%25foo"; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			w.Write([]byte("result1\nresult2\n"))
+		}
+		withClient(t, h, func(client *Client) {
+			values, err := client.Expand("%foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+		})
+	})
+
+	t.Run("PUT when server returns uri too long", func(t *testing.T) {
+		var methods []string
+		h := func(w http.ResponseWriter, r *http.Request) {
+			methods = append(methods, r.Method+" "+r.URL.Path)
+			if r.Method == http.MethodGet {
+				http.Error(w, "use PUT", http.StatusRequestURITooLong)
+				return
+			}
+			w.Write([]byte("result1\n"))
+		}
+		withClient(t, h, func(client *Client) {
+			values, err := client.ExpandCtx(context.Background(), "%foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1"})
+		})
+		ensureStringSlicesMatch(t, methods, []string{"GET /range/expand", "PUT /range/expand"})
+	})
+
+	t.Run("RangeException", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("RangeException", "some error")
+		}
+		withClient(t, h, func(client *Client) {
+			_, err := client.Expand("%foo")
+			if _, ok := err.(ErrRangeException); !ok {
+				t.Errorf("GOT: %T; WANT: %T", err, ErrRangeException{})
+			}
+		})
+	})
+}
+
+func TestClientDefaultUserAgent(t *testing.T) {
+	long := strings.Repeat("{", defaultQueryURILengthThreshold)
+
+	t.Run("default", func(t *testing.T) {
+		var lock sync.Mutex
+		agents := make(map[string]string)
+		h := func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			agents[r.Method] = r.Header.Get("User-Agent")
+			lock.Unlock()
+		}
+		configure := func(config *Config) { config.UserAgent = "" }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			for _, expression := range []string{"foo", long} {
+				_, err := client.Query(expression)
+				ensureError(t, err)
+			}
+		})
+		for _, method := range []string{http.MethodGet, http.MethodPut} {
+			if got, want := agents[method], DefaultUserAgent; got != want {
+				t.Errorf("%s: GOT: %v; WANT: %v", method, got, want)
+			}
+		}
+	})
+
+	t.Run("from headers", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if got, want := r.Header.Get("User-Agent"), "from-headers"; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		}
+		configure := func(config *Config) {
+			config.Headers = http.Header{"User-Agent": []string{"from-headers"}}
+			config.UserAgent = ""
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query("foo")
+			ensureError(t, err)
+		})
+	})
+}
+
+func TestClientHostHeader(t *testing.T) {
+	long := strings.Repeat("{", defaultQueryURILengthThreshold)
+
+	t.Run("overrides host", func(t *testing.T) {
+		var lock sync.Mutex
+		hosts := make(map[string]string)
+		h := func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			hosts[r.Method] = r.Host
+			lock.Unlock()
+		}
+		withTestServer(t, h, func(server *httptest.Server) {
+			address := strings.TrimPrefix(server.URL, "http://")
+			var targets []string
+			client, err := NewClient(&Config{
+				HostHeader: "range.example.com",
+				HTTPClient: server.Client(),
+				RequestMiddleware: []func(*http.Request) error{
+					func(request *http.Request) error {
+						targets = append(targets, request.URL.Host)
+						return nil
+					},
+				},
+				Servers: []string{address},
+			})
+			ensureError(t, err)
+			for _, expression := range []string{"foo", long} {
+				_, err := client.Query(expression)
+				ensureError(t, err)
+			}
+			ensureStringSlicesMatch(t, targets, []string{address})
+		})
+		for _, method := range []string{http.MethodGet, http.MethodPut} {
+			if got, want := hosts[method], "range.example.com"; got != want {
+				t.Errorf("%s: GOT: %v; WANT: %v", method, got, want)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, host := range []string{"http://range.example.com", "range.example.com/path", "range example", "range.example.com:http"} {
+			_, err := NewClient(&Config{HostHeader: host, Servers: []string{"localhost:8081"}})
+			ensureError(t, err, "invalid HostHeader")
+		}
+	})
+}
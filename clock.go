@@ -0,0 +1,55 @@
+package orange
+
+import "time"
+
+// Clock provides the current time and the ability to wait for time to pass.
+// The client uses the system clock by default, but tests may provide their own
+// Clock via Config in order to control the passage of time deterministically.
+// The client waits for time to pass using After, so it can stop waiting when a
+// query is canceled.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After waits for the duration to elapse and then sends the current time
+	// on the returned channel.
+	After(time.Duration) <-chan time.Time
+
+	// Sleep pauses the current go-routine for at least the duration.
+	Sleep(time.Duration)
+}
+
+// systemClock is the Clock used when none is provided by the Config.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (systemClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// sleep pauses the current go-routine for at least the duration using clock,
+// unless done closes first, in which case it returns false without waiting for
+// the remainder of the duration.  It also returns false when done closes at
+// the same time the duration elapses.
+func sleep(clock Clock, done <-chan struct{}, d time.Duration) bool {
+	var elapsed <-chan time.Time
+	if _, ok := clock.(systemClock); ok {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		elapsed = timer.C
+	} else {
+		elapsed = clock.After(d)
+	}
+
+	select {
+	case <-done:
+		return false
+	case <-elapsed:
+	}
+
+	select {
+	case <-done:
+		return false
+	default:
+		return true
+	}
+}
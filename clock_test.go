@@ -0,0 +1,195 @@
+package orange
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when advanced by a test, or when
+// a go-routine sleeps, in which case the sleep returns immediately after
+// advancing the clock by the requested duration.
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	sleeps  []time.Duration
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	when time.Time
+	ch   chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- fc.now
+		return ch
+	}
+	fc.waiters = append(fc.waiters, fakeWaiter{when: fc.now.Add(d), ch: ch})
+	return ch
+}
+
+func (fc *fakeClock) Sleep(d time.Duration) {
+	fc.lock.Lock()
+	fc.sleeps = append(fc.sleeps, d)
+	fc.lock.Unlock()
+	fc.Advance(d)
+}
+
+// sleepingClock is a fakeClock on which each wait for time to pass elapses
+// immediately, after recording the duration and advancing the clock by it, as
+// though the go-routine slept.
+type sleepingClock struct {
+	*fakeClock
+}
+
+func newSleepingClock() sleepingClock {
+	return sleepingClock{fakeClock: newFakeClock()}
+}
+
+func (sc sleepingClock) After(d time.Duration) <-chan time.Time {
+	sc.Sleep(d)
+	ch := make(chan time.Time, 1)
+	ch <- sc.Now()
+	return ch
+}
+
+// Advance moves the clock forward by d, and wakes any waiters whose time has
+// come.
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.now = fc.now.Add(d)
+	remaining := fc.waiters[:0]
+	for _, w := range fc.waiters {
+		if w.when.After(fc.now) {
+			remaining = append(remaining, w)
+			continue
+		}
+		w.ch <- fc.now
+	}
+	fc.waiters = remaining
+}
+
+// Sleeps returns a copy of the durations requested by calls to Sleep.
+func (fc *fakeClock) Sleeps() []time.Duration {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return append([]time.Duration(nil), fc.sleeps...)
+}
+
+func TestFakeClock(t *testing.T) {
+	fc := newFakeClock()
+	start := fc.Now()
+
+	ch := fc.After(time.Minute)
+	fc.Advance(30 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("GOT: fired early; WANT: blocked")
+	default:
+	}
+
+	fc.Advance(30 * time.Second)
+	select {
+	case now := <-ch:
+		if got, want := now.Sub(start), time.Minute; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	default:
+		t.Fatal("GOT: blocked; WANT: fired")
+	}
+}
+
+func TestClientClock(t *testing.T) {
+	t.Run("backoff", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		}
+
+		fc := newSleepingClock()
+		start := fc.Now()
+
+		configure := func(config *Config) {
+			config.Clock = fc
+			config.RetryBackoff = true
+			config.RetryCallback = func(error) bool { return true }
+			config.RetryCount = 3
+			config.RetryPause = time.Hour
+		}
+
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query("foo")
+			ensureError(t, err, http.StatusText(http.StatusServiceUnavailable))
+		})
+
+		sleeps := fc.Sleeps()
+		want := []time.Duration{time.Hour, 2 * time.Hour, 4 * time.Hour}
+		if got, want := len(sleeps), len(want); got != want {
+			t.Fatalf("GOT: %v; WANT: %v", got, want)
+		}
+		for i := range want {
+			if got, want := sleeps[i], want[i]; got != want {
+				t.Errorf("sleep %d: GOT: %v; WANT: %v", i, got, want)
+			}
+		}
+
+		if got, want := fc.Now().Sub(start), 7*time.Hour; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
+
+func TestSleep(t *testing.T) {
+	t.Run("elapses", func(t *testing.T) {
+		done := make(chan struct{})
+		if got, want := sleep(systemClock{}, done, time.Millisecond), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		fc := newSleepingClock()
+		if got, want := sleep(fc, done, time.Hour), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := len(fc.Sleeps()), 1; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("done closes first", func(t *testing.T) {
+		done := make(chan struct{})
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(done)
+		}()
+		started := time.Now()
+		if got, want := sleep(systemClock{}, done, time.Hour), false; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if elapsed := time.Since(started); elapsed > time.Minute {
+			t.Errorf("GOT: %v; WANT: < %v", elapsed, time.Minute)
+		}
+	})
+
+	t.Run("done already closed", func(t *testing.T) {
+		done := make(chan struct{})
+		close(done)
+		if got, want := sleep(newFakeClock(), done, time.Hour), false; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
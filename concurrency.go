@@ -0,0 +1,50 @@
+package orange
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// InFlight returns the number of requests the client is currently sending to
+// range servers, so callers can observe saturation when the client is
+// configured with MaxConcurrency.
+func (c *Client) InFlight() int {
+	return int(atomic.LoadInt64(&c.inFlight))
+}
+
+// admitRequest blocks until the client's RateLimit, ServerRateLimit, and
+// MaxConcurrency allow sending another request to server, returning the
+// context's error when it closes first.  Each successful call must be followed
+// by a call to releaseSlot.
+func (c *Client) admitRequest(ctx context.Context, server string) error {
+	if err := c.waitForRateLimit(ctx); err != nil {
+		return err
+	}
+	if err := c.waitForServerBudget(ctx, server); err != nil {
+		return err
+	}
+	return c.acquireSlot(ctx)
+}
+
+// acquireSlot blocks until the client's MaxConcurrency allows sending another
+// request, returning the context's error when it closes first.  Each
+// successful call must be followed by a call to releaseSlot.
+func (c *Client) acquireSlot(ctx context.Context) error {
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	atomic.AddInt64(&c.inFlight, 1)
+	return nil
+}
+
+// releaseSlot allows another request to be sent.
+func (c *Client) releaseSlot() {
+	atomic.AddInt64(&c.inFlight, -1)
+	if c.slots != nil {
+		<-c.slots
+	}
+}
@@ -0,0 +1,69 @@
+package orange
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientMaxConcurrency(t *testing.T) {
+	var active, maxActive int32
+	arrived := make(chan struct{}, 10)
+	release := make(chan struct{})
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		arrived <- struct{}{}
+		<-release
+		atomic.AddInt32(&active, -1)
+		w.Write([]byte("result1\n"))
+	}
+	configure := func(config *Config) { config.MaxConcurrency = 2 }
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := client.Query("foo")
+				ensureError(t, err)
+			}()
+		}
+
+		<-arrived
+		<-arrived
+		if got, want := client.InFlight(), 2; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+
+		t.Run("waiting query honors context", func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			_, err := client.QueryCtx(ctx, "bar")
+			if got, want := err, context.DeadlineExceeded; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+
+		close(release)
+		wg.Wait()
+
+		if got, want := client.InFlight(), 0; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	if got, want := atomic.LoadInt32(&maxActive), int32(2); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
@@ -1,7 +1,10 @@
 package orange
 
 import (
+	"context"
+	"crypto/tls"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -9,6 +12,11 @@ import (
 // duration a query will remain in flight prior to automatic cancellation.
 const DefaultQueryTimeout = 30 * time.Second
 
+// DefaultUserAgent is sent as the User-Agent of each request when no
+// UserAgent is provided, so range servers may attribute traffic to this
+// library and its major version.
+const DefaultUserAgent = "orange/1 (+https://github.com/karrick/orange)"
+
 // DefaultDialTimeout is used when no HTTPClient is provided to control the
 // timeout for establishing a new connection.
 const DefaultDialTimeout = 5 * time.Second
@@ -21,9 +29,208 @@ const DefaultDialKeepAlive = 30 * time.Second
 // how many idle connections to keep alive per host.
 const DefaultMaxIdleConnsPerHost = 1
 
+// DefaultMaxIdleConns is used when no HTTPClient is provided to control how
+// many idle connections to keep alive across all hosts.
+const DefaultMaxIdleConns = 100
+
+// DefaultIdleConnTimeout is used when no HTTPClient is provided to control how
+// long an idle connection is kept alive before it is closed.
+const DefaultIdleConnTimeout = 90 * time.Second
+
+// DefaultMaxRedirects is used when no HTTPClient is provided to control how
+// many redirects are followed for each request, and matches the limit of the
+// default redirect policy of http.Client.
+const DefaultMaxRedirects = 10
+
 // Config provides a way to list the range server addresses, and a way to
 // override defaults when creating new http.Client instances.
 type Config struct {
+	// AcceptGzip, when true, asks range servers to compress their responses
+	// using gzip, and decompresses the responses of servers that do, which
+	// reduces the bytes transferred for large results.  MaxResponseSize limits
+	// the size of the decompressed response.  Leave false to let the
+	// http.Transport negotiate compression itself, which it only does when no
+	// HTTPClient is provided, or the provided one does not disable it.
+	AcceptGzip bool
+
+	// BasicAuthPassword is the password sent using HTTP Basic authentication
+	// when BasicAuthUsername or BasicAuthPassword is not empty.  The client
+	// never includes credentials in the errors it returns.
+	BasicAuthPassword string
+
+	// BasicAuthUsername is the user name sent using HTTP Basic authentication
+	// when BasicAuthUsername or BasicAuthPassword is not empty.  It may not be
+	// used with BearerToken.
+	BasicAuthUsername string
+
+	// BearerToken, when not empty, is sent in the Authorization header of each
+	// request as a bearer token.  It may not be used with HTTP Basic
+	// authentication.  The client never includes credentials in the errors it
+	// returns.
+	BearerToken string
+
+	// BreakerCooldown is the amount of time a range server is skipped after
+	// its circuit breaker opens.  Once it elapses, a single query is sent to
+	// that server as a probe, while other queries continue to skip it: success
+	// returns the server to the rotation, and failure skips it for another
+	// cooldown.
+	BreakerCooldown time.Duration
+
+	// BreakerThreshold is the number of consecutive failures after which a
+	// range server's circuit breaker opens, causing the server to be skipped
+	// for BreakerCooldown.  Failures are network errors and 5xx responses.
+	// Leave 0 to disable circuit breakers.
+	BreakerThreshold int
+
+	// CacheNegativeTTL is the amount of time an empty response to Query or
+	// QueryCtx, or a RangeException whose code is NO_SUCH_CLUSTER, is cached
+	// when CacheTTL is positive, so callers that repeat a query for a missing
+	// expression in a loop do not send it to a range server each time.
+	// Transient errors, such as timeouts and 5xx responses, are never cached.
+	// It is usually much shorter than CacheTTL.  Leave 0 to cache empty
+	// responses for CacheTTL and never cache errors.
+	CacheNegativeTTL time.Duration
+
+	// CacheSize is the maximum number of query responses kept in the cache
+	// when CacheTTL is positive.  When the cache is full, the least recently
+	// used response is evicted.  Leave 0 to use DefaultCacheSize.
+	CacheSize int
+
+	// CacheTTL is the amount of time a successful response to Query or
+	// QueryCtx is cached, keyed by the query expression.  While cached, a
+	// repeated query returns the cached values without contacting a range
+	// server.  Errors are not cached unless CacheNegativeTTL is positive.
+	// Leave 0 to disable caching.
+	CacheTTL time.Duration
+
+	// CanonicalizeHTMLErrors, when true, extracts a concise message from HTML
+	// error pages in 5xx responses, such as those returned by proxies, and
+	// stores it in the Message field of the returned ErrStatusNotOK.  The
+	// message is the page title, or when there is none, the first line of text
+	// on the page.
+	CanonicalizeHTMLErrors bool
+
+	// CheckRedirect, when not nil, is the redirect policy of the HTTP client
+	// the client creates when HTTPClient is nil, as described by the
+	// CheckRedirect field of http.Client, for policies MaxRedirects cannot
+	// express, such as only following redirects to particular hosts.  It may
+	// not be provided along with HTTPClient or MaxRedirects.
+	CheckRedirect func(request *http.Request, via []*http.Request) error
+
+	// ClientCertFile and ClientKeyFile, when provided, are the names of
+	// PEM-encoded files holding the certificate and private key the client
+	// presents to range servers that require mutual TLS.  The key pair is
+	// loaded when the Client is created, and added to the Certificates of
+	// TLSConfig, or of an otherwise default TLS configuration when TLSConfig is
+	// nil, so queries are sent using HTTPS.  Both must be provided together,
+	// and neither may be provided with HTTPClient, whose transport the client
+	// cannot configure.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// Clock allows the caller to specify the source of time used for pauses
+	// between retries and other time dependent features.  This is intended for
+	// tests.  Leave nil to use the system clock.
+	Clock Clock
+
+	// CoalesceQueries, when true, causes concurrent calls to Query or QueryCtx
+	// with the same expression to share a single query to a range server, all
+	// receiving its result.  Queries sent using QueryWithOptions are only
+	// shared with others sent with the same method and retries.  The shared
+	// query's deadline is the latest deadline of the callers waiting for it.  A
+	// caller whose context closes stops waiting, but the shared query is only
+	// canceled once every caller waiting for it has stopped.
+	CoalesceQueries bool
+
+	// CorrelationIDHeader, when not empty, is the name of the header that
+	// carries the correlation ID of each query, such as "X-Correlation-ID", so
+	// range server logs for every attempt of one query may be matched with each
+	// other and with the client's logs.  See CorrelationID.  Leave empty to not
+	// send the correlation ID.
+	CorrelationIDHeader string
+
+	// CorrelationIDKey, when not nil, is the context key under which the
+	// caller's tracing system stores the correlation ID of a request, as
+	// either a string or a fmt.Stringer.  When the context of a query carries
+	// a correlation ID under this key, it becomes the query's correlation ID
+	// rather than one generated by the client, and is sent in the
+	// CorrelationIDHeader.  When the context does not carry one, the client
+	// generates a correlation ID for its logs, but sends no header.  The key
+	// must be comparable, as required by context.WithValue.  Leave nil to
+	// generate the correlation ID of every query.
+	CorrelationIDKey interface{}
+
+	// DedupeResults, when true, removes duplicate values from the results of
+	// Query, QueryCtx, QueryWithServer, and Expand, keeping the first
+	// occurrence of each.  QueryCallback still streams the response exactly
+	// as the server sent it.  This requires memory proportional to the number
+	// of values, in addition to the values themselves.
+	DedupeResults bool
+
+	// DisablePut, when true, causes every query to be sent using the GET
+	// method, for range servers behind proxies that mangle PUT requests.  A
+	// query whose URI exceeds the query length threshold, or which the server
+	// rejects with Request URI Too Long, returns ErrURITooLong rather than
+	// being sent using PUT, or using LongQueryMethod.
+	DisablePut bool
+
+	// EnableHTTP2, when true, causes the client's default transport to
+	// negotiate HTTP/2 with range servers that support it, so many concurrent
+	// queries are multiplexed over fewer connections.  HTTP/2 is only
+	// negotiated over HTTPS, so it has no effect unless queries are sent using
+	// TLS.  It is ignored when HTTPClient is provided, whose transport must
+	// then be configured for HTTP/2 itself.  Leave false to send queries using
+	// HTTP/1.1.
+	EnableHTTP2 bool
+
+	// ExtraFormFields are additional form fields sent alongside the query in
+	// the body of requests for long queries, for servers that expect fields
+	// such as "caller" or "reason" for auditing.  A "query" entry is ignored
+	// so it cannot overwrite the query expression.
+	ExtraFormFields map[string]string
+
+	// GzipLongQueries, when true, compresses the body of each request for a
+	// long query sent using PUT or POST using gzip when the body is at least
+	// GzipLongQueryThreshold bytes, which reduces the bytes sent for queries
+	// of thousands of hosts.  Only enable it when the range servers decode
+	// request bodies with a Content-Encoding of gzip, because others will
+	// fail to parse the query.  The MaxBodySize of ServerLimits applies to
+	// the uncompressed body.
+	GzipLongQueries bool
+
+	// GzipLongQueryThreshold is the smallest body, in bytes, compressed when
+	// GzipLongQueries is true, because compressing small bodies costs more
+	// than it saves.  Leave 0 to use DefaultGzipLongQueryThreshold.
+	GzipLongQueryThreshold int
+
+	// Headers are added to every request sent to a range server, such as
+	// headers used by servers to authorize or attribute traffic.  Headers the
+	// library sets itself take precedence: Content-Type for PUT requests,
+	// Authorization when credentials are provided, and User-Agent when
+	// UserAgent is provided.  A User-Agent in Headers replaces
+	// DefaultUserAgent.
+	Headers http.Header
+
+	// HedgeDelay, when greater than 0, sends each query attempt to a second
+	// range server when the first has not responded within this delay, using
+	// whichever successful response arrives first and canceling the other
+	// request.  An error from one server does not prevent using a successful
+	// response from the other.  Only used when there is more than one server
+	// and TryAllServers is false.  Leave 0 to send each attempt to a single
+	// server.
+	HedgeDelay time.Duration
+
+	// HostHeader, when not empty, is sent as the Host header of every request
+	// in place of the address of the range server, while the client still
+	// connects to that address.  It is used when one endpoint, such as a
+	// shared ingress, serves several range servers distinguished by virtual
+	// host.  It must be a host name or IP address with an optional port, such
+	// as "range.example.com" or "range.example.com:8081".  Note that when
+	// connecting with TLS, the certificate is still verified against the
+	// server address, unless HTTPClient is configured otherwise.  Leave empty
+	// to derive the Host header from the server address.
+	HostHeader string
+
 	// HTTPClient allows the caller to specify a specially configured
 	// http.Client instance to use for all queries.  When none is provided, a
 	// client will be created using the default timeouts.  If you intend to only
@@ -32,26 +239,470 @@ type Config struct {
 	// cause unexpected results.
 	HTTPClient Doer
 
+	// IdempotencyKeyGenerator returns a new idempotency key for each query.
+	// Leave nil to generate random 128-bit keys.  Only used when
+	// IdempotencyKeyHeader is not empty.
+	IdempotencyKeyGenerator func() string
+
+	// IdempotencyKeyHeader is the name of a header, such as
+	// DefaultIdempotencyKeyHeader, that carries a key generated for each
+	// query, so range servers and proxies can recognize retries of the same
+	// query.  Every attempt to send a query, including retries and attempts
+	// sent to other servers, carries the same key.  Leave empty to send no
+	// idempotency key.
+	IdempotencyKeyHeader string
+
+	// IdleConnTimeout is how long the client's default transport keeps an
+	// idle connection to a range server alive before closing it.  It is
+	// ignored when HTTPClient is provided.  Leave 0 to use
+	// DefaultIdleConnTimeout.
+	IdleConnTimeout time.Duration
+
+	// LatencyAlpha is the weight, in the range (0, 1], given to the most recent
+	// response time of a range server when updating its moving average
+	// latency.  Larger values adapt more quickly to changes in latency.  Leave
+	// 0 to use DefaultLatencyAlpha.  Only used when SelectionStrategy is
+	// LeastLatency.
+	LatencyAlpha float64
+
+	// LatencyProbeEvery is how many query attempts are sent to range servers
+	// for each attempt sent to the slower server whose latency was measured
+	// least recently, to detect its recovery.  Leave 0 to use
+	// DefaultLatencyProbeEvery.  Only used when SelectionStrategy is
+	// LeastLatency.
+	LatencyProbeEvery int
+
+	// LatencyRankInterval is how often range servers are re-ranked by their
+	// moving average latency.  Leave 0 to use DefaultLatencyRankInterval.  Only
+	// used when SelectionStrategy is LeastLatency.
+	LatencyRankInterval time.Duration
+
+	// Logger receives structured log messages when each query starts and
+	// finishes, after each attempt to query a range server, and before each
+	// retry.  Logger methods are invoked synchronously from the query path.
+	// Leave nil to log nothing.
+	Logger Logger
+
+	// LongQueryMethod is the HTTP method, either "PUT" or "POST", used to send
+	// queries whose URI is too long to send using GET, with the expression in
+	// a form-encoded request body.  Leave empty to use PUT.
+	LongQueryMethod string
+
+	// LowercaseCacheKeys, when true, lowercases the query expression before
+	// using it as a cache key, so expressions differing only in case share a
+	// cache entry.  Enable only when range servers treat every query case
+	// insensitively.  Only used when CacheTTL is positive.
+	LowercaseCacheKeys bool
+
+	// MaxConcurrency, when greater than 0, is the most requests the client
+	// sends to range servers at once.  Additional queries wait until a request
+	// finishes, or return the context's error when the context closes first.
+	// Unlike MaxConnsPerHost and MaxIdleConnsPerHost, which limit the
+	// connections to each server, this limits active requests to all servers.
+	// Use InFlight to observe how many requests are active.  Leave 0 for no
+	// limit.
+	MaxConcurrency int
+
+	// MaxConnsPerHost, when greater than 0, is the most connections the
+	// client's default transport opens to each range server, counting
+	// connections that are dialing, active, or idle.  Requests beyond it wait
+	// for a connection to become available.  It is ignored when HTTPClient is
+	// provided.  Leave 0 for no limit.
+	MaxConnsPerHost int
+
+	// MaxIdleConns is the most idle connections the client's default
+	// transport keeps alive across all range servers.  It is ignored when
+	// HTTPClient is provided.  Leave 0 to use DefaultMaxIdleConns.
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost is the most idle connections the client's default
+	// transport keeps alive to each range server.  Services sending many
+	// concurrent queries should raise it to about the number of queries they
+	// send to each server at once, so connections are reused rather than
+	// closed after each request.  It is ignored when HTTPClient is provided.
+	// Leave 0 to use DefaultMaxIdleConnsPerHost.
+	MaxIdleConnsPerHost int
+
+	// MaxQueryDuration, when greater than 0, is the most time a single query
+	// may take, including every retry and the pauses between them, even when
+	// the query's context has no deadline.  When it elapses before the query
+	// succeeds, the query returns ErrQueryBudgetExceeded.  Unlike the Timeout
+	// of an http.Client, which limits each request, this limits the query as a
+	// whole.  Leave 0 for no limit.
+	MaxQueryDuration time.Duration
+
+	// MaxRedirects is the most redirects followed for each request sent by the
+	// HTTP client the client creates when HTTPClient is nil, such as when a
+	// load balancer in front of the range servers redirects to a canonical
+	// host.  When a response would exceed the limit, the redirect is not
+	// followed, and the query fails with ErrStatusNotOK for the redirect
+	// response.  Set it to a negative value to never follow redirects.  It may
+	// not be provided along with HTTPClient, whose own policy applies.  Leave 0
+	// to use DefaultMaxRedirects.
+	MaxRedirects int
+
+	// MaxResponseSize is the largest response body, in bytes, the client will
+	// read from a range server for a query, so a misbehaving server cannot
+	// exhaust the client's memory.  Queries whose response is larger return
+	// ErrResponseTooLarge.  Leave 0 for no limit.
+	MaxResponseSize int64
+
+	// MaxRetryAfter is the longest delay the client will honor when a range
+	// server responds with 429 Too Many Requests or 503 Service Unavailable and
+	// a Retry-After header, so a misbehaving server cannot stall the client
+	// indefinitely.  When a query is retried after such a response, the client
+	// pauses for the longer of the configured retry pause and the requested
+	// delay, capped at this maximum.  Leave 0 to use DefaultMaxRetryAfter.
+	MaxRetryAfter time.Duration
+
+	// MaxServersPerQuery, when greater than 0, is the maximum number of
+	// distinct range servers a single query is sent to, across all of its
+	// attempts, including those made by TryAllServers and HedgeDelay.  Once a
+	// query has been sent to this many servers and has not succeeded, it
+	// returns the most recent error rather than being sent to another server,
+	// even when RetryCount would allow more attempts.  Leave 0 to allow a
+	// query to be sent to any number of servers.
+	MaxServersPerQuery int
+
+	// NormalizeCacheKeys, when true, trims leading and trailing white space
+	// from the query expression, and collapses each run of interior white
+	// space to a single space, before using it as a cache key, so trivially
+	// different but equivalent expressions share a cache entry.  Enable only
+	// when white space is not significant in any query, such as within a
+	// regular expression.  Only used when CacheTTL is positive.
+	NormalizeCacheKeys bool
+
+	// Observer receives notifications when each query starts and finishes,
+	// and after each attempt to query a range server.  Observer methods are
+	// invoked synchronously, so they must not block or do heavy work.  An
+	// Observer that implements ContextObserver is also given the context of
+	// each query.  Leave nil to ignore notifications.
+	Observer Observer
+
+	// PaginateQueries, when true, resolves each query sent by Query or
+	// QueryCtx using a series of smaller sub-queries, for range servers that
+	// cannot answer very large queries before timing out.  Each sub-query
+	// intersects the query expression with a regular expression matching
+	// values that begin with one of the PaginationPrefixes, and a final
+	// sub-query subtracts the values beginning with any of the prefixes, so
+	// no value is missed.  The results of the sub-queries are merged, with
+	// duplicates removed.  This sends one more query than there are prefixes,
+	// and requires range servers that support regular expressions.
+	PaginateQueries bool
+
+	// PaginationPrefixes are the value prefixes used to split queries when
+	// PaginateQueries is true.  Choose prefixes that divide the values of
+	// large queries into similarly sized groups.  Leave nil to use
+	// DefaultPaginationPrefixes.
+	PaginationPrefixes []string
+
+	// PerAttemptTimeout, when greater than 0, is the most time each attempt to
+	// query a range server may take, so a single hung server cannot consume
+	// the time remaining for the query, which remains governed by its context.
+	// An attempt that times out fails with the context.DeadlineExceeded error,
+	// and is retried as allowed by RetryCount and RetryCallback, which by
+	// default retries timeouts.  Leave 0 for no limit.
+	PerAttemptTimeout time.Duration
+
+	// PingTimeout is the longest Ping waits for each range server to answer.
+	// Leave 0 to use DefaultPingTimeout.
+	PingTimeout time.Duration
+
+	// PreferLowLatency, when true, is equivalent to setting SelectionStrategy
+	// to LeastLatency, and may not be provided along with another
+	// SelectionStrategy.
+	//
+	// Deprecated: Set SelectionStrategy to LeastLatency instead.
+	PreferLowLatency bool
+
+	// PreserveLineEndings, when true, returns the values of a response exactly
+	// as the server sent them.  By default, a byte order mark at the start of
+	// a response is removed, as is the carriage return of each CRLF line
+	// ending, which servers on Windows hosts may send, so values do not end
+	// with a stray "\r".  QueryCallback is not affected by this setting.
+	PreserveLineEndings bool
+
+	// Proxy, when not nil, returns the URL of the proxy through which the
+	// client's default transport sends each request, or nil to send the
+	// request directly to the range server, as the Proxy field of
+	// http.Transport does.  Set it to http.ProxyFromEnvironment to use the
+	// proxy named by the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment
+	// variables.  It may not be provided along with UnixSocket, because the
+	// client always connects to the socket.  It is ignored when HTTPClient is
+	// provided, whose transport must then be configured to use the proxy
+	// itself.  Leave nil to ignore the proxy environment variables and always
+	// connect directly to range servers.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// QueryLengthThreshold is the longest URI, in characters, of a query sent
+	// using the GET method.  Queries whose URI is longer are sent using the PUT
+	// method instead, or return ErrURITooLong when RejectLongQueries is true.
+	// Tune it to match the URI limits of the range servers, so long queries
+	// are neither rejected by servers nor needlessly sent using PUT.  Leave 0
+	// to use a threshold of 4096 characters.  A server's MaxURILength in
+	// ServerLimits takes precedence.
+	QueryLengthThreshold int
+
+	// RateBurst is the largest number of queries the client sends at once
+	// before RateLimit paces them.  Leave 0 to allow bursts of a single query.
+	// Only used when RateLimit is greater than 0.
+	RateBurst int
+
+	// RateLimit, when greater than 0, is the most queries per second the
+	// client sends to range servers, to protect shared servers from a busy
+	// client.  Each attempt, including each retry, counts as a query.  A query
+	// that would exceed the limit waits until it is allowed, or returns the
+	// context's error when the context closes first.  Leave 0 for no limit.
+	RateLimit float64
+
+	// RejectLongQueries, when true, causes queries whose URI is too long to be
+	// sent using the GET method to return ErrURITooLong, rather than being sent
+	// using the PUT method.  This applies both when the URI exceeds the query
+	// length threshold, and when the server responds with Request URI Too
+	// Long.
+	RejectLongQueries bool
+
+	// RequestMiddleware are functions invoked in order on each request sent to
+	// a range server, after the client has set all of its headers and just
+	// before the request is sent, so they may change the request in ways
+	// Headers cannot, such as signing it.  They are invoked again for each
+	// attempt, including retries and requests re-sent using another method,
+	// and may be invoked concurrently.  A middleware that reads the body of a
+	// PUT or POST request must do so using the request's GetBody.  When a
+	// middleware returns an error, the remaining middleware are not invoked,
+	// the request is not sent, and the attempt fails with that error, which
+	// is retried as allowed by RetryCallback.
+	RequestMiddleware []func(*http.Request) error
+
+	// ResponseFormat is the format in which the client asks range servers to
+	// return the values of queries.  When JSONResponse is requested, but a
+	// server ignores the request and responds with text, the text response is
+	// used.  QueryCallback receives the response in whichever format the
+	// server sent it.  Leave 0 to use TextResponse.
+	ResponseFormat ResponseFormat
+
+	// ResponseTransform, when not nil, is invoked with the entire body of each
+	// successful response, and returns the body the client parses in its
+	// place, so responses of servers with unusual formats may be normalized,
+	// such as by replacing commas between values with newlines.  It is only
+	// invoked for responses with a status of OK and without a RangeException,
+	// after the body is decompressed, and its result is not limited by
+	// MaxResponseSize.  When it returns an error, the attempt fails with that
+	// error, which is retried as allowed by RetryCallback.  Because the entire
+	// body is read before it is transformed, QueryCallback no longer streams
+	// the response.
+	ResponseTransform func([]byte) ([]byte, error)
+
+	// RetryBackoff, when true, doubles the pause prior to each successive
+	// retry, starting with RetryPause.
+	RetryBackoff bool
+
+	// RetryBackoffMax is the ceiling for the pause between retries when
+	// RetryBackoff is enabled.  Leave 0 for no ceiling.
+	RetryBackoffMax time.Duration
+
 	// RetryCallback is predicate function that tests whether query should be
 	// retried for a given error.  Leave nil to retry all errors.
 	RetryCallback func(error) bool
 
+	// RetryCallbackCtx is like RetryCallback, but is also given the query's
+	// context, the number of the attempt that failed, starting at 1, and the
+	// address of the range server that returned the error, so retry decisions
+	// may depend on which server failed and how many attempts have been made.
+	// It is only invoked when more attempts are allowed by RetryCount.  Only
+	// one of RetryCallback and RetryCallbackCtx may be provided.  Leave nil to
+	// use RetryCallback.
+	RetryCallbackCtx func(ctx context.Context, attempt int, server string, err error) bool
+
 	// RetryCount is number of query retries to be issued if query returns
-	// error.  Leave 0 to never retry query errors.
+	// error.  Each retry is sent to a range server the query has not yet been
+	// sent to, while one remains.  Leave 0 to never retry query errors.
 	RetryCount int
 
+	// RetryDelay is an optional function that returns the amount of time to
+	// wait prior to the specified retry attempt, where attempt 1 is the first
+	// retry.  When provided, RetryPause, RetryBackoff, and RetryBackoffMax are
+	// ignored.  Leave nil to use RetryPause, with optional exponential backoff.
+	RetryDelay func(attempt int) time.Duration
+
+	// RetryJitter is the maximum amount of random time added to each pause
+	// before retrying the query, so that many clients sharing the same retry
+	// settings do not retry in lockstep.  The actual pause is randomly chosen
+	// in the range [pause, pause+RetryJitter].  Leave 0 to disable jitter.
+	RetryJitter time.Duration
+
 	// RetryPause is the amount of time to wait before retrying the query.
 	RetryPause time.Duration
 
+	// SelectionStrategy controls how the client chooses the range server to
+	// send each query attempt to.  Leave 0 to use RoundRobin.  Weights provided
+	// by WeightedServers apply to each strategy except LeastLatency.
+	SelectionStrategy SelectionStrategy
+
+	// ServerLimits optionally maps range server addresses to the largest
+	// requests those servers are known to accept.  A query whose URI is longer
+	// than a server's MaxURILength is sent to that server using the PUT method
+	// rather than the GET method, or returns ErrURITooLong when
+	// RejectLongQueries is true.  A query that must be sent using the PUT
+	// method, but whose body is larger than the server's MaxBodySize, returns
+	// ErrRequestTooLarge rather than being sent to that server.  Servers not
+	// listed, and limits left 0, use the client's defaults.
+	ServerLimits map[string]ServerLimit
+
+	// ServerRateBurst is the largest number of queries the client sends at once
+	// to a single range server before ServerRateLimit paces them.  Leave 0 to
+	// allow bursts of a single query.  Only used when ServerRateLimit is
+	// greater than 0.
+	ServerRateBurst int
+
+	// ServerRateLimit, when greater than 0, is the most queries per second the
+	// client sends to each range server, independently of RateLimit, so a
+	// single server is not overwhelmed when it is the only healthy one.  When a
+	// server's budget is exhausted, queries are sent to another server whose
+	// budget allows it.  When every server's budget is exhausted, a query
+	// waits until the selected server's budget allows it, or returns the
+	// context's error when the context closes first.  Leave 0 for no limit.
+	ServerRateLimit float64
+
 	// Servers is slice of range server address strings.  Must contain at least
-	// one string.
+	// one string, unless SRVRecord, UnixSocket, or WeightedServers is provided.
+	// Each address is a host name or IP address, optionally followed by a
+	// port.  IPv6 addresses are wrapped in brackets as needed, but must already
+	// be wrapped when followed by a port, as in "[::1]:8081".  An address may
+	// also be a base URL with a scheme of http or https and an optional path
+	// prefix, such as "https://range.example.com:9443/proxy", in which case
+	// queries are sent using its scheme, rather than the scheme selected by
+	// TLSConfig, and to endpoints below its path.
 	Servers []string
 
+	// SortResults, when true, sorts the results of Query, QueryCtx,
+	// QueryWithServer, and Expand, so they may be compared deterministically.
+	// When DedupeResults is also true, duplicates are removed after sorting.
+	// QueryCallback still streams the response exactly as the server sent it.
+	// Sorting takes O(n log n) time, which may be noticeable for results with
+	// millions of values.
+	SortResults bool
+
+	// SRVRecord, when not empty, is the name of a DNS SRV record, such as
+	// "_range._tcp.example.com", whose targets are used as the range servers.
+	// The record is resolved when the client is created, and every
+	// SRVRefreshInterval thereafter until the client is closed.  Only the
+	// targets with the lowest priority value are used, and their weights are
+	// ignored.  When resolution fails or returns no targets, the client keeps
+	// its previous servers, which initially are the Servers.  Servers may be
+	// empty when the record resolves while creating the client.
+	SRVRecord string
+
+	// SRVRefreshInterval is how often SRVRecord is resolved.  Leave 0 to use
+	// DefaultSRVRefreshInterval.  Only used when SRVRecord is not empty.
+	SRVRefreshInterval time.Duration
+
+	// SRVResolver resolves SRVRecord.  Leave nil to use net.DefaultResolver.
+	// Only used when SRVRecord is not empty.
+	SRVResolver SRVResolver
+
+	// Timeout is the time limit for each query sent using the HTTP client the
+	// client creates when HTTPClient is nil, including reading the response
+	// body.  It is also the response time recorded for failed attempts when
+	// servers are ranked by latency, which is its only use when HTTPClient is
+	// provided.  Leave 0 to use DefaultQueryTimeout.
+	Timeout time.Duration
+
+	// TLSConfig, when not nil, causes queries to be sent to range servers using
+	// HTTPS rather than HTTP, with this configuration used by the client's
+	// default transport, such as to trust the certificate authority that
+	// signed the servers' certificates, or to present client certificates to
+	// servers that require mutual TLS.  The configuration is only used by the
+	// transport the client creates when HTTPClient is nil, and never changes a
+	// provided HTTPClient, which must then be configured for HTTPS itself.  The
+	// configuration is copied, so later changes to it have no effect.
+	//
+	// Setting InsecureSkipVerify disables verification of the servers'
+	// certificates, which allows anyone able to intercept the connection to
+	// impersonate a range server, reading queries and returning whatever
+	// values they choose.  Only disable verification for testing.
+	TLSConfig *tls.Config
+
+	// TryAllServers, when true, causes each query attempt that fails to be sent
+	// to each of the other servers in turn, until one succeeds or every server
+	// has been tried once.  This happens independently of RetryCount, which
+	// controls how many times this process is repeated.  A RangeException is
+	// not sent to other servers, because it describes a problem with the query
+	// rather than with the server.  Leave false to send each attempt to a
+	// single server.
+	TryAllServers bool
+
+	// UnixSocket, when not empty, is the path of a Unix domain socket to which
+	// the client's default transport connects to send every query, such as
+	// that of a local range cache.  The range server addresses are then only
+	// used as the Host of each request, so servers may route queries by Host,
+	// and Servers may be left empty to use "localhost".  It may not be
+	// provided along with HTTPClient, which must then be configured to dial
+	// the socket itself, nor along with Proxy.
+	UnixSocket string
+
+	// UseCountEndpoint, when true, causes Count and CountCtx to ask each range
+	// server's count endpoint for the number of values a query expression
+	// resolves to, rather than transferring the values only to count them.
+	// Servers that respond that they have no count endpoint are sent the query
+	// itself.  Enable it only when the range servers provide a count endpoint
+	// whose count matches the client's DedupeResults setting.  Leave false to
+	// always count the values of the query.
+	UseCountEndpoint bool
+
 	// UserAgent is a string added to the HTTP headers and is intended to
-	// identify clients requesting online content.  When none is provided,
-	// the default Go user agent will be used.
-	// https://go.dev/src/net/http/request.go#L514
+	// identify clients requesting online content, such as the name and version
+	// of the program, so range servers may log and rate limit by client.  When
+	// none is provided, the User-Agent from Headers is used, or when Headers
+	// has none, DefaultUserAgent.
 	UserAgent string
+
+	// ValidateQueries, when true, causes each query expression to be checked
+	// before it is sent, returning ErrInvalidQuery rather than sending an
+	// expression that contains control characters or invalid UTF-8, or whose
+	// escaped URI is not well-formed.
+	ValidateQueries bool
+
+	// WeightedServers is a slice of range server addresses, each with a weight
+	// that controls how often queries are sent to it relative to the other
+	// servers, so larger servers may be sent more queries than smaller ones.
+	// These servers are used in addition to any listed in Servers, each of
+	// which has a weight of 1.  Weights are ignored when SelectionStrategy is
+	// LeastLatency, and when SRVRecord is provided and resolves.
+	WeightedServers []ServerWeight
+
+	// WrapTransport, when not nil, is invoked with the transport of the HTTP
+	// client the client creates when HTTPClient is nil, and returns the
+	// http.RoundTripper that HTTP client uses instead, such as one that
+	// records a trace of each request before sending it using the transport.
+	// The client's TLS, proxy, connection pool, and Unix socket settings still
+	// apply to the transport, and Close still closes its idle connections.  It
+	// may not be provided along with HTTPClient, which may be wrapped instead.
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+}
+
+// ServerLimit describes the largest requests a range server accepts.
+type ServerLimit struct {
+	// MaxURILength is the longest URI the server accepts for a GET query.
+	// Leave 0 to use the client's default threshold.
+	MaxURILength int
+
+	// MaxBodySize is the largest body, in bytes, the server accepts for a PUT
+	// query.  Leave 0 for no limit.
+	MaxBodySize int
+}
+
+// MaxServerWeight is the largest Weight of a ServerWeight.  It bounds the
+// work done to interleave weighted servers, which grows with their weights.
+const MaxServerWeight = 100
+
+// ServerWeight is the address of a range server along with the relative
+// share of queries it ought to be sent.  A server with a Weight of 3 is sent
+// three times as many queries as a server with a Weight of 1.  Weight may be
+// at most MaxServerWeight.
+type ServerWeight struct {
+	Server string
+	Weight int
 }
 
 // Doer performs the specfied http.Request and returns the http.Response.
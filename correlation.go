@@ -0,0 +1,78 @@
+package orange
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+// correlationIDKey is the context key for the correlation ID of a query.
+type correlationIDKey struct{}
+
+// CorrelationID returns the correlation ID of the query that ctx belongs to,
+// or the empty string when ctx does not belong to a query.  Every attempt to
+// send the same query, including retries, attempts sent to other servers, and
+// attempts sent using another HTTP method, shares the same correlation ID,
+// while each query has its own.  The client includes it in each message sent
+// to its Logger, and a Doer may read it from the context of each request it
+// sends, such as to annotate traces.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// withCorrelationID returns a copy of ctx that carries the correlation ID of a
+// new query: the caller's correlation ID when ctx has one under the client's
+// CorrelationIDKey, and otherwise a newly generated one.
+func (c *Client) withCorrelationID(ctx context.Context) context.Context {
+	id, ok := c.callerCorrelationID(ctx)
+	if !ok {
+		id = randomHex(8)
+	}
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// callerCorrelationID returns the correlation ID the caller stored in ctx under
+// the client's CorrelationIDKey, and whether there is one.  The value may be
+// either a string or a fmt.Stringer.
+func (c *Client) callerCorrelationID(ctx context.Context) (string, bool) {
+	if c.correlationIDKey == nil {
+		return "", false
+	}
+	var id string
+	switch v := ctx.Value(c.correlationIDKey).(type) {
+	case string:
+		id = v
+	case fmt.Stringer:
+		id = v.String()
+	}
+	return id, id != ""
+}
+
+// setCorrelationIDHeader adds the correlation ID carried by ctx, if any, to
+// request, when the client is configured to send it.  When the client has a
+// CorrelationIDKey, only the caller's correlation ID is sent.
+func (c *Client) setCorrelationIDHeader(ctx context.Context, request *http.Request) {
+	if c.correlationIDHeader == "" {
+		return
+	}
+	id := CorrelationID(ctx)
+	if c.correlationIDKey != nil {
+		id, _ = c.callerCorrelationID(ctx)
+	}
+	if id != "" {
+		request.Header.Set(c.correlationIDHeader, id)
+	}
+}
+
+// randomHex returns size random bytes encoded as hexadecimal, such as for
+// correlation IDs and idempotency keys.
+func randomHex(size int) string {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err) // crypto/rand only fails when the system has no entropy source
+	}
+	return hex.EncodeToString(buf)
+}
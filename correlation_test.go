@@ -0,0 +1,168 @@
+package orange
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// correlationDoer records the correlation ID of each request it sends.
+type correlationDoer struct {
+	next Doer
+	lock sync.Mutex
+	ids  []string
+}
+
+func (cd *correlationDoer) Do(request *http.Request) (*http.Response, error) {
+	cd.lock.Lock()
+	cd.ids = append(cd.ids, CorrelationID(request.Context()))
+	cd.lock.Unlock()
+	return cd.next.Do(request)
+}
+
+func TestClientCorrelationID(t *testing.T) {
+	const header = "X-Correlation-ID"
+
+	var lock sync.Mutex
+	var headers []string
+	var invocations int
+	h := func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		headers = append(headers, r.Header.Get(header))
+		invocations++
+		n := invocations
+		lock.Unlock()
+
+		switch {
+		case r.URL.RawQuery == "single":
+			w.Write([]byte("result\n"))
+		case n == 1:
+			http.Error(w, "use PUT", http.StatusRequestURITooLong)
+		case n == 2:
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("result\n"))
+		}
+	}
+
+	doer := new(correlationDoer)
+	logger := new(recordingLogger)
+	configure := func(config *Config) {
+		doer.next = config.HTTPClient
+		config.HTTPClient = doer
+		config.CorrelationIDHeader = header
+		config.Logger = logger
+		config.RetryCallback = func(error) bool { return true }
+		config.RetryCount = 1
+	}
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		_, err := client.Query("foo")
+		ensureError(t, err)
+
+		_, err = client.Query("single")
+		ensureError(t, err)
+	})
+
+	// The first query switched from GET to PUT, then was retried.
+	if got, want := len(headers), 4; got != want {
+		t.Fatalf("GOT: %v; WANT: %v", got, want)
+	}
+	first := headers[0]
+	if first == "" {
+		t.Fatalf("GOT: %q; WANT: correlation ID", first)
+	}
+	for i := 1; i < 3; i++ {
+		if got, want := headers[i], first; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	}
+	if got, want := headers[3], first; got == "" || got == want {
+		t.Errorf("GOT: %q; WANT: other than %q", got, want)
+	}
+
+	// The Doer sees the same correlation ID as the range server.
+	if got, want := strings.Join(doer.ids, ","), strings.Join(headers, ","); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+
+	// Every log message of the first query includes its correlation ID.
+	var logged int
+	for _, message := range logger.Messages() {
+		if strings.Contains(message, first) {
+			logged++
+		}
+	}
+	if got, want := logged, 5; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
+
+func TestClientCorrelationIDHeaderDisabled(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Correlation-ID"); got != "" {
+			t.Errorf("GOT: %q; WANT: %q", got, "")
+		}
+		w.Write([]byte("result\n"))
+	}
+	withClient(t, h, func(client *Client) {
+		_, err := client.Query("foo")
+		ensureError(t, err)
+	})
+}
+
+func TestClientCorrelationIDKey(t *testing.T) {
+	type traceKey struct{}
+
+	var lock sync.Mutex
+	var headers []string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		headers = append(headers, r.Header.Get("X-Correlation-ID"))
+		lock.Unlock()
+		w.Write([]byte("result\n"))
+	}
+
+	doer := new(correlationDoer)
+	configure := func(config *Config) {
+		doer.next = config.HTTPClient
+		config.HTTPClient = doer
+		config.CorrelationIDHeader = "X-Correlation-ID"
+		config.CorrelationIDKey = traceKey{}
+	}
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		ctx := context.WithValue(context.Background(), traceKey{}, "trace-1234")
+		_, err := client.QueryCtx(ctx, "foo")
+		ensureError(t, err)
+
+		_, _, err = client.QueryBytesTypedCtx(ctx, "foo")
+		ensureError(t, err)
+
+		// Without the caller's correlation ID, the client sends none.
+		_, err = client.QueryCtx(context.Background(), "foo")
+		ensureError(t, err)
+	})
+
+	if got, want := strings.Join(headers, ","), "trace-1234,trace-1234,"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+	// The caller's correlation ID becomes the query's correlation ID, and
+	// otherwise the client generates one.
+	if got, want := len(doer.ids), 3; got != want {
+		t.Fatalf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := doer.ids[0], "trace-1234"; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got := doer.ids[2]; got == "" || got == "trace-1234" {
+		t.Errorf("GOT: %q; WANT: generated correlation ID", got)
+	}
+
+	t.Run("not comparable", func(t *testing.T) {
+		_, err := NewClient(&Config{CorrelationIDKey: []string{"key"}, Servers: []string{"one"}})
+		ensureError(t, err, "CorrelationIDKey that is not comparable")
+	})
+}
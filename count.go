@@ -0,0 +1,48 @@
+package orange
+
+import "context"
+
+// Count returns the number of values the range query expression resolves to.
+// It is a convenience wrapper for CountCtx using a background context.
+func (c *Client) Count(expression string) (int, error) {
+	return c.CountCtx(context.Background(), expression)
+}
+
+// CountCtx returns the number of values the range query expression resolves
+// to, using the provided context.  When the client is configured with
+// UseCountEndpoint, it asks the range server's count endpoint, so the values
+// themselves are not transferred, and sends the query itself to servers
+// without a count endpoint.  Otherwise it counts the values returned by
+// QueryCtx, after the client's DedupeResults setting is applied.  Servers are
+// selected and requests are retried as per the client's configuration, and it
+// returns the same error types as QueryCtx.
+//
+// Unlike EstimateResultCount, whose answer from a server without a count
+// endpoint is only approximated from the length of the response, the count
+// returned by CountCtx is always exact, at the cost of transferring the values
+// when the server has no count endpoint.
+func (c *Client) CountCtx(ctx context.Context, expression string) (int, error) {
+	if !c.useCountEndpoint {
+		values, err := c.QueryCtx(ctx, expression)
+		if err != nil {
+			return 0, err
+		}
+		return len(values), nil
+	}
+	return c.observeCount(ctx, expression, c.count)
+}
+
+// count returns the number of values the query expression resolves to, as
+// reported by the specified server's count endpoint, or when the server has no
+// count endpoint, by counting the values of the query.
+func (c *Client) count(ctx context.Context, expression, server string) (int, error) {
+	count, ok, err := c.countFromEndpoint(ctx, expression, server)
+	if err != nil || ok {
+		return count, err
+	}
+	var lines []string
+	if err := c.query(ctx, listPath, expression, c.appendValues(&lines), server); err != nil {
+		return 0, err
+	}
+	return len(c.processResults(lines)), nil
+}
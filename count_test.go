@@ -0,0 +1,97 @@
+package orange
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestClientCount(t *testing.T) {
+	var lock sync.Mutex
+	var paths []string
+	record := func(r *http.Request) {
+		lock.Lock()
+		paths = append(paths, r.URL.Path)
+		lock.Unlock()
+	}
+	reset := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		p := paths
+		paths = nil
+		return p
+	}
+
+	withCountEndpoint := func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		if r.URL.Path == countPath {
+			w.Write([]byte("42\n"))
+			return
+		}
+		w.Write([]byte("host1\nhost2\nhost2\n"))
+	}
+
+	t.Run("values", func(t *testing.T) {
+		h := withCountEndpoint
+		configure := func(config *Config) { config.DedupeResults = true }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			count, err := client.Count("%foo")
+			ensureError(t, err)
+			if got, want := count, 2; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+		ensureStringSlicesMatch(t, reset(), []string{listPath})
+	})
+
+	t.Run("count endpoint", func(t *testing.T) {
+		h := withCountEndpoint
+		configure := func(config *Config) { config.UseCountEndpoint = true }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			count, err := client.Count("%foo")
+			ensureError(t, err)
+			if got, want := count, 42; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+		ensureStringSlicesMatch(t, reset(), []string{countPath})
+	})
+
+	t.Run("no count endpoint", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == countPath {
+				record(r)
+				http.NotFound(w, r)
+				return
+			}
+			withCountEndpoint(w, r)
+		}
+		configure := func(config *Config) {
+			config.MaxConcurrency = 1
+			config.UseCountEndpoint = true
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			count, err := client.Count("%foo")
+			ensureError(t, err)
+			if got, want := count, 3; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+		ensureStringSlicesMatch(t, reset(), []string{countPath, listPath})
+	})
+
+	t.Run("error", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("RangeException", "NO_SUCH_CLUSTER: foo")
+		}
+		for _, useCountEndpoint := range []bool{false, true} {
+			configure := func(config *Config) { config.UseCountEndpoint = useCountEndpoint }
+			withConfiguredClient(t, h, configure, func(client *Client) {
+				_, err := client.Count("%foo")
+				if got, want := IsNoSuchCluster(err), true; got != want {
+					t.Errorf("GOT: %v; WANT: %v", err, ErrRangeException{})
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package orange
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Result is the outcome of a query made with QueryDetailed, describing how the
+// query was fulfilled in addition to its values.
+type Result struct {
+	Values   []string      // Values are the values the query resolved to, or nil when it failed.
+	Attempts int           // Attempts is the number of attempts made, including the first.
+	Server   string        // Server is the address of the range server sent the final attempt.
+	Duration time.Duration // Duration is the time taken by the query, including retries and pauses.
+}
+
+type attemptsKey struct{}
+
+// recordAttempt increments the attempt counter attached to ctx by
+// QueryDetailedCtx, if any.
+func recordAttempt(ctx context.Context) {
+	if p, ok := ctx.Value(attemptsKey{}).(*int32); ok {
+		atomic.AddInt32(p, 1)
+	}
+}
+
+// QueryDetailed sends the query expression to a range server and returns its
+// values along with the number of attempts made, the server that was sent the
+// final attempt, and how long the query took.  It is a convenience wrapper for
+// QueryDetailedCtx using a background context.
+func (c *Client) QueryDetailed(expression string) (*Result, error) {
+	return c.QueryDetailedCtx(context.Background(), expression)
+}
+
+// QueryDetailedCtx sends the query expression to a range server with the
+// provided context, and returns its values along with the number of attempts
+// made, the server that was sent the final attempt, and how long the query
+// took.  Servers are selected and the query is retried just as they are for
+// QueryCtx, and the values are sorted and deduplicated as configured, but the
+// query is neither cached nor coalesced, so each Result describes requests
+// actually sent to range servers.
+//
+// When the query fails, the error is returned along with a Result whose
+// Values are nil, so the attempts of failed queries may also be counted.
+//
+//     result, err := client.QueryDetailedCtx(ctx, "%web")
+//     if result.Attempts > 1 {
+//         log.Printf("query took %d attempts; final server: %s", result.Attempts, result.Server)
+//     }
+//     if err != nil {
+//         return err
+//     }
+func (c *Client) QueryDetailedCtx(ctx context.Context, expression string) (*Result, error) {
+	var attempts int32
+	ctx = context.WithValue(ctx, attemptsKey{}, &attempts)
+
+	var lines []string
+	started := c.clock.Now()
+	server, err := c.queryCallback(ctx, expression, c.appendValues(&lines))
+	result := &Result{
+		Attempts: int(atomic.LoadInt32(&attempts)),
+		Server:   server,
+		Duration: c.clock.Now().Sub(started),
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Values = c.processResults(lines)
+	return result, nil
+}
@@ -0,0 +1,80 @@
+package orange
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClientQueryDetailed(t *testing.T) {
+	t.Run("first attempt", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result2\nresult1\n"))
+		}
+		configure := func(config *Config) { config.SortResults = true }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			result, err := client.QueryDetailed("foo")
+			ensureError(t, err)
+			if got, want := len(result.Values), 2; got != want {
+				t.Fatalf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := result.Values[0], "result1"; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := result.Attempts, 1; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := result.Server, client.Servers()[0]; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if result.Duration <= 0 {
+				t.Errorf("GOT: %v; WANT: > 0", result.Duration)
+			}
+		})
+	})
+
+	t.Run("retried", func(t *testing.T) {
+		var requests int32
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) < 3 {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("result1\n"))
+		}
+		configure := func(config *Config) {
+			config.RetryCallback = func(error) bool { return true }
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			result, err := client.QueryDetailed("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, result.Values, []string{"result1"})
+			if got, want := result.Attempts, 3; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		}
+		configure := func(config *Config) {
+			config.RetryCallback = func(error) bool { return true }
+			config.RetryCount = 1
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			result, err := client.QueryDetailed("foo")
+			ensureError(t, err, http.StatusText(http.StatusServiceUnavailable))
+			if result.Values != nil {
+				t.Errorf("GOT: %v; WANT: %v", result.Values, nil)
+			}
+			if got, want := result.Attempts, 2; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := result.Server, client.Servers()[0]; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+}
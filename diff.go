@@ -0,0 +1,141 @@
+package orange
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Difference is a value present in only one of two sorted query results.
+type Difference struct {
+	Value string // Value is the value present in only one result.
+	Added bool   // Added is true when Value is only in the second result, and false when only in the first.
+}
+
+// DiffSorted reads two sorted streams of newline delimited values, and sends
+// each value present in only one of them to differences, in sorted order.
+// Values only in before are sent with Added false, and values only in after
+// are sent with Added true.  Because it reads both streams one line at a time,
+// memory use does not depend on the size of the streams.
+//
+// Both streams must be sorted in ascending byte-wise order, which is how range
+// servers commonly return results.  DiffSorted returns an error when it finds a
+// value out of order.  It closes differences before returning.
+func DiffSorted(ctx context.Context, before, after io.Reader, differences chan<- Difference) error {
+	defer close(differences)
+
+	b := newSortedScanner(before, "first")
+	a := newSortedScanner(after, "second")
+
+	bOK, aOK := b.Scan(), a.Scan()
+	for bOK || aOK {
+		var d Difference
+		switch {
+		case !aOK || (bOK && b.Text() < a.Text()):
+			d = Difference{Value: b.Text()}
+			bOK = b.Scan()
+		case !bOK || a.Text() < b.Text():
+			d = Difference{Value: a.Text(), Added: true}
+			aOK = a.Scan()
+		default:
+			bOK, aOK = b.Scan(), a.Scan()
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case differences <- d:
+		}
+	}
+
+	if err := b.Err(); err != nil {
+		return err
+	}
+	return a.Err()
+}
+
+// DiffQuery sends the query expression to both this client and other, and
+// while both responses stream in, sends each value present in only one of them
+// to differences.  Values only in this client's response are sent with Added
+// false, and values only in other's response are sent with Added true.  See
+// DiffSorted for the requirement that both responses are sorted.  DiffQuery
+// closes differences before returning.
+//
+// Because the responses are streamed, the clients must not be configured with
+// a RetryCallback that retries errors reading a response body, because a
+// retried response would be streamed after the partial one.
+//
+//     differences := make(chan orange.Difference)
+//     errc := make(chan error, 1)
+//     go func() { errc <- production.DiffQuery(ctx, staging, "%all", differences) }()
+//     for d := range differences {
+//         if d.Added {
+//             fmt.Println("+", d.Value)
+//         } else {
+//             fmt.Println("-", d.Value)
+//         }
+//     }
+//     if err := <-errc; err != nil {
+//         fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+//     }
+func (c *Client) DiffQuery(ctx context.Context, other *Client, expression string, differences chan<- Difference) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	before := streamQuery(ctx, c, expression)
+	after := streamQuery(ctx, other, expression)
+
+	err := DiffSorted(ctx, before, after, differences)
+
+	// Unblock both queries should the diff have ended early.
+	_ = before.CloseWithError(io.ErrClosedPipe)
+	_ = after.CloseWithError(io.ErrClosedPipe)
+
+	return err
+}
+
+// streamQuery sends the query expression using client, and returns a reader
+// that streams the response body, or returns the query error when reading.
+func streamQuery(ctx context.Context, client *Client, expression string) *io.PipeReader {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(client.QueryCallback(ctx, expression, func(ior io.Reader) error {
+			_, err := io.Copy(pw, ior)
+			return err
+		}))
+	}()
+	return pr
+}
+
+// sortedScanner scans lines, returning an error when a line sorts before the
+// line that preceded it.
+type sortedScanner struct {
+	*lineScanner
+	name     string
+	previous string
+	err      error
+}
+
+func newSortedScanner(ior io.Reader, name string) *sortedScanner {
+	return &sortedScanner{lineScanner: newLineScanner(ior, false), name: name}
+}
+
+func (s *sortedScanner) Scan() bool {
+	if s.err != nil || !s.lineScanner.Scan() {
+		return false
+	}
+	text := s.Text()
+	if s.line > 1 && text < s.previous {
+		s.err = fmt.Errorf("cannot diff unsorted input: %s input line %d: %q sorts before %q", s.name, s.line, text, s.previous)
+		return false
+	}
+	s.previous = text
+	return true
+}
+
+func (s *sortedScanner) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.lineScanner.Err()
+}
@@ -0,0 +1,139 @@
+package orange
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// collectDifferences runs diff, and returns the differences it sends as
+// strings prefixed by "+" or "-".
+func collectDifferences(diff func(chan<- Difference) error) ([]string, error) {
+	differences := make(chan Difference)
+	errc := make(chan error, 1)
+	go func() { errc <- diff(differences) }()
+
+	var results []string
+	for d := range differences {
+		if d.Added {
+			results = append(results, "+"+d.Value)
+		} else {
+			results = append(results, "-"+d.Value)
+		}
+	}
+	return results, <-errc
+}
+
+// sortedValues returns a reader of n sorted values, omitting every value for
+// which skip returns true.
+func sortedValues(n int, skip func(int) bool) io.Reader {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		if !skip(i) {
+			fmt.Fprintf(&sb, "host%06d\n", i)
+		}
+	}
+	return strings.NewReader(sb.String())
+}
+
+func TestDiffSorted(t *testing.T) {
+	t.Run("small", func(t *testing.T) {
+		before := strings.NewReader("a\nb\nd\nf\n")
+		after := strings.NewReader("b\nc\nd\ng\nh")
+		got, err := collectDifferences(func(differences chan<- Difference) error {
+			return DiffSorted(context.Background(), before, after, differences)
+		})
+		ensureError(t, err)
+		if got, want := strings.Join(got, " "), "-a +c -f +g +h"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		got, err := collectDifferences(func(differences chan<- Difference) error {
+			return DiffSorted(context.Background(), strings.NewReader(""), strings.NewReader("a\n"), differences)
+		})
+		ensureError(t, err)
+		if got, want := strings.Join(got, " "), "+a"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("large", func(t *testing.T) {
+		const n = 200000
+		before := sortedValues(n, func(i int) bool { return i%1000 == 7 })
+		after := sortedValues(n, func(i int) bool { return i%5000 == 3 })
+
+		got, err := collectDifferences(func(differences chan<- Difference) error {
+			return DiffSorted(context.Background(), before, after, differences)
+		})
+		ensureError(t, err)
+
+		var want []string
+		for i := 0; i < n; i++ {
+			switch {
+			case i%1000 == 7:
+				want = append(want, fmt.Sprintf("+host%06d", i))
+			case i%5000 == 3:
+				want = append(want, fmt.Sprintf("-host%06d", i))
+			}
+		}
+		if got, want := strings.Join(got, " "), strings.Join(want, " "); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("unsorted", func(t *testing.T) {
+		_, err := collectDifferences(func(differences chan<- Difference) error {
+			return DiffSorted(context.Background(), strings.NewReader("a\nc\nb\n"), strings.NewReader("a\nc\n"), differences)
+		})
+		ensureError(t, err, "unsorted", "line 3")
+	})
+}
+
+func TestDiffQuery(t *testing.T) {
+	const n = 50000
+
+	newServer := func(skip func(int) bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(w, sortedValues(n, skip))
+		}))
+	}
+	newClient := func(server *httptest.Server) *Client {
+		client, err := NewClient(&Config{
+			HTTPClient: server.Client(),
+			Servers:    []string{strings.TrimLeft(server.URL, "http://")},
+		})
+		ensureError(t, err)
+		return client
+	}
+
+	production := newServer(func(i int) bool { return i == 42 })
+	defer production.Close()
+	staging := newServer(func(i int) bool { return i == 4242 })
+	defer staging.Close()
+
+	got, err := collectDifferences(func(differences chan<- Difference) error {
+		return newClient(production).DiffQuery(context.Background(), newClient(staging), "%all", differences)
+	})
+	ensureError(t, err)
+	if got, want := strings.Join(got, " "), "+host000042 -host004242"; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+
+	t.Run("query error", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("RangeException", "some error")
+		}))
+		defer failing.Close()
+
+		_, err := collectDifferences(func(differences chan<- Difference) error {
+			return newClient(production).DiffQuery(context.Background(), newClient(failing), "%all", differences)
+		})
+		ensureError(t, err, "some error")
+	})
+}
@@ -0,0 +1,73 @@
+package orange
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSRVRefreshInterval is used when SRVRecord is provided but no
+// SRVRefreshInterval is provided to control how often the record is resolved.
+const DefaultSRVRefreshInterval = time.Minute
+
+// SRVResolver resolves DNS SRV records.  The *net.Resolver type implements
+// this interface.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// resolveSRV returns the address of each target of the SRV record name with the
+// lowest priority, ordered by decreasing weight.  Targets with higher priority
+// values are only meant to be used when those with the lowest are unavailable,
+// which the client's retry and circuit breaker settings already provide for, so
+// they are ignored.
+func resolveSRV(ctx context.Context, resolver SRVResolver, name string) ([]string, error) {
+	_, records, err := resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	sorted := append([]*net.SRV(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+		return sorted[i].Weight > sorted[j].Weight
+	})
+
+	var servers []string
+	for _, record := range sorted {
+		if record.Priority != sorted[0].Priority {
+			break
+		}
+		servers = append(servers, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+	}
+	return servers, nil
+}
+
+// refreshSRV periodically resolves the client's SRV record, and replaces the
+// client's range servers with its targets, until the client is closed.  When
+// resolution fails or returns no targets, the previous servers are kept.
+func (c *Client) refreshSRV(interval time.Duration) {
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-c.clock.After(interval):
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+		servers, err := resolveSRV(ctx, c.srvResolver, c.srvRecord)
+		cancel()
+		if err != nil || len(servers) == 0 || sameStrings(servers, c.servers.Values()) {
+			continue
+		}
+		_ = c.servers.Set(servers) // never fails with at least one server
+	}
+}
@@ -0,0 +1,167 @@
+package orange
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver is an SRVResolver that returns its current records.
+type fakeResolver struct {
+	lock    sync.Mutex
+	records []*net.SRV
+	err     error
+	lookups int
+}
+
+func (fr *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	fr.lookups++
+	if fr.err != nil {
+		return "", nil, fr.err
+	}
+	return name, fr.records, nil
+}
+
+func (fr *fakeResolver) set(records []*net.SRV, err error) {
+	fr.lock.Lock()
+	fr.records, fr.err = records, err
+	fr.lock.Unlock()
+}
+
+func (fr *fakeResolver) Lookups() int {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	return fr.lookups
+}
+
+// waitForServers advances the clock until the client's servers match want, or
+// fails the test after a second.
+func waitForServers(t *testing.T, fc *fakeClock, client *Client, want []string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !sameStrings(client.servers.Values(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("GOT: %v; WANT: %v", client.servers.Values(), want)
+		}
+		fc.Advance(time.Minute)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestResolveSRV(t *testing.T) {
+	t.Run("error", func(t *testing.T) {
+		_, err := resolveSRV(context.Background(), &fakeResolver{err: errors.New("no such host")}, "_range._tcp.example.com")
+		ensureError(t, err, "no such host")
+	})
+
+	t.Run("lowest priority by weight", func(t *testing.T) {
+		resolver := &fakeResolver{records: []*net.SRV{
+			{Target: "backup.example.com.", Port: 8081, Priority: 20, Weight: 100},
+			{Target: "light.example.com.", Port: 8081, Priority: 10, Weight: 5},
+			{Target: "heavy.example.com.", Port: 8082, Priority: 10, Weight: 50},
+		}}
+		servers, err := resolveSRV(context.Background(), resolver, "_range._tcp.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"heavy.example.com:8082", "light.example.com:8081"}
+		if got, want := len(servers), len(want); got != want {
+			t.Fatalf("GOT: %v; WANT: %v", got, want)
+		}
+		for i := range want {
+			if got, want := servers[i], want[i]; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		}
+	})
+}
+
+func TestClientSRVRecord(t *testing.T) {
+	t.Run("negative refresh interval", func(t *testing.T) {
+		_, err := NewClient(&Config{SRVRecord: "_range._tcp.example.com", SRVResolver: &fakeResolver{}, SRVRefreshInterval: -time.Second})
+		ensureError(t, err, "negative")
+	})
+
+	t.Run("resolution fails without servers", func(t *testing.T) {
+		_, err := NewClient(&Config{SRVRecord: "_range._tcp.example.com", SRVResolver: &fakeResolver{err: errors.New("no such host")}})
+		ensureError(t, err, "no such host")
+	})
+
+	t.Run("resolution fails with servers", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			Servers:     []string{"fallback.example.com"},
+			SRVRecord:   "_range._tcp.example.com",
+			SRVResolver: &fakeResolver{err: errors.New("no such host")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		ensureStringSlicesMatch(t, client.servers.Values(), []string{"fallback.example.com"})
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		fc := newFakeClock()
+		resolver := &fakeResolver{records: []*net.SRV{{Target: "one.example.com.", Port: 8081}}}
+
+		client, err := NewClient(&Config{
+			Clock:       fc,
+			Servers:     []string{"fallback.example.com"},
+			SRVRecord:   "_range._tcp.example.com",
+			SRVResolver: resolver,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		ensureStringSlicesMatch(t, client.servers.Values(), []string{"one.example.com:8081"})
+
+		resolver.set([]*net.SRV{{Target: "one.example.com.", Port: 8081}, {Target: "two.example.com.", Port: 8081}}, nil)
+		waitForServers(t, fc, client, []string{"one.example.com:8081", "two.example.com:8081"})
+
+		// Neither failed nor empty resolutions replace the servers.
+		resolver.set(nil, errors.New("no such host"))
+		lookups := resolver.Lookups()
+		for resolver.Lookups() < lookups+2 {
+			fc.Advance(time.Minute)
+			time.Sleep(time.Millisecond)
+		}
+		resolver.set(nil, nil)
+		lookups = resolver.Lookups()
+		for resolver.Lookups() < lookups+2 {
+			fc.Advance(time.Minute)
+			time.Sleep(time.Millisecond)
+		}
+		ensureStringSlicesMatch(t, client.servers.Values(), []string{"one.example.com:8081", "two.example.com:8081"})
+
+		resolver.set([]*net.SRV{{Target: "three.example.com.", Port: 8081}}, nil)
+		waitForServers(t, fc, client, []string{"three.example.com:8081"})
+	})
+
+	t.Run("close stops refresh", func(t *testing.T) {
+		fc := newFakeClock()
+		resolver := &fakeResolver{records: []*net.SRV{{Target: "one.example.com.", Port: 8081}}}
+
+		client, err := NewClient(&Config{Clock: fc, SRVRecord: "_range._tcp.example.com", SRVResolver: resolver})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = client.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		lookups := resolver.Lookups()
+		for i := 0; i < 10; i++ {
+			fc.Advance(time.Minute)
+			time.Sleep(time.Millisecond)
+		}
+		if got, want := resolver.Lookups(), lookups; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package orange
+
+import "net/http"
+
+// DoerFunc is an adapter to allow the use of an ordinary function as the
+// HTTPClient of a Client, such as to stub the responses of range servers in
+// tests with a single closure.  If f is a function with the appropriate
+// signature, DoerFunc(f) is a Doer that calls f.
+//
+// For instance, to simulate a range server that rejects a long query sent
+// using GET with 414 Request URI Too Long, then resolves it when sent again
+// using PUT:
+//
+//     doer := orange.DoerFunc(func(request *http.Request) (*http.Response, error) {
+//         response := &http.Response{
+//             Header:  make(http.Header),
+//             Body:    ioutil.NopCloser(strings.NewReader("host1\nhost2\n")),
+//             Request: request,
+//         }
+//         if request.Method == http.MethodGet {
+//             response.StatusCode = http.StatusRequestURITooLong
+//             response.Body = ioutil.NopCloser(strings.NewReader(""))
+//         } else {
+//             response.StatusCode = http.StatusOK
+//         }
+//         response.Status = fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode))
+//         return response, nil
+//     })
+//
+//     client, err := orange.NewClient(&orange.Config{
+//         HTTPClient: doer,
+//         Servers:    []string{"range.example.com"},
+//     })
+type DoerFunc func(*http.Request) (*http.Response, error)
+
+// Do calls f(request).
+func (f DoerFunc) Do(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
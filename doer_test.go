@@ -0,0 +1,92 @@
+package orange
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDoerFunc(t *testing.T) {
+	var methods []string
+	doer := DoerFunc(func(request *http.Request) (*http.Response, error) {
+		methods = append(methods, request.Method)
+		response := &http.Response{
+			Header:  make(http.Header),
+			Body:    ioutil.NopCloser(strings.NewReader("host1\nhost2\n")),
+			Request: request,
+		}
+		if request.Method == http.MethodGet {
+			response.StatusCode = http.StatusRequestURITooLong
+			response.Body = ioutil.NopCloser(strings.NewReader(""))
+		} else {
+			response.StatusCode = http.StatusOK
+		}
+		response.Status = fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode))
+		return response, nil
+	})
+
+	client, err := NewClient(&Config{
+		HTTPClient: doer,
+		Servers:    []string{"range.example.com"},
+	})
+	ensureError(t, err)
+
+	values, err := client.Query("%foo")
+	ensureError(t, err)
+	ensureStringSlicesMatch(t, values, []string{"host1", "host2"})
+	if got, want := strings.Join(methods, ","), "GET,PUT"; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
+
+// roundTripperFunc is an adapter to allow the use of an ordinary function as
+// an http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func TestClientWrapTransport(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Wrapped") + "\n"))
+	}
+
+	t.Run("wrapped", func(t *testing.T) {
+		withTestServer(t, h, func(server *httptest.Server) {
+			var wrapped http.RoundTripper
+			client, err := NewClient(&Config{
+				Servers: []string{strings.TrimPrefix(server.URL, "http://")},
+				WrapTransport: func(next http.RoundTripper) http.RoundTripper {
+					wrapped = next
+					return roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+						request = request.Clone(request.Context())
+						request.Header.Set("X-Wrapped", "true")
+						return next.RoundTrip(request)
+					})
+				},
+			})
+			ensureError(t, err)
+			defer client.Close()
+
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"true"})
+			if got, want := wrapped == http.RoundTripper(client.transport), true; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		servers := []string{"range.example.com"}
+		wrap := func(next http.RoundTripper) http.RoundTripper { return next }
+		_, err := NewClient(&Config{HTTPClient: http.DefaultClient, Servers: servers, WrapTransport: wrap})
+		ensureError(t, err, "both HTTPClient and WrapTransport")
+		_, err = NewClient(&Config{Servers: servers, WrapTransport: func(http.RoundTripper) http.RoundTripper { return nil }})
+		ensureError(t, err, "WrapTransport that returns nil")
+	})
+}
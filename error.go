@@ -1,31 +1,258 @@
 package orange
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
 	"net"
+	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
 // ErrRangeException is returned when the response includes an HTTP
 // 'RangeException' header.
 type ErrRangeException struct {
-	Message string
+	Message string // Message is the entire value of the RangeException header.
+	Code    string // Code is the category of a message of the form "CODE: detail", or empty.
+	Detail  string // Detail is the remainder of a message of the form "CODE: detail", or empty.
 }
 
 func (err ErrRangeException) Error() string {
 	return "RangeException: " + err.Message
 }
 
+// Is returns true when target is an ErrRangeException with either the same
+// Message or an empty Message, and either the same Code or an empty Code, so
+// callers can test for any range exception using errors.Is(err,
+// ErrRangeException{}), or for a category using errors.Is(err,
+// ErrRangeException{Code: RangeExceptionNoSuchCluster}).
+func (err ErrRangeException) Is(target error) bool {
+	t, ok := target.(ErrRangeException)
+	return ok && (t.Message == "" || t.Message == err.Message) && (t.Code == "" || t.Code == err.Code)
+}
+
+// ErrURITooLong is returned when the client is configured to reject long
+// queries rather than send them using the PUT method, and the URI for a query
+// is too long to send using the GET method.
+type ErrURITooLong struct {
+	Length      int  // Length is the number of characters in the URI.
+	Threshold   int  // Threshold is the maximum URI length, or 0 when the server rejected the URI.
+	PutDisabled bool // PutDisabled is true when the client cannot send the query using PUT because of DisablePut.
+}
+
+func (err ErrURITooLong) Error() string {
+	var message string
+	if err.Threshold > 0 {
+		message = fmt.Sprintf("URI too long: %d characters exceeds threshold of %d", err.Length, err.Threshold)
+	} else {
+		message = fmt.Sprintf("URI too long: %d characters rejected by server", err.Length)
+	}
+	if err.PutDisabled {
+		message += ", and the query cannot be sent using PUT in GET-only mode"
+	}
+	return message
+}
+
+// ErrRequestTooLarge is returned when a query must be sent using the PUT
+// method, but its body is larger than the server's configured MaxBodySize.
+type ErrRequestTooLarge struct {
+	Length int // Length is the number of bytes in the request body.
+	Limit  int // Limit is the server's configured MaxBodySize.
+}
+
+func (err ErrRequestTooLarge) Error() string {
+	return fmt.Sprintf("request too large: %d bytes exceeds limit of %d", err.Length, err.Limit)
+}
+
+// ErrTooManyResults is returned when a query made with WithMaxResults resolves
+// to more values than allowed.
+type ErrTooManyResults struct {
+	Count int // Count is the number of values the query resolved to.
+	Limit int // Limit is the maximum number of values allowed.
+}
+
+func (err ErrTooManyResults) Error() string {
+	return fmt.Sprintf("too many results: %d values exceeds limit of %d", err.Count, err.Limit)
+}
+
+// ErrQueryBudgetExceeded is returned when the client is configured with a
+// MaxQueryDuration, and it elapses before a query succeeds.
+type ErrQueryBudgetExceeded struct {
+	Budget time.Duration // Budget is the configured MaxQueryDuration.
+	Err    error         // Err is the error of the final attempt, or the context's error when none completed.
+}
+
+func (err ErrQueryBudgetExceeded) Error() string {
+	return fmt.Sprintf("query exceeded budget of %s: %s", err.Budget, err.Err)
+}
+
+// Unwrap returns the error of the final attempt.
+func (err ErrQueryBudgetExceeded) Unwrap() error {
+	return err.Err
+}
+
+// ErrResponseTooLarge is returned when the client is configured with a
+// MaxResponseSize, and a range server responds to a query with a larger body.
+type ErrResponseTooLarge struct {
+	Limit int64 // Limit is the configured MaxResponseSize.
+}
+
+func (err ErrResponseTooLarge) Error() string {
+	return fmt.Sprintf("response too large: exceeds limit of %d bytes", err.Limit)
+}
+
+// ErrInvalidQuery is returned when the client is configured to validate
+// queries, and a query expression cannot be sent as a well-formed URI.
+type ErrInvalidQuery struct {
+	Expression string // Expression is the rejected query expression.
+	Reason     string // Reason describes why the expression was rejected.
+}
+
+func (err ErrInvalidQuery) Error() string {
+	return "invalid query: " + err.Reason
+}
+
+// isQueryError returns true when err describes a problem with the query itself
+// rather than with the range server that received it, so sending the same
+// query to another server would result in the same error.
+func isQueryError(err error) bool {
+	switch err.(type) {
+	case ErrInvalidQuery, ErrRangeException, ErrURITooLong:
+		return true
+	}
+	return false
+}
+
 // ErrStatusNotOK is returned when the response status code is not Ok.
 type ErrStatusNotOK struct {
-	Body       []byte // Body contains the HTTP response body from the server.
-	Status     string // Status is the canonical HTTP status message.
-	StatusCode int    // StatusCode contains the numerical HTTP status code from the server.
+	Body       []byte        // Body contains the HTTP response body from the server, truncated to MaxErrorBodySize bytes.
+	Message    string        // Message contains a concise summary of an HTML error page, when enabled.
+	RetryAfter time.Duration // RetryAfter is the delay requested by the Retry-After header of a 429 or 503 response.
+	Status     string        // Status is the canonical HTTP status message.
+	StatusCode int           // StatusCode contains the numerical HTTP status code from the server.
 }
 
+// Error returns the status, followed by either the summary of an HTML error
+// page, or a snippet of the body when it does not appear to be HTML.
 func (err ErrStatusNotOK) Error() string {
+	if err.Message != "" {
+		return err.Status + ": " + err.Message
+	}
+	if snippet := bodySnippet(err.Body); snippet != "" {
+		return err.Status + ": " + snippet
+	}
 	return err.Status
 }
 
+// MaxErrorBodySize is the most bytes of the body of a response with an error
+// status the client reads into ErrStatusNotOK, so a huge error page neither
+// consumes much memory nor delays the error.
+const MaxErrorBodySize = 4096
+
+// maxErrorSnippet is the most bytes of an error body included in the message
+// of ErrStatusNotOK.
+const maxErrorSnippet = 80
+
+// bodySnippet returns the text of body with its white space collapsed, and
+// truncated when long.  It returns the empty string when body appears to be
+// HTML, whose markup would bury any message.
+func bodySnippet(body []byte) string {
+	text := strings.Join(strings.Fields(string(body)), " ")
+	if strings.HasPrefix(text, "<") {
+		return ""
+	}
+	return truncate(text, maxErrorSnippet)
+}
+
+// Is returns true when target is an ErrStatusNotOK with either the same
+// StatusCode or a zero StatusCode, so callers can test for a particular status
+// code using one of the sentinel errors below, such as
+// errors.Is(err, ErrTooManyRequests), or for any status code using
+// errors.Is(err, ErrStatusNotOK{}).
+func (err ErrStatusNotOK) Is(target error) bool {
+	t, ok := target.(ErrStatusNotOK)
+	return ok && (t.StatusCode == 0 || t.StatusCode == err.StatusCode)
+}
+
+// Sentinel errors for common status codes returned by range servers, for use
+// with errors.Is.
+var (
+	ErrBadGateway          = newErrStatusNotOK(http.StatusBadGateway)
+	ErrGatewayTimeout      = newErrStatusNotOK(http.StatusGatewayTimeout)
+	ErrInternalServerError = newErrStatusNotOK(http.StatusInternalServerError)
+	ErrNotFound            = newErrStatusNotOK(http.StatusNotFound)
+	ErrServiceUnavailable  = newErrStatusNotOK(http.StatusServiceUnavailable)
+	ErrTooManyRequests     = newErrStatusNotOK(http.StatusTooManyRequests)
+)
+
+func newErrStatusNotOK(code int) ErrStatusNotOK {
+	return ErrStatusNotOK{Status: fmt.Sprintf("%d %s", code, http.StatusText(code)), StatusCode: code}
+}
+
+// summarizeHTML returns a concise message from an HTML error page: the text of
+// its title element, or when it has none, the first non-blank line of text
+// remaining after markup is removed.  It returns the empty string when the
+// body does not appear to be HTML.
+func summarizeHTML(contentType string, body []byte) string {
+	trimmed := bytes.TrimSpace(body)
+	if !strings.Contains(strings.ToLower(contentType), "html") && !bytes.HasPrefix(trimmed, []byte("<")) {
+		return ""
+	}
+
+	lower := bytes.ToLower(trimmed)
+	if i := bytes.Index(lower, []byte("<title")); i >= 0 {
+		if j := bytes.IndexByte(lower[i:], '>'); j >= 0 {
+			start := i + j + 1
+			if k := bytes.Index(lower[start:], []byte("</title")); k >= 0 {
+				if title := collapseSpace(html.UnescapeString(string(trimmed[start : start+k]))); title != "" {
+					return title
+				}
+			}
+		}
+	}
+
+	// No usable title, so strip the markup and return the first line of text.
+	text := make([]byte, 0, len(trimmed))
+	var inTag bool
+	for _, b := range trimmed {
+		switch {
+		case b == '<':
+			inTag = true
+		case b == '>':
+			inTag = false
+		case !inTag:
+			text = append(text, b)
+		}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for scanner.Scan() {
+		if line := collapseSpace(html.UnescapeString(scanner.Text())); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// collapseSpace trims leading and trailing white space and replaces each run of
+// interior white space with a single space.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// isContextError returns true when err is the error of a closed context, or a
+// network error caused by one.
+func isContextError(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
 ////////////////////////////////////////
 // Some utility functions for the default method of whether or not a query with
 // an error result ought to be retried.
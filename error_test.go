@@ -0,0 +1,117 @@
+package orange
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestErrorsIs(t *testing.T) {
+	t.Run("status code", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		}
+		withClient(t, h, func(client *Client) {
+			_, err := client.Query("%foo")
+			if got, want := errors.Is(err, ErrTooManyRequests), true; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := errors.Is(err, ErrStatusNotOK{}), true; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := errors.Is(err, ErrServiceUnavailable), false; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+
+			var e ErrStatusNotOK
+			if !errors.As(err, &e) {
+				t.Fatalf("GOT: %T; WANT: %T", err, e)
+			}
+			if got, want := e.StatusCode, http.StatusTooManyRequests; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+
+	t.Run("range exception", func(t *testing.T) {
+		err := error(ErrRangeException{Message: "bad query"})
+		if got, want := errors.Is(err, ErrRangeException{}), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := errors.Is(err, ErrRangeException{Message: "bad query"}), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := errors.Is(err, ErrRangeException{Message: "other"}), false; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("network error", func(t *testing.T) {
+		client, err := NewClient(&Config{Servers: []string{"127.0.0.1:1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Query("%foo")
+		var e *url.Error
+		if !errors.As(err, &e) {
+			t.Errorf("GOT: %T; WANT: %T", err, e)
+		}
+	})
+}
+
+func TestSummarizeHTML(t *testing.T) {
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"plain text", "text/plain", "upstream failed\nsecond line\n", ""},
+		{"title", "text/html", "<html><head><title>\n  503 Service\n  Unavailable </title></head><body>ignored</body></html>", "503 Service Unavailable"},
+		{"title with entities", "text/html; charset=utf-8", "<title>Bad &amp; Broken</title>", "Bad & Broken"},
+		{"empty title", "text/html", "<html><title> </title><body>\n\n<h1>Gateway Timeout</h1>\n<p>more</p></body></html>", "Gateway Timeout"},
+		{"no title", "text/html", "<html>\n<body>\n<h1>Proxy Error</h1>\n<p>details</p>\n</body>\n</html>", "Proxy Error"},
+		{"sniffed markup", "", "<html><title>Bad Gateway</title></html>", "Bad Gateway"},
+		{"empty", "text/html", "", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got, want := summarizeHTML(c.contentType, []byte(c.body)), c.want; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestErrStatusNotOKBody(t *testing.T) {
+	t.Run("bounded", func(t *testing.T) {
+		long := strings.Repeat("x", 2*MaxErrorBodySize)
+		h := func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "no such host "+long, http.StatusBadRequest)
+		}
+		withClient(t, h, func(client *Client) {
+			_, err := client.Query("%foo")
+			e, ok := err.(ErrStatusNotOK)
+			if !ok {
+				t.Fatalf("GOT: %T; WANT: %T", err, e)
+			}
+			if got, want := len(e.Body), MaxErrorBodySize; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			want := "400 Bad Request: " + ("no such host " + long)[:maxErrorSnippet] + "..."
+			if got := err.Error(); got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+
+	t.Run("html", func(t *testing.T) {
+		e := ErrStatusNotOK{Body: []byte("<html><body>oops</body></html>"), Status: "500 Internal Server Error"}
+		if got, want := e.Error(), "500 Internal Server Error"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package orange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// estimatedBytesPerValue is the assumed average size of a single value in a
+// range server's list response, including its terminating newline, used when
+// estimating a result count from the response's Content-Length.
+const estimatedBytesPerValue = 24
+
+// EstimateResultCount returns an estimate of the number of values the range
+// query expression would return, without retrieving the full result, so
+// callers can decide whether to stream the result rather than collect it in
+// memory.
+//
+// It first asks the range server's count endpoint, whose answer is exact.
+// When the server does not provide a count endpoint, it sends a HEAD request
+// for the query and estimates the count from the Content-Length of the
+// response, which is only a rough approximation.  Servers are selected and
+// the request is retried as per the client's configuration.  Use CountCtx
+// when an exact count is required even from servers without a count
+// endpoint.
+//
+//     estimate, err := client.EstimateResultCount(ctx, "%someQuery")
+//     if err != nil {
+//         fmt.Fprintf(os.Stderr, "%s", err)
+//         os.Exit(1)
+//     }
+//     if estimate > 100000 {
+//         // stream the result using QueryCallback
+//     }
+func (c *Client) EstimateResultCount(ctx context.Context, expression string) (int, error) {
+	return c.observeCount(ctx, expression, c.estimate)
+}
+
+// observeCount sends the query expression using count, just as observeQuery
+// does, and returns the count of values of the successful attempt.
+func (c *Client) observeCount(ctx context.Context, expression string, count func(ctx context.Context, expression, server string) (int, error)) (int, error) {
+	var result int
+	_, err := c.observeQuery(ctx, expression, func(ctx context.Context, server string) error {
+		n, err := count(ctx, expression, server)
+		if err == nil {
+			result = n // only the winning request of a hedged attempt succeeds
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// estimate returns the count of values the query expression would return from
+// the specified server's count endpoint, falling back to estimating the count
+// from the Content-Length of a HEAD request for the query.
+func (c *Client) estimate(ctx context.Context, expression, server string) (int, error) {
+	count, ok, err := c.countFromEndpoint(ctx, expression, server)
+	if err != nil || ok {
+		return count, err
+	}
+
+	if err := c.admitRequest(ctx, server); err != nil {
+		return 0, err
+	}
+	defer c.releaseSlot()
+
+	response, err := c.sendEstimate(ctx, http.MethodHead, c.endpoint(server, listPath)+"?"+url.QueryEscape(expression))
+	if err != nil {
+		return 0, err
+	}
+	_ = discard(response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return 0, c.statusNotOK(response)
+	}
+	if response.ContentLength <= 0 {
+		return 0, nil // unknown length cannot be estimated
+	}
+	return int((response.ContentLength + estimatedBytesPerValue - 1) / estimatedBytesPerValue), nil
+}
+
+// countFromEndpoint returns the count of values from the specified server's
+// count endpoint, and false when the server has no count endpoint.
+func (c *Client) countFromEndpoint(ctx context.Context, expression, server string) (int, bool, error) {
+	uri := c.endpoint(server, countPath) + "?" + url.QueryEscape(expression)
+
+	if c.validateQueries {
+		if err := validateQuery(expression, uri); err != nil {
+			return 0, false, err
+		}
+	}
+
+	if err := c.admitRequest(ctx, server); err != nil {
+		return 0, false, err
+	}
+	defer c.releaseSlot()
+
+	return c.requestCount(ctx, uri)
+}
+
+// requestCount returns the count of values from the count endpoint uri of a
+// range server, and false when the server has no count endpoint.
+func (c *Client) requestCount(ctx context.Context, uri string) (int, bool, error) {
+	response, err := c.sendEstimate(ctx, http.MethodGet, uri)
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		buf, err := c.readBody(response, 0)
+		if err != nil {
+			return 0, false, err
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+		if err != nil {
+			return 0, false, fmt.Errorf("cannot parse count from range server: %s", err)
+		}
+		return count, true, nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		_ = discard(response.Body) // server has no count endpoint
+		return 0, false, nil
+	default:
+		return 0, false, c.statusNotOK(response)
+	}
+}
+
+// sendEstimate sends a request for an estimate, returning ErrRangeException
+// when the range server rejected the query expression.
+func (c *Client) sendEstimate(ctx context.Context, method, uri string) (*http.Response, error) {
+	request, err := http.NewRequest(method, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.prepareRequest(ctx, request)
+	if method == http.MethodHead {
+		// The estimate is based on the length of the uncompressed response.
+		request.Header.Del("Accept-Encoding")
+	}
+
+	request = request.WithContext(ctx)
+	if err := c.runMiddleware(request); err != nil {
+		return nil, err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if message := response.Header.Get("RangeException"); message != "" {
+		_ = discard(response.Body)
+		return nil, newRangeException(message)
+	}
+	if response.StatusCode == http.StatusOK && !claimResponse(ctx) {
+		_ = discard(response.Body) // another hedged request already won
+		return nil, errHedgeLost
+	}
+	return response, nil
+}
@@ -0,0 +1,106 @@
+package orange
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestEstimateResultCount(t *testing.T) {
+	t.Run("count endpoint", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if got, want := r.URL.Path, "/range/count"; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := r.URL.RawQuery, "%25foo"; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			_, _ = w.Write([]byte("42\n"))
+		}
+		withClient(t, h, func(client *Client) {
+			count, err := client.EstimateResultCount(context.Background(), "%foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := count, 42; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+
+	t.Run("falls back to content length", func(t *testing.T) {
+		var methods []string
+		h := func(w http.ResponseWriter, r *http.Request) {
+			methods = append(methods, r.Method+" "+r.URL.Path)
+			if r.URL.Path != "/range/list" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(10*estimatedBytesPerValue))
+		}
+		withClient(t, h, func(client *Client) {
+			count, err := client.EstimateResultCount(context.Background(), "%foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := count, 10; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+		ensureStringSlicesMatch(t, methods, []string{"GET /range/count", "HEAD /range/list"})
+	})
+
+	t.Run("range exception", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("RangeException", "bad query")
+		}
+		withClient(t, h, func(client *Client) {
+			_, err := client.EstimateResultCount(context.Background(), "%foo")
+			if _, ok := err.(ErrRangeException); !ok {
+				t.Errorf("GOT: %T; WANT: %T", err, ErrRangeException{})
+			}
+		})
+	})
+
+	t.Run("retries server errors", func(t *testing.T) {
+		var requests int
+		h := func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				http.Error(w, "oops", http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte("7"))
+		}
+		configure := func(config *Config) {
+			config.RetryCallback = func(error) bool { return true }
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			count, err := client.EstimateResultCount(context.Background(), "%foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := count, 7; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+}
+
+func TestEstimateResultCountCanonicalizesHTMLErrors(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("<html><head><title>upstream unavailable</title></head></html>"))
+	}
+	configure := func(config *Config) { config.CanonicalizeHTMLErrors = true }
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		_, queryErr := client.Query("%foo")
+		_, estimateErr := client.EstimateResultCount(context.Background(), "%foo")
+		ensureError(t, estimateErr, "502 Bad Gateway: upstream unavailable")
+		if got, want := estimateErr.Error(), queryErr.Error(); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
@@ -32,7 +32,7 @@ func main() {
 	}
 
 	if flag.NArg() == 0 {
-		fmt.Fprintf(os.Stderr, "USAGE: %s [-timeout DURATION] q1 q2\n")
+		fmt.Fprintf(os.Stderr, "USAGE: %s [-timeout DURATION] q1 q2\n", os.Args[0])
 		os.Exit(1)
 	}
 
@@ -0,0 +1,82 @@
+package orange
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ResponseFormat is the format in which the client asks range servers to
+// return the values of a query.
+type ResponseFormat int
+
+const (
+	// TextResponse asks for the values of a query one per line.  It is the
+	// default format.
+	TextResponse ResponseFormat = iota
+
+	// JSONResponse asks for the values of a query as a JSON array of strings,
+	// which newer range servers return when sent the Accept header with
+	// application/json, avoiding any ambiguity in splitting a text body.
+	JSONResponse
+)
+
+func (f ResponseFormat) String() string {
+	switch f {
+	case TextResponse:
+		return "TextResponse"
+	case JSONResponse:
+		return "JSONResponse"
+	}
+	return fmt.Sprintf("ResponseFormat(%d)", int(f))
+}
+
+// setAcceptHeader asks for the client's response format in request.
+func (c *Client) setAcceptHeader(request *http.Request) {
+	if c.responseFormat == JSONResponse {
+		request.Header.Set("Accept", "application/json")
+	}
+}
+
+// appendValues returns a callback that appends each value of the response
+// body to lines.  When the client asks for JSON, but the server ignores the
+// request and responds with text, the response is parsed as text.
+func (c *Client) appendValues(lines *[]string) func(io.Reader) error {
+	if c.responseFormat != JSONResponse {
+		return appendLines(lines, c.preserveLineEndings)
+	}
+	text := appendLines(lines, c.preserveLineEndings)
+	return func(ior io.Reader) error {
+		br := bufio.NewReader(ior)
+		if !startsJSONArray(br) {
+			return text(br)
+		}
+		var values []string
+		if err := json.NewDecoder(br).Decode(&values); err != nil {
+			return ErrParse{Err: err}
+		}
+		*lines = append(*lines, values...)
+		return nil
+	}
+}
+
+// startsJSONArray returns true when the first byte after any leading white
+// space in br opens a JSON array.  It does not consume the opening byte.
+func startsJSONArray(br *bufio.Reader) bool {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return false
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = br.ReadByte()
+		case '[':
+			return true
+		default:
+			return false
+		}
+	}
+}
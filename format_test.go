@@ -0,0 +1,86 @@
+package orange
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestClientResponseFormat(t *testing.T) {
+	t.Run("unknown", func(t *testing.T) {
+		_, err := NewClient(&Config{Servers: []string{"localhost"}, ResponseFormat: ResponseFormat(42)})
+		ensureError(t, err, "unknown ResponseFormat: ResponseFormat(42)")
+	})
+
+	t.Run("text", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if got, want := r.Header.Get("Accept"), ""; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			w.Write([]byte("result1\nresult2\n"))
+		}
+		withClient(t, h, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+		})
+	})
+
+	configure := func(config *Config) { config.ResponseFormat = JSONResponse }
+
+	t.Run("json", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if got, want := r.Header.Get("Accept"), "application/json"; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(` ["result1", "with\nnewline", "with,comma"]`))
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			if got, want := strings.Join(values, "|"), "result1|with\nnewline|with,comma"; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+		})
+	})
+
+	t.Run("json expected but text received", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1\nresult2\n"))
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+		})
+	})
+
+	t.Run("json empty", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("[]"))
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			if got, want := len(values), 0; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+
+	t.Run("json malformed", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`["result1", 42]`))
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query("foo")
+			var e ErrParse
+			if !errors.As(err, &e) {
+				t.Fatalf("GOT: %T; WANT: %T", err, e)
+			}
+			ensureError(t, err, "cannot parse response: json")
+		})
+	})
+}
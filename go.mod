@@ -1,3 +1,5 @@
 module github.com/karrick/orange
 
-go 1.12
+go 1.13
+
+require golang.org/x/time v0.3.0
@@ -0,0 +1,81 @@
+package orange
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DefaultGzipLongQueryThreshold is the smallest request body, in bytes,
+// compressed when GzipLongQueries is true and no GzipLongQueryThreshold is
+// provided.
+const DefaultGzipLongQueryThreshold = 1024
+
+// gzipBytes returns s compressed using gzip.
+func gzipBytes(s string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// setAcceptEncodingHeader asks range servers to compress their responses when
+// the client is configured to accept gzip.  Because the header is set by the
+// client rather than by the http.Transport, the transport does not decompress
+// the response, and decodeBody must.
+func (c *Client) setAcceptEncodingHeader(request *http.Request) {
+	if c.acceptGzip {
+		request.Header.Set("Accept-Encoding", "gzip")
+	}
+}
+
+// decodeBody returns a reader of the body of response, decompressed when the
+// client accepts gzip and the server compressed the response.  Closing the
+// returned reader releases the decompressor, but does not close the response
+// body, which the caller still must close.  A body that cannot be decompressed
+// results in ErrParse.
+func (c *Client) decodeBody(response *http.Response) (io.ReadCloser, error) {
+	if !c.acceptGzip || !strings.EqualFold(response.Header.Get("Content-Encoding"), "gzip") {
+		return ioutil.NopCloser(response.Body), nil
+	}
+	zr, err := gzip.NewReader(response.Body)
+	if err == io.EOF {
+		return ioutil.NopCloser(response.Body), nil // an empty body has no gzip header
+	}
+	if err != nil {
+		return nil, ErrParse{Err: err}
+	}
+	return zr, nil
+}
+
+// readBody returns the decoded body of response, and closes the response body.
+// When limit is positive, it reads no more than limit bytes of the decoded
+// body, and abandons the remainder.
+func (c *Client) readBody(response *http.Response, limit int64) ([]byte, error) {
+	decoded, err := c.decodeBody(response)
+	if err != nil {
+		_ = response.Body.Close()
+		return nil, err
+	}
+	var ior io.Reader = decoded
+	if limit > 0 {
+		ior = io.LimitReader(decoded, limit)
+	}
+	buf, err := ioutil.ReadAll(ior)
+	_ = decoded.Close()
+	if err2 := response.Body.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,168 @@
+package orange
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func mustGzip(tb testing.TB, s string) []byte {
+	buf, err := gzipBytes(s)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return buf
+}
+
+// gzipHandler compresses body when the request accepts gzip.
+func gzipHandler(tb testing.TB, status int, body string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(status)
+		w.Write(mustGzip(tb, body))
+	}
+}
+
+func TestClientAcceptGzip(t *testing.T) {
+	t.Run("decompressed", func(t *testing.T) {
+		var encodings []string
+		h := gzipHandler(t, http.StatusOK, "result1\nresult2\n")
+		recording := func(w http.ResponseWriter, r *http.Request) {
+			encodings = append(encodings, r.Header.Get("Accept-Encoding"))
+			h(w, r)
+		}
+		configure := func(config *Config) { config.AcceptGzip = true }
+		withConfiguredClient(t, recording, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+		})
+		ensureStringSlicesMatch(t, encodings, []string{"gzip"})
+	})
+
+	t.Run("not compressed by server", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1\nresult2\n"))
+		}
+		configure := func(config *Config) { config.AcceptGzip = true }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+		})
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		configure := func(config *Config) { config.AcceptGzip = true }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			if got, want := len(values), 0; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte("result1\n"))
+		}
+		configure := func(config *Config) { config.AcceptGzip = true }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query("foo")
+			if _, ok := err.(ErrParse); !ok {
+				t.Errorf("GOT: %v; WANT: %T", err, ErrParse{})
+			}
+		})
+	})
+
+	t.Run("limits decompressed size", func(t *testing.T) {
+		body := strings.Repeat("result\n", 1000)
+		if compressed := len(mustGzip(t, body)); compressed >= 100 {
+			t.Fatalf("GOT: %v; WANT: < 100", compressed)
+		}
+		configure := func(config *Config) {
+			config.AcceptGzip = true
+			config.MaxResponseSize = 100
+		}
+		withConfiguredClient(t, gzipHandler(t, http.StatusOK, body), configure, func(client *Client) {
+			_, err := client.Query("foo")
+			if _, ok := err.(ErrResponseTooLarge); !ok {
+				t.Errorf("GOT: %v; WANT: %T", err, ErrResponseTooLarge{})
+			}
+		})
+	})
+
+	t.Run("error body", func(t *testing.T) {
+		configure := func(config *Config) { config.AcceptGzip = true }
+		withConfiguredClient(t, gzipHandler(t, http.StatusNotFound, "no such endpoint\n"), configure, func(client *Client) {
+			_, err := client.Query("foo")
+			e, ok := err.(ErrStatusNotOK)
+			if !ok {
+				t.Fatalf("GOT: %v; WANT: %T", err, e)
+			}
+			if got, want := string(e.Body), "no such endpoint\n"; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+		})
+	})
+}
+
+func TestClientGzipLongQueries(t *testing.T) {
+	var encodings []string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		if r.Method == http.MethodGet {
+			w.Write([]byte(r.URL.RawQuery + "\n"))
+			return
+		}
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = zr
+			r.Header.Del("Content-Encoding")
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(r.Form.Get("query") + "\n"))
+	}
+
+	long := strings.Repeat("%web,", 500)
+	short := strings.Repeat("%db,", 20)
+
+	configure := func(config *Config) {
+		config.GzipLongQueries = true
+		config.QueryLengthThreshold = 64
+	}
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		values, err := client.Query(long)
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{long})
+
+		values, err = client.Query(short)
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{short})
+	})
+	// The long query is compressed, but the short one sent using PUT is not.
+	ensureStringSlicesMatch(t, encodings, []string{"gzip", ""})
+
+	t.Run("negative threshold", func(t *testing.T) {
+		_, err := NewClient(&Config{GzipLongQueryThreshold: -1, Servers: []string{"range.example.com"}})
+		ensureError(t, err, "negative GzipLongQueryThreshold")
+	})
+}
@@ -0,0 +1,37 @@
+package orange
+
+import (
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+// HashingCallback returns a callback for QueryCallback that computes a hash of
+// the response body with h while callback streams it, so callers can obtain
+// both the data and a content hash in one pass without buffering the body.
+// The body is hashed as callback reads it, and any of the body callback does
+// not read is hashed after callback returns.  Because a query may be retried,
+// h is reset each time the returned callback is invoked, so after the query
+// returns, h holds the hash of the response body that was successfully
+// streamed.
+//
+//     h := sha256.New()
+//     err := client.QueryCallback(ctx, "%someQuery", orange.HashingCallback(h, func(r io.Reader) error {
+//         _, err := io.Copy(os.Stdout, r)
+//         return err
+//     }))
+//     if err != nil {
+//         return err
+//     }
+//     sum := h.Sum(nil)
+func HashingCallback(h hash.Hash, callback func(io.Reader) error) func(io.Reader) error {
+	return func(r io.Reader) error {
+		h.Reset()
+		tee := io.TeeReader(r, h)
+		if err := callback(tee); err != nil {
+			return err
+		}
+		_, err := io.Copy(ioutil.Discard, tee) // hash what callback did not read
+		return err
+	}
+}
@@ -0,0 +1,66 @@
+package orange
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestHashingCallback(t *testing.T) {
+	var body bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		body.WriteString("host" + strconv.Itoa(i) + "\n")
+	}
+	want := sha256.Sum256(body.Bytes())
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body.Bytes())
+	}
+
+	t.Run("callback reads entire body", func(t *testing.T) {
+		withClient(t, h, func(client *Client) {
+			hasher := sha256.New()
+			var streamed bytes.Buffer
+			err := client.QueryCallback(context.Background(), "foo", HashingCallback(hasher, func(r io.Reader) error {
+				_, err := io.Copy(&streamed, r)
+				return err
+			}))
+			ensureError(t, err)
+			if got, want := streamed.Len(), body.Len(); got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got := hasher.Sum(nil); !bytes.Equal(got, want[:]) {
+				t.Errorf("GOT: %x; WANT: %x", got, want)
+			}
+		})
+	})
+
+	t.Run("callback reads part of body", func(t *testing.T) {
+		withClient(t, h, func(client *Client) {
+			hasher := sha256.New()
+			err := client.QueryCallback(context.Background(), "foo", HashingCallback(hasher, func(r io.Reader) error {
+				_, err := io.CopyN(ioutil.Discard, r, 10)
+				return err
+			}))
+			ensureError(t, err)
+			if got := hasher.Sum(nil); !bytes.Equal(got, want[:]) {
+				t.Errorf("GOT: %x; WANT: %x", got, want)
+			}
+		})
+	})
+
+	t.Run("resets hash for each response", func(t *testing.T) {
+		hasher := sha256.New()
+		callback := HashingCallback(hasher, func(r io.Reader) error { return nil })
+		ensureError(t, callback(bytes.NewReader([]byte("stale\n"))))
+		ensureError(t, callback(bytes.NewReader(body.Bytes())))
+		if got := hasher.Sum(nil); !bytes.Equal(got, want[:]) {
+			t.Errorf("GOT: %x; WANT: %x", got, want)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package orange
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errHedgeLost is returned by a hedged request that received a successful
+// response after another request for the same attempt already claimed its own
+// successful response.
+var errHedgeLost = errors.New("hedged request lost to a faster server")
+
+// hedgeClaim records which of a set of hedged requests was the first to
+// receive a successful response, so only that response is delivered.
+type hedgeClaim struct {
+	lock   sync.Mutex
+	winner int // winner is the 1-based number of the winning request, or 0.
+}
+
+type hedgeKey struct{}
+
+// hedgeTicket identifies a single hedged request within its claim.
+type hedgeTicket struct {
+	claim *hedgeClaim
+	n     int
+}
+
+// claimResponse returns true when the request using ctx may deliver its
+// successful response.  Requests that are not hedged may always deliver their
+// response.
+func claimResponse(ctx context.Context) bool {
+	t, ok := ctx.Value(hedgeKey{}).(hedgeTicket)
+	if !ok {
+		return true
+	}
+	t.claim.lock.Lock()
+	defer t.claim.lock.Unlock()
+	if t.claim.winner == 0 {
+		t.claim.winner = t.n
+	}
+	return t.claim.winner == t.n
+}
+
+// hedge sends the query to the next range server, and when that server has not
+// responded within the client's hedge delay, also sends it to the following
+// server.  The first successful response wins, and the other request is
+// canceled.  When one request fails while the other is still in flight, hedge
+// waits for the other, so an error from the faster server does not mask a
+// good response from the slower one.  It returns the address of the server
+// whose result it returns.  The second request is only sent when tried allows
+// it.
+func (c *Client) hedge(ctx context.Context, send sendFunc, tried *triedServers) (string, error) {
+	type result struct {
+		n      int
+		server string
+		err    error
+	}
+
+	sequence := tried.preferUntried(c.serverSequence())
+	claim := new(hedgeClaim)
+	results := make(chan result, 2) // buffered so neither request blocks after hedge returns
+	var cancels []context.CancelFunc
+
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+
+	launch := func() {
+		n := len(cancels) + 1
+		server := sequence[n-1]
+		hctx, cancel := context.WithCancel(context.WithValue(ctx, hedgeKey{}, hedgeTicket{claim: claim, n: n}))
+		cancels = append(cancels, cancel)
+		go func() {
+			results <- result{n: n, server: server, err: c.queryServer(hctx, send, server)}
+		}()
+	}
+
+	if !tried.allow(sequence[0]) {
+		return "", errServerLimit
+	}
+	launch()
+	pending := 1
+
+	var timer <-chan time.Time
+	if len(sequence) > 1 {
+		timer = c.clock.After(c.hedgeDelay)
+	}
+
+	var first *result
+	for {
+		select {
+		case <-timer:
+			timer = nil
+			if ctx.Err() == nil && tried.allow(sequence[1]) {
+				launch()
+				pending++
+			}
+		case r := <-results:
+			pending--
+			claim.lock.Lock()
+			winner := claim.winner
+			claim.lock.Unlock()
+			if r.err == nil || winner == r.n {
+				return r.server, r.err
+			}
+			if first == nil && r.err != errHedgeLost {
+				first = &r
+			}
+			if pending == 0 {
+				if first == nil {
+					first = &r
+				}
+				return first.server, first.err
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package orange
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientHedgeDelay(t *testing.T) {
+	withHedgedServers := func(t *testing.T, primary, secondary http.HandlerFunc, callback func(client *Client, secondaryAddress string)) {
+		first := httptest.NewServer(primary)
+		defer first.Close()
+		second := httptest.NewServer(secondary)
+		defer second.Close()
+
+		secondaryAddress := strings.TrimLeft(second.URL, "http://")
+
+		client, err := NewClient(&Config{
+			HedgeDelay: 10 * time.Millisecond,
+			Servers:    []string{strings.TrimLeft(first.URL, "http://"), secondaryAddress},
+		})
+		ensureError(t, err)
+
+		callback(client, secondaryAddress)
+	}
+
+	t.Run("faster server wins and slower request is canceled", func(t *testing.T) {
+		canceled := make(chan struct{})
+		slow := func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			close(canceled)
+		}
+		fast := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("fast\n"))
+		}
+		withHedgedServers(t, slow, fast, func(client *Client, secondaryAddress string) {
+			values, server, err := client.QueryWithServer("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"fast"})
+			if got, want := server, secondaryAddress; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+		select {
+		case <-canceled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("slower request was not canceled")
+		}
+	})
+
+	t.Run("error from faster server does not mask slower response", func(t *testing.T) {
+		slow := func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("slow\n"))
+		}
+		failing := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("RangeException", "some error")
+		}
+		withHedgedServers(t, slow, failing, func(client *Client, _ string) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"slow"})
+		})
+	})
+
+	t.Run("not sent when first server responds in time", func(t *testing.T) {
+		var invocations int32
+		fast := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("fast\n"))
+		}
+		other := func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&invocations, 1)
+		}
+		withHedgedServers(t, fast, other, func(client *Client, _ string) {
+			client.hedgeDelay = time.Minute
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"fast"})
+		})
+		if got, want := atomic.LoadInt32(&invocations), int32(0); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("both fail", func(t *testing.T) {
+		slow := func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		}
+		failing := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("RangeException", "some error")
+		}
+		withHedgedServers(t, slow, failing, func(client *Client, _ string) {
+			_, err := client.Query("foo")
+			ensureError(t, err, "some error")
+		})
+	})
+}
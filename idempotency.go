@@ -0,0 +1,31 @@
+package orange
+
+import (
+	"context"
+	"net/http"
+)
+
+// DefaultIdempotencyKeyHeader is a conventional name for the header that
+// carries a query's idempotency key.
+const DefaultIdempotencyKeyHeader = "X-Idempotency-Key"
+
+// idempotencyKey is the context key for the idempotency key of a query.
+type idempotencyKey struct{}
+
+// withIdempotencyKey returns a copy of ctx that carries a newly generated
+// idempotency key, when the client is configured to send one, so every attempt
+// to send the same query carries the same key.
+func (c *Client) withIdempotencyKey(ctx context.Context) context.Context {
+	if c.idempotencyKeyHeader == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, idempotencyKey{}, c.newIdempotencyKey())
+}
+
+// setIdempotencyKeyHeader adds the idempotency key carried by ctx, if any, to
+// request.
+func (c *Client) setIdempotencyKeyHeader(ctx context.Context, request *http.Request) {
+	if key, ok := ctx.Value(idempotencyKey{}).(string); ok {
+		request.Header.Set(c.idempotencyKeyHeader, key)
+	}
+}
@@ -0,0 +1,86 @@
+package orange
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestClientIdempotencyKey(t *testing.T) {
+	t.Run("shared by retries of one query", func(t *testing.T) {
+		var lock sync.Mutex
+		var keys []string
+
+		h := func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			keys = append(keys, r.Header.Get(DefaultIdempotencyKeyHeader))
+			attempt := len(keys)
+			lock.Unlock()
+			if attempt%3 != 0 {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("result1\n"))
+		}
+		configure := func(config *Config) {
+			config.IdempotencyKeyHeader = DefaultIdempotencyKeyHeader
+			config.RetryCallback = func(error) bool { return true }
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			for i := 0; i < 2; i++ {
+				_, err := client.Query("foo")
+				ensureError(t, err)
+			}
+		})
+
+		if got, want := len(keys), 6; got != want {
+			t.Fatalf("GOT: %v; WANT: %v", got, want)
+		}
+		if keys[0] == "" {
+			t.Errorf("GOT: %q; WANT: non-empty key", keys[0])
+		}
+		for i, key := range keys {
+			if got, want := key, keys[i/3*3]; got != want {
+				t.Errorf("attempt %d: GOT: %v; WANT: %v", i, got, want)
+			}
+		}
+		if keys[0] == keys[3] {
+			t.Errorf("GOT: %q; WANT: distinct keys for distinct queries", keys[3])
+		}
+	})
+
+	t.Run("custom generator", func(t *testing.T) {
+		var keys []string
+		h := func(w http.ResponseWriter, r *http.Request) {
+			keys = append(keys, r.Header.Get("X-Request-Nonce"))
+		}
+		var count int
+		configure := func(config *Config) {
+			config.IdempotencyKeyHeader = "x-request-nonce"
+			config.IdempotencyKeyGenerator = func() string {
+				count++
+				return "nonce-" + strconv.Itoa(count)
+			}
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			for i := 0; i < 2; i++ {
+				_, err := client.Query("foo")
+				ensureError(t, err)
+			}
+		})
+		ensureStringSlicesMatch(t, keys, []string{"nonce-1", "nonce-2"})
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if got, want := r.Header.Get(DefaultIdempotencyKeyHeader), ""; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		}
+		withClient(t, h, func(client *Client) {
+			_, err := client.Query("foo")
+			ensureError(t, err)
+		})
+	})
+}
@@ -0,0 +1,153 @@
+package orange
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyAlpha is used when SelectionStrategy is LeastLatency but no
+// LatencyAlpha is provided, to control how much weight the most recent
+// response time has in a server's moving average latency.
+const DefaultLatencyAlpha = 0.3
+
+// DefaultLatencyRankInterval is used when SelectionStrategy is LeastLatency but
+// no LatencyRankInterval is provided, to control how often servers are
+// re-ranked by their moving average latency.
+const DefaultLatencyRankInterval = 10 * time.Second
+
+// DefaultLatencyProbeEvery is used when SelectionStrategy is LeastLatency but
+// no LatencyProbeEvery is provided, to control how often a query attempt is
+// sent to a slower range server to detect its recovery.
+const DefaultLatencyProbeEvery = 20
+
+// latencyTracker maintains an exponentially weighted moving average of the
+// response time of each range server, and periodically ranks servers from
+// lowest to highest average latency.
+type latencyTracker struct {
+	clock      Clock
+	alpha      float64
+	interval   time.Duration
+	probeEvery int // probeEvery is how many attempts between probes, or 0 to never probe
+
+	lock       sync.Mutex
+	averages   map[string]float64 // nanoseconds
+	measured   map[string]uint64  // measured maps each server to the sequence number of its latest measurement
+	sequence   uint64             // sequence is the number of measurements recorded
+	attempts   int                // attempts counts calls to ProbeDue
+	ranked     []string
+	rankedAt   time.Time
+	unmeasured bool // whether ranked includes unmeasured servers
+}
+
+func newLatencyTracker(clock Clock, alpha float64, interval time.Duration, probeEvery int) *latencyTracker {
+	return &latencyTracker{
+		clock:      clock,
+		alpha:      alpha,
+		interval:   interval,
+		probeEvery: probeEvery,
+		averages:   make(map[string]float64),
+		measured:   make(map[string]uint64),
+	}
+}
+
+// Record folds the response time of a query sent to server into the server's
+// moving average.
+func (lt *latencyTracker) Record(server string, d time.Duration) {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	if average, ok := lt.averages[server]; ok {
+		lt.averages[server] = lt.alpha*float64(d) + (1-lt.alpha)*average
+	} else {
+		lt.averages[server] = float64(d)
+	}
+	lt.sequence++
+	lt.measured[server] = lt.sequence
+}
+
+// ProbeDue counts a query attempt, and returns true when the attempt ought to
+// probe a slower server rather than use the fastest one.
+func (lt *latencyTracker) ProbeDue() bool {
+	if lt.probeEvery == 0 {
+		return false
+	}
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	lt.attempts++
+	return lt.attempts%lt.probeEvery == 0
+}
+
+// Stalest returns the server among servers whose latency was measured least
+// recently, and false when servers is empty.
+func (lt *latencyTracker) Stalest(servers []string) (string, bool) {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	var stalest string
+	var found bool
+	for _, server := range servers {
+		if !found || lt.measured[server] < lt.measured[stalest] {
+			stalest, found = server, true
+		}
+	}
+	return stalest, found
+}
+
+// Average returns the moving average latency of server, and whether it has
+// been measured.
+func (lt *latencyTracker) Average(server string) (time.Duration, bool) {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	average, ok := lt.averages[server]
+	return time.Duration(average), ok
+}
+
+// Ranked returns servers ordered from lowest to highest moving average
+// latency, as of the most recent ranking.  Servers that have not yet been
+// measured are ranked first so they get measured.  Servers are re-ranked when
+// the ranking interval has elapsed, the set of servers has changed, or some
+// servers have not yet been measured.
+func (lt *latencyTracker) Ranked(servers []string) []string {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+
+	now := lt.clock.Now()
+	if !lt.unmeasured && now.Sub(lt.rankedAt) < lt.interval && sameStrings(lt.ranked, servers) {
+		return lt.ranked
+	}
+
+	ranked := make([]string, len(servers))
+	copy(ranked, servers)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return lt.averages[ranked[i]] < lt.averages[ranked[j]] // unmeasured are 0
+	})
+
+	lt.unmeasured = false
+	for _, server := range ranked {
+		if _, ok := lt.averages[server]; !ok {
+			lt.unmeasured = true
+			break
+		}
+	}
+
+	lt.ranked = ranked
+	lt.rankedAt = now
+	return ranked
+}
+
+// sameStrings returns true when a and b have the same strings, regardless of
+// order.  Both slices are assumed to be free of duplicates.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]struct{}, len(a))
+	for _, s := range a {
+		set[s] = struct{}{}
+	}
+	for _, s := range b {
+		if _, ok := set[s]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,202 @@
+package orange
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// latencyDoer simulates range servers with different response times by
+// advancing a fake clock by the latency configured for each request's host.
+type latencyDoer struct {
+	clock     *fakeClock
+	lock      sync.Mutex
+	latencies map[string]time.Duration
+	counts    map[string]int
+}
+
+func (ld *latencyDoer) Do(request *http.Request) (*http.Response, error) {
+	ld.lock.Lock()
+	latency := ld.latencies[request.URL.Host]
+	ld.counts[request.URL.Host]++
+	ld.lock.Unlock()
+
+	ld.clock.Advance(latency)
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader("result1\n")),
+	}, nil
+}
+
+func (ld *latencyDoer) set(host string, latency time.Duration) {
+	ld.lock.Lock()
+	ld.latencies[host] = latency
+	ld.lock.Unlock()
+}
+
+// reset returns the request counts per host, and resets them.
+func (ld *latencyDoer) reset() map[string]int {
+	ld.lock.Lock()
+	counts := ld.counts
+	ld.counts = make(map[string]int)
+	ld.lock.Unlock()
+	return counts
+}
+
+func TestLatencyTracker(t *testing.T) {
+	fc := newFakeClock()
+	lt := newLatencyTracker(fc, 0.5, time.Minute, 0)
+	servers := []string{"one", "two", "three"}
+
+	t.Run("unmeasured first", func(t *testing.T) {
+		lt.Record("one", 10*time.Millisecond)
+		if got, want := strings.Join(lt.Ranked(servers), ","), "two,three,one"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("moving average", func(t *testing.T) {
+		lt.Record("two", 40*time.Millisecond)
+		lt.Record("two", 20*time.Millisecond)
+		if got, want := mustAverage(t, lt, "two"), 30*time.Millisecond; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("ranked by average", func(t *testing.T) {
+		lt.Record("three", 20*time.Millisecond)
+		if got, want := strings.Join(lt.Ranked(servers), ","), "one,three,two"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("re-ranked after interval", func(t *testing.T) {
+		lt.Record("one", 90*time.Millisecond) // average now 50ms
+		if got, want := strings.Join(lt.Ranked(servers), ","), "one,three,two"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		fc.Advance(time.Minute)
+		if got, want := strings.Join(lt.Ranked(servers), ","), "three,two,one"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
+
+func mustAverage(tb testing.TB, lt *latencyTracker, server string) time.Duration {
+	tb.Helper()
+	average, ok := lt.Average(server)
+	if !ok {
+		tb.Fatalf("GOT: %v; WANT: %v", ok, true)
+	}
+	return average
+}
+
+func TestClientPreferLowLatency(t *testing.T) {
+	servers := []string{"range1.example.com", "range2.example.com"}
+
+	t.Run("alias for LeastLatency", func(t *testing.T) {
+		client, err := NewClient(&Config{PreferLowLatency: true, Servers: servers})
+		ensureError(t, err)
+		if got, want := client.latencies != nil, true; got != want {
+			t.Fatalf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := client.latencies.probeEvery, DefaultLatencyProbeEvery; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("along with LeastLatency", func(t *testing.T) {
+		_, err := NewClient(&Config{PreferLowLatency: true, SelectionStrategy: LeastLatency, Servers: servers})
+		ensureError(t, err)
+	})
+
+	t.Run("along with another strategy", func(t *testing.T) {
+		_, err := NewClient(&Config{PreferLowLatency: true, SelectionStrategy: Random, Servers: servers})
+		ensureError(t, err, "both PreferLowLatency and SelectionStrategy: Random")
+	})
+}
+
+func TestClientLeastLatency(t *testing.T) {
+	fc := newFakeClock()
+	doer := &latencyDoer{
+		clock:     fc,
+		latencies: map[string]time.Duration{"slow": 100 * time.Millisecond, "fast": 10 * time.Millisecond},
+		counts:    make(map[string]int),
+	}
+
+	client, err := NewClient(&Config{
+		Clock:               fc,
+		HTTPClient:          doer,
+		LatencyAlpha:        1,
+		LatencyProbeEvery:   10,
+		LatencyRankInterval: time.Minute,
+		SelectionStrategy:   LeastLatency,
+		Servers:             []string{"slow", "fast"},
+	})
+	ensureError(t, err)
+
+	query := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			_, err := client.Query("foo")
+			ensureError(t, err)
+		}
+	}
+
+	// Each server is measured once, and thereafter the fast one is preferred,
+	// except for every tenth attempt, which probes the slow one.
+	query(100)
+	counts := doer.reset()
+	if got, want := counts["slow"], 11; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := counts["fast"], 89; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+
+	// When the slow server recovers, a probe measures its recovery, and it is
+	// preferred once the servers are re-ranked.
+	doer.set("slow", time.Millisecond)
+	query(10)
+	fc.Advance(time.Minute)
+	doer.reset()
+	query(20)
+	counts = doer.reset()
+	if got, want := counts["slow"], 18; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := counts["fast"], 2; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewClient(&Config{LatencyProbeEvery: -1, Servers: []string{"one"}})
+		ensureError(t, err, "negative LatencyProbeEvery")
+	})
+}
+
+func TestClientLatencyOfFailedAttempt(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}
+
+	configure := func(config *Config) {
+		config.SelectionStrategy = LeastLatency
+		config.RetryCount = 0
+		config.Timeout = 2 * time.Second
+	}
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		_, err := client.Query("foo")
+		ensureError(t, err, http.StatusText(http.StatusServiceUnavailable))
+		if got, want := mustAverage(t, client.latencies, client.Servers()[0]), 2*time.Second; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
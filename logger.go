@@ -0,0 +1,46 @@
+package orange
+
+// Levels of the messages the client sends to a Logger.
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+// Logger receives structured log messages about queries and the attempts made
+// to fulfill them: when each query starts, after each attempt to query a range
+// server, before each retry, and when each query finishes.  Each message is
+// accompanied by fields, which are alternating keys and values, such as
+// "server", "localhost:8081", "status", 200.
+//
+// Messages never include request headers, so credentials provided by
+// BearerToken, BasicAuthUsername, BasicAuthPassword, or Headers are never
+// logged.
+//
+// Logger methods are invoked synchronously from the query path, and may be
+// invoked concurrently from many go-routines.  Implementations must be safe
+// for concurrent use.
+type Logger interface {
+	Log(level, msg string, fields ...interface{})
+}
+
+// LoggerFunc adapts an ordinary function to the Logger interface.
+//
+//     client, err := orange.NewClient(&orange.Config{
+//         Servers: []string{"localhost:8081"},
+//         Logger: orange.LoggerFunc(func(level, msg string, fields ...interface{}) {
+//             log.Println(append([]interface{}{level, msg}, fields...)...)
+//         }),
+//     })
+type LoggerFunc func(level, msg string, fields ...interface{})
+
+// Log invokes f.
+func (f LoggerFunc) Log(level, msg string, fields ...interface{}) { f(level, msg, fields...) }
+
+// NoopLogger is a Logger that ignores all messages.  It is used when no Logger
+// is provided.
+type NoopLogger struct{}
+
+// Log does nothing.
+func (NoopLogger) Log(string, string, ...interface{}) {}
@@ -0,0 +1,88 @@
+package orange
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger is a Logger that remembers each message it receives.
+type recordingLogger struct {
+	lock     sync.Mutex
+	messages []string
+}
+
+func (rl *recordingLogger) Log(level, msg string, fields ...interface{}) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	rl.messages = append(rl.messages, fmt.Sprintln(append([]interface{}{level, msg}, fields...)...))
+}
+
+func (rl *recordingLogger) Messages() []string {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	return append([]string(nil), rl.messages...)
+}
+
+func TestClientLogger(t *testing.T) {
+	const secret = "s3cr3t"
+
+	var invocations int
+	h := func(w http.ResponseWriter, r *http.Request) {
+		invocations++
+		if invocations == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("result1\n"))
+	}
+
+	logger := new(recordingLogger)
+	configure := func(config *Config) {
+		config.BearerToken = secret
+		config.Logger = logger
+		config.RetryCallback = func(error) bool { return true }
+		config.RetryCount = 1
+	}
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		_, err := client.Query("foo")
+		ensureError(t, err)
+	})
+
+	messages := logger.Messages()
+	want := []string{
+		"debug query started",
+		"warn attempt failed",
+		"info retrying query",
+		"debug attempt finished",
+		"debug query finished",
+	}
+	if got, want := len(messages), len(want); got != want {
+		t.Fatalf("GOT: %v; WANT: %v", got, want)
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(messages[i], prefix) {
+			t.Errorf("GOT: %q; WANT: %q", messages[i], prefix)
+		}
+		if strings.Contains(messages[i], secret) {
+			t.Errorf("GOT: %q; WANT: no secret", messages[i])
+		}
+	}
+	if got, want := messages[1], "503"; !strings.Contains(got, want) {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+}
+
+func TestLoggerFunc(t *testing.T) {
+	var got string
+	var logger Logger = LoggerFunc(func(level, msg string, fields ...interface{}) {
+		got = fmt.Sprint(level, msg, fields)
+	})
+	logger.Log(LogInfo, "hello", "key", 42)
+	if want := "infohello[key 42]"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package orange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// MetadataHeaderPrefix is prepended to each metadata key to form the name of
+// the header that carries it.
+const MetadataHeaderPrefix = "X-Range-Meta-"
+
+// Limits on the metadata that may be attached to a context, chosen to keep the
+// request headers well below the limits commonly imposed by HTTP servers.
+const (
+	MaxMetadataEntries     = 16
+	MaxMetadataKeyLength   = 64
+	MaxMetadataValueLength = 1024
+	MaxMetadataSize        = 4096 // sum of key and encoded value lengths
+)
+
+// metadataKey is the context key for query metadata.
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx that carries the key-value pair as query
+// metadata, in addition to any metadata already carried by ctx.  Each query
+// sent with the returned context includes one header for each pair, named by
+// MetadataHeaderPrefix followed by the canonicalized key, such as
+// "X-Range-Meta-Caller", whose value is the percent-encoded value.  This allows
+// callers to pass trace or audit information to range servers.
+//
+// Keys may only contain ASCII letters, digits, and hyphens, and are case
+// insensitive.  It returns an error when the key is invalid, or when adding
+// the pair would exceed MaxMetadataEntries, MaxMetadataKeyLength,
+// MaxMetadataValueLength, or MaxMetadataSize.
+//
+//     ctx, err := orange.WithMetadata(ctx, "caller", "deploy-tool")
+//     if err != nil {
+//         return err
+//     }
+//     values, err := client.QueryCtx(ctx, "%web")
+func WithMetadata(ctx context.Context, key, value string) (context.Context, error) {
+	if key == "" || len(key) > MaxMetadataKeyLength {
+		return nil, fmt.Errorf("cannot add metadata key with length %d outside range [1, %d]", len(key), MaxMetadataKeyLength)
+	}
+	for i := 0; i < len(key); i++ {
+		if b := key[i]; !(b == '-' || ('0' <= b && b <= '9') || ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z')) {
+			return nil, fmt.Errorf("cannot add metadata key with invalid character %q: %q", b, key)
+		}
+	}
+	if len(value) > MaxMetadataValueLength {
+		return nil, fmt.Errorf("cannot add metadata value longer than %d bytes: %d", MaxMetadataValueLength, len(value))
+	}
+
+	key = http.CanonicalHeaderKey(key)
+	previous, _ := ctx.Value(metadataKey{}).(map[string]string)
+
+	metadata := make(map[string]string, len(previous)+1)
+	for k, v := range previous {
+		metadata[k] = v
+	}
+	metadata[key] = url.PathEscape(value)
+
+	if len(metadata) > MaxMetadataEntries {
+		return nil, fmt.Errorf("cannot add more than %d metadata entries", MaxMetadataEntries)
+	}
+	var size int
+	for k, v := range metadata {
+		size += len(k) + len(v)
+	}
+	if size > MaxMetadataSize {
+		return nil, fmt.Errorf("cannot add metadata larger than %d bytes: %d", MaxMetadataSize, size)
+	}
+
+	return context.WithValue(ctx, metadataKey{}, metadata), nil
+}
+
+// Metadata returns the query metadata carried by ctx, with canonicalized keys
+// and decoded values.
+func Metadata(ctx context.Context) map[string]string {
+	metadata, _ := ctx.Value(metadataKey{}).(map[string]string)
+	decoded := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if value, err := url.PathUnescape(v); err == nil {
+			decoded[k] = value
+		}
+	}
+	return decoded
+}
+
+// setMetadataHeaders sets one header on request for each pair of query
+// metadata carried by ctx.
+func setMetadataHeaders(ctx context.Context, request *http.Request) {
+	metadata, _ := ctx.Value(metadataKey{}).(map[string]string)
+	for k, v := range metadata {
+		request.Header.Set(MetadataHeaderPrefix+k, v)
+	}
+}
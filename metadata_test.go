@@ -0,0 +1,85 @@
+package orange
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWithMetadata(t *testing.T) {
+	t.Run("accumulates", func(t *testing.T) {
+		ctx, err := WithMetadata(context.Background(), "caller", "deploy tool")
+		ensureError(t, err)
+		child, err := WithMetadata(ctx, "REASON", "restart/web")
+		ensureError(t, err)
+
+		metadata := Metadata(child)
+		if got, want := metadata["Caller"], "deploy tool"; got != want {
+			t.Errorf("GOT: %q; WANT: %q", got, want)
+		}
+		if got, want := metadata["Reason"], "restart/web"; got != want {
+			t.Errorf("GOT: %q; WANT: %q", got, want)
+		}
+
+		// The parent context is not modified.
+		if got, want := len(Metadata(ctx)), 1; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		for _, key := range []string{"", "has space", "colon:", strings.Repeat("k", MaxMetadataKeyLength+1)} {
+			_, err := WithMetadata(context.Background(), key, "value")
+			ensureError(t, err, "cannot add metadata key")
+		}
+	})
+
+	t.Run("value too long", func(t *testing.T) {
+		_, err := WithMetadata(context.Background(), "key", strings.Repeat("v", MaxMetadataValueLength+1))
+		ensureError(t, err, "cannot add metadata value longer")
+	})
+
+	t.Run("too many entries", func(t *testing.T) {
+		ctx := context.Background()
+		var err error
+		for i := 0; i < MaxMetadataEntries; i++ {
+			ctx, err = WithMetadata(ctx, "key-"+string(rune('a'+i)), "value")
+			ensureError(t, err)
+		}
+		_, err = WithMetadata(ctx, "one-too-many", "value")
+		ensureError(t, err, "more than")
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		ctx := context.Background()
+		var err error
+		for i := 0; i < 3; i++ {
+			ctx, err = WithMetadata(ctx, "key-"+string(rune('a'+i)), strings.Repeat("v", MaxMetadataValueLength))
+			ensureError(t, err)
+		}
+		_, err = WithMetadata(ctx, "key-d", strings.Repeat("v", MaxMetadataValueLength))
+		ensureError(t, err, "larger than")
+	})
+}
+
+func TestClientMetadata(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("X-Range-Meta-Caller"), "deploy%20tool"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := r.Header.Get("X-Range-Meta-Ticket"), "OPS-123"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	}
+
+	withClient(t, h, func(client *Client) {
+		ctx, err := WithMetadata(context.Background(), "caller", "deploy tool")
+		ensureError(t, err)
+		ctx, err = WithMetadata(ctx, "ticket", "OPS-123")
+		ensureError(t, err)
+
+		_, err = client.QueryCtx(ctx, "foo")
+		ensureError(t, err)
+	})
+}
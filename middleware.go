@@ -0,0 +1,14 @@
+package orange
+
+import "net/http"
+
+// runMiddleware invokes the client's RequestMiddleware in order on request,
+// stopping at the first that returns an error.
+func (c *Client) runMiddleware(request *http.Request) error {
+	for _, middleware := range c.requestMiddleware {
+		if err := middleware(request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package orange
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClientRequestMiddleware(t *testing.T) {
+	t.Run("order", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if got, want := strings.Join(r.Header["X-Order"], ","), "first,second"; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			// Middleware see the headers set by the client.
+			if got, want := r.Header.Get("X-Agent"), "custom-user-agent"; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		}
+		configure := func(config *Config) {
+			config.RequestMiddleware = []func(*http.Request) error{
+				func(r *http.Request) error {
+					r.Header.Add("X-Order", "first")
+					r.Header.Set("X-Agent", r.Header.Get("User-Agent"))
+					return nil
+				},
+				func(r *http.Request) error {
+					r.Header.Add("X-Order", "second")
+					return nil
+				},
+			}
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			for _, expression := range []string{"foo", strings.Repeat("{", defaultQueryURILengthThreshold)} {
+				_, err := client.Query(expression)
+				ensureError(t, err)
+			}
+		})
+	})
+
+	t.Run("body", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			buf, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := r.Header.Get("X-Signature"), string(buf); got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		}
+		configure := func(config *Config) {
+			config.RequestMiddleware = []func(*http.Request) error{
+				func(r *http.Request) error {
+					if r.GetBody == nil {
+						return nil
+					}
+					body, err := r.GetBody()
+					if err != nil {
+						return err
+					}
+					buf, err := ioutil.ReadAll(body)
+					if err != nil {
+						return err
+					}
+					r.Header.Set("X-Signature", string(buf))
+					return nil
+				},
+			}
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query(strings.Repeat("{", defaultQueryURILengthThreshold))
+			ensureError(t, err)
+		})
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var requests, invocations int32
+		h := func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+		}
+		configure := func(config *Config) {
+			config.RequestMiddleware = []func(*http.Request) error{
+				func(r *http.Request) error {
+					return errors.New("cannot sign request")
+				},
+				func(r *http.Request) error {
+					atomic.AddInt32(&invocations, 1)
+					return nil
+				},
+			}
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query("foo")
+			ensureError(t, err, "cannot sign request")
+		})
+		if got, want := atomic.LoadInt32(&requests), int32(0); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := atomic.LoadInt32(&invocations), int32(0); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package orange
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrQueryMulti is returned by QueryMulti and QueryMultiCtx when one or more of
+// the query expressions failed.
+type ErrQueryMulti struct {
+	Errors map[string]error // Errors maps each failed query expression to its error.
+}
+
+func (err ErrQueryMulti) Error() string {
+	expressions := make([]string, 0, len(err.Errors))
+	for expression := range err.Errors {
+		expressions = append(expressions, expression)
+	}
+	sort.Strings(expressions)
+
+	messages := make([]string, len(expressions))
+	for i, expression := range expressions {
+		messages[i] = fmt.Sprintf("%q: %s", expression, err.Errors[expression])
+	}
+	return fmt.Sprintf("%d queries failed: %s", len(expressions), strings.Join(messages, "; "))
+}
+
+// QueryMulti sends each of the query expressions concurrently, and returns a
+// map from each expression to its values.  It is a convenience wrapper for
+// QueryMultiCtx using a background context.
+func (c *Client) QueryMulti(expressions []string) (map[string][]string, error) {
+	return c.QueryMultiCtx(context.Background(), expressions)
+}
+
+// QueryMultiCtx sends each of the query expressions concurrently with the
+// provided context, and returns a map from each expression to its values.
+// Each query is sent as though by QueryCtx, so the client's MaxConcurrency,
+// cache, and retry settings apply to each.  When any of the queries fail, it
+// returns the values of the successful queries along with ErrQueryMulti, which
+// reports the error of each failed expression.
+//
+//     results, err := client.QueryMultiCtx(ctx, []string{"%web", "%db"})
+//     if err != nil {
+//         var e orange.ErrQueryMulti
+//         if !errors.As(err, &e) {
+//             return err
+//         }
+//         for expression, err := range e.Errors {
+//             log.Printf("cannot resolve %q: %s", expression, err)
+//         }
+//     }
+//     for expression, values := range results {
+//         fmt.Println(expression, values)
+//     }
+func (c *Client) QueryMultiCtx(ctx context.Context, expressions []string) (map[string][]string, error) {
+	results := make(map[string][]string, len(expressions))
+	errs := make(map[string]error)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	sent := make(map[string]struct{}, len(expressions))
+	for _, expression := range expressions {
+		if _, ok := sent[expression]; ok {
+			continue // send duplicate expressions only once
+		}
+		sent[expression] = struct{}{}
+
+		wg.Add(1)
+		go func(expression string) {
+			defer wg.Done()
+			values, err := c.QueryCtx(ctx, expression)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				errs[expression] = err
+				return
+			}
+			results[expression] = values
+		}(expression)
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return results, ErrQueryMulti{Errors: errs}
+	}
+	return results, nil
+}
@@ -0,0 +1,67 @@
+package orange
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClientQueryMulti(t *testing.T) {
+	var invocations int32
+	h := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&invocations, 1)
+		switch r.URL.RawQuery {
+		case "bad":
+			w.Header().Set("RangeException", "some error")
+		case "busy":
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(r.URL.RawQuery + "1\n" + r.URL.RawQuery + "2\n"))
+		}
+	}
+
+	t.Run("all succeed", func(t *testing.T) {
+		atomic.StoreInt32(&invocations, 0)
+		withClient(t, h, func(client *Client) {
+			results, err := client.QueryMulti([]string{"foo", "bar", "foo"})
+			ensureError(t, err)
+			if got, want := len(results), 2; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			ensureStringSlicesMatch(t, results["foo"], []string{"foo1", "foo2"})
+			ensureStringSlicesMatch(t, results["bar"], []string{"bar1", "bar2"})
+		})
+		if got, want := atomic.LoadInt32(&invocations), int32(2); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		configure := func(config *Config) { config.MaxConcurrency = 1 }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			results, err := client.QueryMultiCtx(context.Background(), []string{"foo", "bad", "busy"})
+			ensureError(t, err, `"bad": RangeException: some error`, `"busy": 429`)
+
+			var e ErrQueryMulti
+			if !errors.As(err, &e) {
+				t.Fatalf("GOT: %T; WANT: %T", err, e)
+			}
+			if got, want := len(e.Errors), 2; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if _, ok := e.Errors["bad"].(ErrRangeException); !ok {
+				t.Errorf("GOT: %T; WANT: %T", e.Errors["bad"], ErrRangeException{})
+			}
+			if got, want := errors.Is(e.Errors["busy"], ErrTooManyRequests), true; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+
+			if got, want := len(results), 1; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			ensureStringSlicesMatch(t, results["foo"], []string{"foo1", "foo2"})
+		})
+	})
+}
@@ -0,0 +1,86 @@
+package orange
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Observer receives notifications about queries and the attempts made to
+// fulfill them, allowing programs to collect metrics such as query counts,
+// retry counts, failures by error type, and latencies.
+//
+// Observer methods are invoked synchronously from the query path, and may be
+// invoked concurrently from many go-routines.  Implementations must be safe
+// for concurrent use, and must be cheap and never block: record the values and
+// return, deferring any heavy work to another go-routine.
+type Observer interface {
+	// QueryStarted is invoked when a query begins, before any attempt is
+	// sent to a range server.
+	QueryStarted(expression string)
+
+	// AttemptFinished is invoked after each attempt to query a range server,
+	// with the server queried, the HTTP status code of its response, or 0
+	// when no response was received, the duration of the attempt, and the
+	// error that resulted from the attempt, if any.  A hedged request whose
+	// successful response arrived after that of another request has status
+	// 200 along with an error, because its response was discarded.
+	AttemptFinished(server string, status int, duration time.Duration, err error)
+
+	// QueryFinished is invoked when a query ends, after its final attempt,
+	// with the error returned to the caller, if any.
+	QueryFinished(expression string, err error)
+}
+
+// ContextObserver is an Observer that is also given the context of each
+// query, such as to record a tracing span for it.  When the Observer provided
+// to a Client implements ContextObserver, QueryStartedCtx and QueryFinishedCtx
+// are invoked in place of QueryStarted and QueryFinished, for every query the
+// Client sends, whichever method was used to send it.
+type ContextObserver interface {
+	Observer
+
+	// QueryStartedCtx is invoked when a query begins, before any attempt is
+	// sent to a range server, with the query's context, which carries its
+	// correlation ID.  The returned context is used to send the query's
+	// attempts, and must be derived from ctx.
+	QueryStartedCtx(ctx context.Context, expression string) context.Context
+
+	// QueryFinishedCtx is invoked when a query ends, after its final attempt,
+	// with the context returned by QueryStartedCtx and the error returned to
+	// the caller, if any.
+	QueryFinishedCtx(ctx context.Context, expression string, err error)
+}
+
+// NoopObserver is an Observer that ignores all notifications.  It is used when
+// no Observer is provided, and may be embedded by types that only need to
+// implement some of the Observer methods.
+type NoopObserver struct{}
+
+// QueryStarted does nothing.
+func (NoopObserver) QueryStarted(string) {}
+
+// AttemptFinished does nothing.
+func (NoopObserver) AttemptFinished(string, int, time.Duration, error) {}
+
+// QueryFinished does nothing.
+func (NoopObserver) QueryFinished(string, error) {}
+
+// statusCode returns the HTTP status code of the final response received for a
+// query attempt that resulted in err, or 0 when no response was received.
+func statusCode(err error) int {
+	if err == errHedgeLost {
+		return http.StatusOK // the server answered, only more slowly than another
+	}
+	switch e := err.(type) {
+	case nil, ErrRangeException:
+		return http.StatusOK
+	case ErrStatusNotOK:
+		return e.StatusCode
+	case ErrURITooLong:
+		if e.Threshold == 0 {
+			return http.StatusRequestURITooLong // server rejected the URI
+		}
+	}
+	return 0
+}
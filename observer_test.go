@@ -0,0 +1,143 @@
+package orange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordingObserver records each notification as a string.
+type recordingObserver struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (ro *recordingObserver) record(format string, a ...interface{}) {
+	ro.lock.Lock()
+	ro.events = append(ro.events, fmt.Sprintf(format, a...))
+	ro.lock.Unlock()
+}
+
+func (ro *recordingObserver) QueryStarted(expression string) {
+	ro.record("started %s", expression)
+}
+
+func (ro *recordingObserver) AttemptFinished(server string, status int, duration time.Duration, err error) {
+	ro.record("attempt %d %v", status, err)
+}
+
+func (ro *recordingObserver) QueryFinished(expression string, err error) {
+	ro.record("finished %s %v", expression, err)
+}
+
+func TestObserver(t *testing.T) {
+	var invocations int32
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&invocations, 1) == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("result1\n"))
+	}
+
+	observer := new(recordingObserver)
+	configure := func(config *Config) {
+		config.Observer = observer
+		config.RetryCallback = func(error) bool { return true }
+	}
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		_, err := client.Query("foo")
+		ensureError(t, err)
+	})
+
+	want := []string{
+		"started foo",
+		"attempt 503 503 Service Unavailable: try again",
+		"attempt 200 <nil>",
+		"finished foo <nil>",
+	}
+	if got, want := fmt.Sprint(observer.events), fmt.Sprint(want); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
+
+// contextObserver records each notification as a string, and marks the
+// context of each query it is given.
+type contextObserver struct {
+	recordingObserver
+}
+
+type contextObserverKey struct{}
+
+func (co *contextObserver) QueryStartedCtx(ctx context.Context, expression string) context.Context {
+	co.record("started %s", expression)
+	return context.WithValue(ctx, contextObserverKey{}, expression)
+}
+
+func (co *contextObserver) QueryFinishedCtx(ctx context.Context, expression string, err error) {
+	co.record("finished %s %v %v", expression, ctx.Value(contextObserverKey{}), err)
+}
+
+func TestContextObserver(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("result1\n"))
+	}
+
+	observer := new(contextObserver)
+	configure := func(config *Config) {
+		config.HTTPClient = nil
+		config.Observer = observer
+		config.WrapTransport = func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+				observer.record("request %v", request.Context().Value(contextObserverKey{}))
+				return next.RoundTrip(request)
+			})
+		}
+	}
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		_, err := client.QueryDetailed("foo")
+		ensureError(t, err)
+		err = client.QueryStream(context.Background(), "bar", func(string) error { return nil })
+		ensureError(t, err)
+	})
+
+	want := []string{
+		"started foo",
+		"request foo",
+		"attempt 200 <nil>",
+		"finished foo foo <nil>",
+		"started bar",
+		"request bar",
+		"attempt 200 <nil>",
+		"finished bar bar <nil>",
+	}
+	if got, want := fmt.Sprint(observer.events), fmt.Sprint(want); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{nil, http.StatusOK},
+		{ErrRangeException{Message: "some error"}, http.StatusOK},
+		{errHedgeLost, http.StatusOK},
+		{ErrStatusNotOK{StatusCode: http.StatusBadGateway}, http.StatusBadGateway},
+		{ErrURITooLong{Length: 5000}, http.StatusRequestURITooLong},
+		{ErrURITooLong{Length: 5000, Threshold: 4096}, 0},
+		{fmt.Errorf("connection refused"), 0},
+	}
+	for _, c := range cases {
+		if got, want := statusCode(c.err), c.want; got != want {
+			t.Errorf("%v: GOT: %v; WANT: %v", c.err, got, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package orange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// QueryOption changes how a single query is sent by QueryWithOptions, without
+// changing the client's configuration.
+type QueryOption func(*queryOptions)
+
+// queryOptions holds the settings of a single query.
+type queryOptions struct {
+	maxResults int    // maxResults is 0 unless WithMaxResults is provided
+	method     string // method is empty unless WithMethod is provided
+	retryCount int    // retryCount is -1 unless WithRetries or WithNoRetry is provided
+}
+
+func newQueryOptions(opts []QueryOption) *queryOptions {
+	o := &queryOptions{retryCount: -1}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithMaxResults causes the query to return ErrTooManyResults rather than its
+// values when it resolves to more than n values, so an accidentally broad
+// expression cannot cause a program to act on far more hosts than intended.
+// The values are counted after the client's SortResults and DedupeResults
+// settings are applied.  A value of n less than 1 means no limit.
+//
+//     hosts, err := client.QueryWithOptions(ctx, "%web", orange.WithMaxResults(50))
+//     if err != nil {
+//         return err // including when %web resolves to more than 50 hosts
+//     }
+func WithMaxResults(n int) QueryOption {
+	return func(o *queryOptions) { o.maxResults = n }
+}
+
+// WithMethod causes the query to be sent using exactly method, which must be
+// http.MethodGet, http.MethodPut, or http.MethodPost, rather than the method
+// the client chooses based on the length of the query.  When the range server
+// rejects it, such as with 405 Method Not Allowed or 414 Request URI Too Long,
+// the query returns ErrStatusNotOK instead of being sent again using another
+// method, because the caller chose the method.  It is useful to reproduce the
+// behavior of a range server, or when the caller knows what methods the range
+// server supports.  A cached response is returned without sending the query.
+//
+//     hosts, err := client.QueryWithOptions(ctx, "%web", orange.WithMethod(http.MethodPut))
+func WithMethod(method string) QueryOption {
+	return func(o *queryOptions) { o.method = method }
+}
+
+// WithRetries causes the query to be retried up to n times, rather than the
+// client's RetryCount, so a critical call site may try harder than others.
+// Each retry is still subject to the client's retry callback and pauses.  A
+// value of n less than 0 means no retries.
+//
+//     hosts, err := client.QueryWithOptions(ctx, "%web", orange.WithRetries(5))
+func WithRetries(n int) QueryOption {
+	if n < 0 {
+		n = 0
+	}
+	return func(o *queryOptions) { o.retryCount = n }
+}
+
+// WithNoRetry causes the query to be sent only once, regardless of the
+// client's RetryCount, so a latency sensitive call site fails fast.  A hedged
+// request, or the other servers tried when the client is configured with
+// TryAllServers, are part of that single attempt.
+func WithNoRetry() QueryOption {
+	return WithRetries(0)
+}
+
+type methodKey struct{}
+
+type retryCountKey struct{}
+
+// retryCountFor returns the number of times a query sent with ctx may be
+// retried: the count provided by WithRetries or WithNoRetry, or else the
+// client's RetryCount.
+func (c *Client) retryCountFor(ctx context.Context) int {
+	if n, ok := ctx.Value(retryCountKey{}).(int); ok {
+		return n
+	}
+	return c.retryCount
+}
+
+// flightKey returns the key under which a query for expression sent with ctx
+// is coalesced with other queries, which includes the method and retry count
+// provided by its options, so only queries sent the same way share a flight.
+func flightKey(ctx context.Context, expression string) string {
+	key := expression
+	if method, ok := ctx.Value(methodKey{}).(string); ok {
+		key += "\x00method=" + method
+	}
+	if n, ok := ctx.Value(retryCountKey{}).(int); ok {
+		key += "\x00retries=" + strconv.Itoa(n)
+	}
+	return key
+}
+
+// QueryWithOptions sends the query expression to a range server just as
+// QueryCtx does, but allows the caller to change how this single query is
+// sent by providing one or more options.  When the client coalesces
+// queries, a query only joins another already in flight that was sent with
+// the same method and retries.
+func (c *Client) QueryWithOptions(ctx context.Context, expression string, opts ...QueryOption) ([]string, error) {
+	o := newQueryOptions(opts)
+	switch o.method {
+	case "":
+	case http.MethodGet, http.MethodPut, http.MethodPost:
+		ctx = context.WithValue(ctx, methodKey{}, o.method)
+	default:
+		return nil, fmt.Errorf("cannot query using unsupported method: %q", o.method)
+	}
+	if o.retryCount >= 0 {
+		ctx = context.WithValue(ctx, retryCountKey{}, o.retryCount)
+	}
+	lines, err := c.QueryCtx(ctx, expression)
+	if err != nil {
+		return nil, err
+	}
+	if o.maxResults > 0 && len(lines) > o.maxResults {
+		return nil, ErrTooManyResults{Count: len(lines), Limit: o.maxResults}
+	}
+	return lines, nil
+}
@@ -0,0 +1,158 @@
+package orange
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClientWithMaxResults(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("host1\nhost2\nhost3\nhost3\n"))
+	}
+
+	t.Run("above limit", func(t *testing.T) {
+		withClient(t, h, func(client *Client) {
+			values, err := client.QueryWithOptions(context.Background(), "%web", WithMaxResults(3))
+			e, ok := err.(ErrTooManyResults)
+			if !ok {
+				t.Fatalf("GOT: %T; WANT: %T", err, e)
+			}
+			if got, want := e.Count, 4; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := e.Limit, 3; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			ensureError(t, err, "4 values exceeds limit of 3")
+			if values != nil {
+				t.Errorf("GOT: %v; WANT: %v", values, nil)
+			}
+		})
+	})
+
+	t.Run("at limit after dedupe", func(t *testing.T) {
+		configure := func(config *Config) { config.DedupeResults = true }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.QueryWithOptions(context.Background(), "%web", WithMaxResults(3))
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"host1", "host2", "host3"})
+		})
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		withClient(t, h, func(client *Client) {
+			values, err := client.QueryWithOptions(context.Background(), "%web", WithMaxResults(0))
+			ensureError(t, err)
+			if got, want := len(values), 4; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+}
+
+func TestClientWithRetries(t *testing.T) {
+	var requests int32
+	h := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}
+	configure := func(config *Config) {
+		config.RetryCallback = func(error) bool { return true }
+		config.RetryCount = 2
+	}
+
+	cases := []struct {
+		name string
+		opts []QueryOption
+		want int32
+	}{
+		{"client default", nil, 3},
+		{"more", []QueryOption{WithRetries(4)}, 5},
+		{"none", []QueryOption{WithNoRetry()}, 1},
+		{"negative", []QueryOption{WithRetries(-1)}, 1},
+	}
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		if got, want := client.RetryCount(), 2; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				atomic.StoreInt32(&requests, 0)
+				_, err := client.QueryWithOptions(context.Background(), "foo", c.opts...)
+				ensureError(t, err, http.StatusText(http.StatusServiceUnavailable))
+				if got, want := atomic.LoadInt32(&requests), c.want; got != want {
+					t.Errorf("GOT: %v; WANT: %v", got, want)
+				}
+			})
+		}
+	})
+}
+
+func TestClientWithMethod(t *testing.T) {
+	var lock sync.Mutex
+	var methods []string
+	reset := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		m := methods
+		methods = nil
+		return m
+	}
+	h := func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		methods = append(methods, r.Method)
+		lock.Unlock()
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Write([]byte("host1\n"))
+	}
+	long := strings.Repeat("{", defaultQueryURILengthThreshold)
+
+	withClient(t, h, func(client *Client) {
+		t.Run("forced", func(t *testing.T) {
+			values, err := client.QueryWithOptions(context.Background(), "%web", WithMethod(http.MethodGet))
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"host1"})
+			ensureStringSlicesMatch(t, reset(), []string{http.MethodGet})
+
+			// A long query is still sent using GET.
+			_, err = client.QueryWithOptions(context.Background(), long, WithMethod(http.MethodGet))
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, reset(), []string{http.MethodGet})
+		})
+
+		t.Run("rejected without fallback", func(t *testing.T) {
+			_, err := client.QueryWithOptions(context.Background(), "%web", WithMethod(http.MethodPost))
+			e, ok := err.(ErrStatusNotOK)
+			if !ok {
+				t.Fatalf("GOT: %T; WANT: %T", err, e)
+			}
+			if got, want := e.StatusCode, http.StatusMethodNotAllowed; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			ensureStringSlicesMatch(t, reset(), []string{http.MethodPost})
+		})
+
+		t.Run("unforced falls back", func(t *testing.T) {
+			_, err := client.QueryWithOptions(context.Background(), long)
+			ensureError(t, err)
+			got := reset()
+			if len(got) != 2 || got[0] != http.MethodPut || got[1] != http.MethodGet {
+				t.Errorf("GOT: %v; WANT: %v", got, []string{http.MethodPut, http.MethodGet})
+			}
+		})
+
+		t.Run("unsupported", func(t *testing.T) {
+			_, err := client.QueryWithOptions(context.Background(), "%web", WithMethod(http.MethodDelete))
+			ensureError(t, err, "unsupported method")
+			ensureStringSlicesMatch(t, reset(), nil)
+		})
+	})
+}
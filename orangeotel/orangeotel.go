@@ -0,0 +1,227 @@
+// Package orangeotel provides OpenTelemetry tracing for range queries made
+// with the orange library.  It is a separate module so that programs which do
+// not use OpenTelemetry are not forced to depend on it.
+//
+// Each query made with a Client from this package, whichever method is used to
+// send it, is recorded as a span that is a child of any span in the query's
+// context, and each HTTP request sent to a range server is recorded as a child
+// span of the query span, tagged with the server address, the HTTP method, the
+// HTTP status code, and the correlation ID shared by every request sent for
+// the same query.  The trace context is injected into each outgoing request's
+// headers, by default using the W3C traceparent header.
+package orangeotel
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/karrick/orange"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies this package as the source of its spans.
+const instrumentationName = "github.com/karrick/orange/orangeotel"
+
+// Option configures tracing.
+type Option func(*options)
+
+type options struct {
+	provider   trace.TracerProvider
+	propagator propagation.TextMapPropagator
+}
+
+// WithTracerProvider specifies the TracerProvider used to create spans.  When
+// not provided, the global TracerProvider is used.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *options) { o.provider = provider }
+}
+
+// WithPropagator specifies the propagator used to inject the trace context
+// into outgoing requests.  When not provided, the W3C Trace Context
+// propagator is used.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(o *options) { o.propagator = propagator }
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		provider:   otel.GetTracerProvider(),
+		propagator: propagation.TraceContext{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// requestTracer records a span for each HTTP request it sends.
+type requestTracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func newRequestTracer(opts []Option) requestTracer {
+	o := newOptions(opts)
+	return requestTracer{
+		tracer:     o.provider.Tracer(instrumentationName),
+		propagator: o.propagator,
+	}
+}
+
+// send sends a copy of request using send, recording a span for it and
+// injecting the trace context into the copy's headers.  The provided request
+// is not modified.
+func (rt requestTracer) send(request *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	ctx, span := rt.tracer.Start(request.Context(), "HTTP "+request.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("server.address", request.URL.Host),
+			attribute.String("http.request.method", request.Method),
+			attribute.String("range.correlation_id", orange.CorrelationID(request.Context())),
+		))
+	defer span.End()
+
+	request = request.Clone(ctx)
+	rt.propagator.Inject(ctx, propagation.HeaderCarrier(request.Header))
+
+	response, err := send(request)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode))
+	if response.StatusCode >= 400 {
+		span.SetStatus(codes.Error, response.Status)
+	}
+	return response, nil
+}
+
+// doer records a span for each HTTP request it sends.
+type doer struct {
+	requestTracer
+	next orange.Doer
+}
+
+// NewDoer returns an orange.Doer that sends each request using next, recording
+// a span for it and injecting the trace context into its headers.
+func NewDoer(next orange.Doer, opts ...Option) orange.Doer {
+	return &doer{requestTracer: newRequestTracer(opts), next: next}
+}
+
+func (d *doer) Do(request *http.Request) (*http.Response, error) {
+	return d.send(request, d.next.Do)
+}
+
+// transport records a span for each HTTP request it sends.
+type transport struct {
+	requestTracer
+	next http.RoundTripper
+}
+
+// NewTransport returns an http.RoundTripper that sends each request using
+// next, recording a span for it and injecting the trace context into its
+// headers.
+func NewTransport(next http.RoundTripper, opts ...Option) http.RoundTripper {
+	return &transport{requestTracer: newRequestTracer(opts), next: next}
+}
+
+func (t *transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	return t.send(request, t.next.RoundTrip)
+}
+
+// Client is an orange.Client that records a span for each query.
+type Client struct {
+	*orange.Client
+}
+
+// NewClient returns a Client that records a span for each query, whichever
+// method is used to send it, and for each HTTP request sent to fulfill it.
+// Any Observer in config continues to be notified of each query.  When config
+// does not provide an HTTPClient, requests are traced by wrapping the
+// transport of the HTTP client the orange library creates, after any
+// WrapTransport in config, so all of the config's transport settings still
+// apply.  Otherwise the provided HTTPClient is wrapped using NewDoer.
+func NewClient(config *orange.Config, opts ...Option) (*Client, error) {
+	traced := *config
+	traced.Observer = NewObserver(traced.Observer, opts...)
+	if traced.HTTPClient != nil {
+		traced.HTTPClient = NewDoer(traced.HTTPClient, opts...)
+	} else {
+		wrap := traced.WrapTransport
+		traced.WrapTransport = func(next http.RoundTripper) http.RoundTripper {
+			if wrap != nil {
+				if next = wrap(next); next == nil {
+					return nil
+				}
+			}
+			return NewTransport(next, opts...)
+		}
+	}
+
+	client, err := orange.NewClient(&traced)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Client: client}, nil
+}
+
+// observer records a span for each query, and passes each notification on to
+// the next Observer.
+type observer struct {
+	orange.Observer
+	tracer trace.Tracer
+}
+
+// spanKey is the context key of the span recorded for a query, kept apart from
+// the current span in the context, which the next Observer may replace.
+type spanKey struct{}
+
+// NewObserver returns an orange.ContextObserver that records a span for each
+// query, as a child of any span in the query's context, and passes each
+// notification on to next.  When next is nil, notifications are not passed
+// on.
+func NewObserver(next orange.Observer, opts ...Option) orange.ContextObserver {
+	if next == nil {
+		next = orange.NoopObserver{}
+	}
+	o := newOptions(opts)
+	return &observer{Observer: next, tracer: o.provider.Tracer(instrumentationName)}
+}
+
+func (o *observer) QueryStartedCtx(ctx context.Context, expression string) context.Context {
+	ctx, span := o.tracer.Start(ctx, "range query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("range.expression", expression),
+			attribute.String("range.correlation_id", orange.CorrelationID(ctx)),
+		))
+	ctx = context.WithValue(ctx, spanKey{}, span)
+	if next, ok := o.Observer.(orange.ContextObserver); ok {
+		return next.QueryStartedCtx(ctx, expression)
+	}
+	o.Observer.QueryStarted(expression)
+	return ctx
+}
+
+func (o *observer) QueryFinishedCtx(ctx context.Context, expression string, err error) {
+	if next, ok := o.Observer.(orange.ContextObserver); ok {
+		next.QueryFinishedCtx(ctx, expression, err)
+	} else {
+		o.Observer.QueryFinished(expression, err)
+	}
+	span, ok := ctx.Value(spanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
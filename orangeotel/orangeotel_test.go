@@ -0,0 +1,233 @@
+package orangeotel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karrick/orange"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestClient(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte("result1\nresult2\n"))
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	address := strings.TrimPrefix(server.URL, "http://")
+	client, err := NewClient(&orange.Config{
+		HTTPClient: server.Client(),
+		Servers:    []string{address},
+	}, WithTracerProvider(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	values, err := client.QueryCtx(ctx, "foo")
+	parent.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(values, ","), "result1,result2"; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+
+	spans := recorder.Ended()
+	if got, want := len(spans), 3; got != want {
+		t.Fatalf("GOT: %v; WANT: %v", got, want)
+	}
+	attempt, query := spans[0], spans[1]
+
+	if got, want := query.Name(), "range query"; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := query.Parent().SpanID(), parent.SpanContext().SpanID(); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+
+	if got, want := attempt.Parent().SpanID(), query.SpanContext().SpanID(); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	attrs := attributes(attempt)
+	if got, want := attrs["server.address"].AsString(), address; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := attrs["http.request.method"].AsString(), http.MethodGet; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := attrs["http.response.status_code"].AsInt64(), int64(http.StatusOK); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got := attrs["range.correlation_id"].AsString(); got == "" {
+		t.Errorf("GOT: %q; WANT: correlation ID", got)
+	}
+
+	// The traceparent header identifies the attempt span.
+	sc := attempt.SpanContext()
+	if got, want := traceparent, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01"; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := sc.TraceID(), parent.SpanContext().TraceID(); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
+
+func TestClientError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("RangeException", "some error")
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	client, err := NewClient(&orange.Config{
+		HTTPClient: server.Client(),
+		Servers:    []string{strings.TrimPrefix(server.URL, "http://")},
+	}, WithTracerProvider(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.Query("foo"); err == nil {
+		t.Fatal("GOT: nil; WANT: error")
+	}
+
+	spans := recorder.Ended()
+	if got, want := len(spans), 2; got != want {
+		t.Fatalf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := spans[1].Status().Description, "RangeException: some error"; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func TestClientWithoutHTTPClient(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte("result1\nresult2\n"))
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	var wrapped int
+	client, err := NewClient(&orange.Config{
+		MaxRedirects: 1,
+		Servers:      []string{strings.TrimPrefix(server.URL, "http://")},
+		WrapTransport: func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+				wrapped++
+				return next.RoundTrip(request)
+			})
+		},
+	}, WithTracerProvider(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	values, err := client.Query("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(values, ","), "result1,result2"; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := wrapped, 1; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+
+	spans := recorder.Ended()
+	if got, want := len(spans), 2; got != want {
+		t.Fatalf("GOT: %v; WANT: %v", got, want)
+	}
+	sc := spans[0].SpanContext()
+	if got, want := traceparent, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01"; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
+
+func TestClientEveryQueryMethod(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("result1\nresult2\n"))
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	var finished []string
+	client, err := NewClient(&orange.Config{
+		Observer: observerFunc(func(expression string, err error) {
+			finished = append(finished, expression)
+		}),
+		Servers: []string{strings.TrimPrefix(server.URL, "http://")},
+	}, WithTracerProvider(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	if _, err = client.QueryWithOptions(ctx, "options"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.QueryDetailedCtx(ctx, "detailed"); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.QueryStream(ctx, "stream", func(string) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	var queries []string
+	for _, span := range recorder.Ended() {
+		if span.Name() == "range query" {
+			queries = append(queries, attributes(span)["range.expression"].AsString())
+		}
+	}
+	if got, want := strings.Join(queries, ","), "options,detailed,stream"; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := strings.Join(finished, ","), "options,detailed,stream"; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
+
+// observerFunc is an orange.Observer that invokes itself when a query
+// finishes.
+type observerFunc func(expression string, err error)
+
+func (f observerFunc) QueryStarted(string) {}
+
+func (f observerFunc) AttemptFinished(string, int, time.Duration, error) {}
+
+func (f observerFunc) QueryFinished(expression string, err error) { f(expression, err) }
@@ -0,0 +1,179 @@
+// Package orangeprom provides Prometheus metrics for range queries made with
+// the orange library.  It is a separate module so that programs which do not
+// use Prometheus are not forced to depend on it.
+//
+// A Collector is both an orange.Observer, which the client notifies as each
+// query and each attempt to query a range server finishes, and a
+// prometheus.Collector, which exports what it observed.  Register it with a
+// prometheus.Registerer, and provide it as the Observer of the client:
+//
+//     collector := orangeprom.NewCollector()
+//     prometheus.MustRegister(collector)
+//
+//     client, err := orange.NewClient(&orange.Config{
+//         Observer: collector,
+//         Servers:  []string{"range1.example.com", "range2.example.com"},
+//     })
+//
+// The Collector exports the following metrics, where the outcome label is one
+// of ok, range_exception, status_not_ok, timeout, or error:
+//
+//     orange_queries_total{outcome}
+//     orange_queries_in_flight
+//     orange_query_attempts_total{server, outcome}
+//     orange_query_attempt_duration_seconds{server}
+//
+// The number of retries is the number of attempts beyond one per query, so
+// the rate of retries is:
+//
+//     sum(rate(orange_query_attempts_total[5m])) - sum(rate(orange_queries_total[5m]))
+//
+// Hedged requests and the additional servers tried when the client is
+// configured with TryAllServers are counted as attempts.
+package orangeprom
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/karrick/orange"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outcomes by which queries and attempts are labeled.
+const (
+	OutcomeOK             = "ok"
+	OutcomeRangeException = "range_exception"
+	OutcomeStatusNotOK    = "status_not_ok"
+	OutcomeTimeout        = "timeout"
+	OutcomeError          = "error"
+)
+
+// Option configures a Collector.
+type Option func(*options)
+
+type options struct {
+	namespace string
+	buckets   []float64
+}
+
+// WithNamespace specifies the namespace that prefixes the name of each
+// metric.  When not provided, the namespace is "orange".
+func WithNamespace(namespace string) Option {
+	return func(o *options) { o.namespace = namespace }
+}
+
+// WithBuckets specifies the upper bounds, in seconds, of the buckets of the
+// attempt duration histogram.  When not provided, prometheus.DefBuckets is
+// used.
+func WithBuckets(buckets []float64) Option {
+	return func(o *options) { o.buckets = buckets }
+}
+
+// Collector records metrics about queries and the attempts made to fulfill
+// them, and exports them to Prometheus.  It is safe for concurrent use.
+type Collector struct {
+	queries  *prometheus.CounterVec
+	inFlight prometheus.Gauge
+	attempts *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewCollector returns a Collector with no recorded queries.
+func NewCollector(opts ...Option) *Collector {
+	o := &options{
+		namespace: "orange",
+		buckets:   prometheus.DefBuckets,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return &Collector{
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "queries_total",
+			Help:      "Number of range queries finished, by outcome.",
+		}, []string{"outcome"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: o.namespace,
+			Name:      "queries_in_flight",
+			Help:      "Number of range queries started but not yet finished.",
+		}),
+		attempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "query_attempts_total",
+			Help:      "Number of attempts to query a range server, by server and outcome.",
+		}, []string{"server", "outcome"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.namespace,
+			Name:      "query_attempt_duration_seconds",
+			Help:      "Duration of attempts to query a range server, by server.",
+			Buckets:   o.buckets,
+		}, []string{"server"}),
+	}
+}
+
+// QueryStarted records that a query is in flight.
+func (c *Collector) QueryStarted(string) {
+	c.inFlight.Inc()
+}
+
+// AttemptFinished records the outcome and duration of an attempt to query
+// server.
+func (c *Collector) AttemptFinished(server string, status int, duration time.Duration, err error) {
+	c.attempts.WithLabelValues(server, outcome(status, err)).Inc()
+	c.duration.WithLabelValues(server).Observe(duration.Seconds())
+}
+
+// QueryFinished records the outcome of a query.
+func (c *Collector) QueryFinished(_ string, err error) {
+	c.inFlight.Dec()
+	c.queries.WithLabelValues(outcome(0, err)).Inc()
+}
+
+// Describe sends the descriptors of the metrics of the Collector to ch.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.queries.Describe(ch)
+	c.inFlight.Describe(ch)
+	c.attempts.Describe(ch)
+	c.duration.Describe(ch)
+}
+
+// Collect sends the metrics of the Collector to ch.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.queries.Collect(ch)
+	c.inFlight.Collect(ch)
+	c.attempts.Collect(ch)
+	c.duration.Collect(ch)
+}
+
+type timeout interface {
+	Timeout() bool
+}
+
+// outcome returns the label for the result of a query or attempt that
+// resulted in err, with the HTTP status code of its response, or 0.
+func outcome(status int, err error) string {
+	if err == nil {
+		return OutcomeOK
+	}
+	var rangeException orange.ErrRangeException
+	if errors.As(err, &rangeException) {
+		return OutcomeRangeException
+	}
+	var statusNotOK orange.ErrStatusNotOK
+	if errors.As(err, &statusNotOK) {
+		return OutcomeStatusNotOK
+	}
+	var t timeout
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &t) && t.Timeout()) {
+		return OutcomeTimeout
+	}
+	if status == http.StatusOK {
+		return OutcomeOK // a hedged request that answered after another
+	}
+	return OutcomeError
+}
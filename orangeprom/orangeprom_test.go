@@ -0,0 +1,114 @@
+package orangeprom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/karrick/orange"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "i/o timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+func TestOutcome(t *testing.T) {
+	cases := []struct {
+		status int
+		err    error
+		want   string
+	}{
+		{http.StatusOK, nil, OutcomeOK},
+		{http.StatusOK, orange.ErrRangeException{Message: "NO_SUCH_CLUSTER: foo"}, OutcomeRangeException},
+		{http.StatusBadGateway, orange.ErrStatusNotOK{StatusCode: http.StatusBadGateway}, OutcomeStatusNotOK},
+		{0, fmt.Errorf("query failed: %w", context.DeadlineExceeded), OutcomeTimeout},
+		{0, &net.OpError{Op: "dial", Err: timeoutError{}}, OutcomeTimeout},
+		{0, errors.New("connection refused"), OutcomeError},
+		{http.StatusOK, errors.New("hedged request lost to a faster server"), OutcomeOK},
+	}
+	for _, c := range cases {
+		if got, want := outcome(c.status, c.err), c.want; got != want {
+			t.Errorf("%v: GOT: %v; WANT: %v", c.err, got, want)
+		}
+	}
+}
+
+func TestCollector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.RawQuery {
+		case "missing":
+			w.Header().Set("RangeException", "NO_SUCH_CLUSTER: missing")
+		case "down":
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("result1\nresult2\n"))
+		}
+	}))
+	defer server.Close()
+
+	collector := NewCollector()
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+
+	address := strings.TrimPrefix(server.URL, "http://")
+	client, err := orange.NewClient(&orange.Config{
+		HTTPClient:    server.Client(),
+		Observer:      collector,
+		RetryCallback: func(err error) bool { return strings.Contains(err.Error(), "503") },
+		RetryCount:    1,
+		Servers:       []string{address},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expression := range []string{"foo", "missing", "down"} {
+		_, _ = client.Query(expression)
+	}
+
+	queries := []struct {
+		outcome string
+		want    float64
+	}{
+		{OutcomeOK, 1},
+		{OutcomeRangeException, 1},
+		{OutcomeStatusNotOK, 1},
+	}
+	for _, c := range queries {
+		if got, want := testutil.ToFloat64(collector.queries.WithLabelValues(c.outcome)), c.want; got != want {
+			t.Errorf("%s: GOT: %v; WANT: %v", c.outcome, got, want)
+		}
+	}
+
+	// Only the 503 was retried.
+	attempts := []struct {
+		outcome string
+		want    float64
+	}{
+		{OutcomeOK, 1},
+		{OutcomeRangeException, 1},
+		{OutcomeStatusNotOK, 2},
+	}
+	for _, c := range attempts {
+		if got, want := testutil.ToFloat64(collector.attempts.WithLabelValues(address, c.outcome)), c.want; got != want {
+			t.Errorf("%s: GOT: %v; WANT: %v", c.outcome, got, want)
+		}
+	}
+
+	if got, want := testutil.ToFloat64(collector.inFlight), float64(0); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := testutil.CollectAndCount(collector.duration), 1; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
@@ -0,0 +1,204 @@
+// Package orangetest provides a range server for testing programs that query
+// range servers using the orange library, so those tests need not implement
+// the range protocol themselves.
+//
+// The test server resolves each query by invoking a handler function, and
+// speaks the protocol the way range servers do: it accepts queries sent using
+// GET in the URI, or using PUT or POST in a form encoded body that may be
+// compressed using gzip, responds with the values one per line or as a JSON
+// array when asked for JSON, and reports query errors using the
+// RangeException header.  Options cause it to reject long URIs or methods the
+// way some range servers and proxies do, so tests may exercise the client's
+// fallbacks.
+//
+//     server := orangetest.NewTestServer(func(query string) ([]string, error) {
+//         switch query {
+//         case "%web":
+//             return []string{"web1", "web2"}, nil
+//         case "%down":
+//             return nil, orangetest.Status(http.StatusServiceUnavailable)
+//         }
+//         return nil, orangetest.RangeException("NO_SUCH_CLUSTER: " + query)
+//     })
+//     defer server.Close()
+//
+//     client, err := orange.NewClient(&orange.Config{
+//         Servers: []string{strings.TrimPrefix(server.URL, "http://")},
+//     })
+package orangetest
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// RangeException is an error a handler returns to make the test server
+// respond with the RangeException header set to its message, which the client
+// returns as orange.ErrRangeException.
+type RangeException string
+
+func (err RangeException) Error() string {
+	return "RangeException: " + string(err)
+}
+
+// StatusError is an error a handler returns to make the test server respond
+// with its status code and body, which the client returns as
+// orange.ErrStatusNotOK.
+type StatusError struct {
+	Code int    // Code is the HTTP status code of the response.
+	Body string // Body is the body of the response, or empty to use the status text.
+}
+
+func (err StatusError) Error() string {
+	return fmt.Sprintf("%d %s", err.Code, http.StatusText(err.Code))
+}
+
+// Status returns a StatusError for code, whose body is the status text.
+func Status(code int) StatusError {
+	return StatusError{Code: code}
+}
+
+// Option configures the test server.
+type Option func(*server)
+
+// WithMaxURILength causes the test server to respond with 414 Request URI Too
+// Long to each GET request whose URI is longer than n bytes, so the client
+// sends the query again using PUT or POST.
+func WithMaxURILength(n int) Option {
+	return func(s *server) { s.maxURILength = n }
+}
+
+// WithMethods causes the test server to respond with 405 Method Not Allowed
+// to each request that uses a method other than those listed, such as
+// http.MethodGet alone, to test a client sending long queries to a server that
+// only accepts GET.
+func WithMethods(methods ...string) Option {
+	return func(s *server) {
+		s.methods = make(map[string]struct{}, len(methods))
+		for _, method := range methods {
+			s.methods[method] = struct{}{}
+		}
+	}
+}
+
+// server resolves queries sent to the test server.
+type server struct {
+	handler      func(query string) ([]string, error)
+	maxURILength int                 // maxURILength is 0 unless WithMaxURILength is provided
+	methods      map[string]struct{} // methods is nil unless WithMethods is provided
+}
+
+// NewTestServer returns a started range server that resolves each query by
+// invoking handler with its expression, and responds with the values handler
+// returns.  When handler returns a RangeException, the server sets the
+// RangeException header.  When it returns a StatusError, the server responds
+// with that status code.  Any other error causes a 500 Internal Server Error
+// response with the error as its body.  The server resolves queries sent to
+// the list and expand endpoints, and answers the count endpoint with the
+// number of values.  The caller must close the server.
+func NewTestServer(handler func(query string) ([]string, error), opts ...Option) *httptest.Server {
+	s := &server{handler: handler}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return httptest.NewServer(s)
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.methods != nil {
+		if _, ok := s.methods[r.Method]; !ok {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+	}
+
+	var query string
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if s.maxURILength > 0 && len(r.URL.RequestURI()) > s.maxURILength {
+			http.Error(w, http.StatusText(http.StatusRequestURITooLong), http.StatusRequestURITooLong)
+			return
+		}
+		var err error
+		if query, err = url.QueryUnescape(r.URL.RawQuery); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodPut, http.MethodPost:
+		var body io.Reader = r.Body
+		if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		buf, err := ioutil.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		form, err := url.ParseQuery(string(buf))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query = form.Get("query")
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/range/list", "/range/expand", "/range/count":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	values, err := s.handler(query)
+	if err != nil {
+		var exception RangeException
+		var status StatusError
+		switch {
+		case errors.As(err, &exception):
+			w.Header().Set("RangeException", string(exception))
+		case errors.As(err, &status):
+			body := status.Body
+			if body == "" {
+				body = http.StatusText(status.Code)
+			}
+			http.Error(w, body, status.Code)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.URL.Path == "/range/count" {
+		w.Write([]byte(strconv.Itoa(len(values)) + "\n"))
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		if values == nil {
+			values = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(values)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, value := range values {
+		w.Write([]byte(value + "\n"))
+	}
+}
@@ -0,0 +1,144 @@
+package orangetest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/karrick/orange"
+)
+
+// resolve is a handler that resolves "%web", fails "%down", and rejects every
+// other query.
+func resolve(query string) ([]string, error) {
+	switch {
+	case query == "%web":
+		return []string{"web1", "web2"}, nil
+	case query == "%down":
+		return nil, Status(http.StatusServiceUnavailable)
+	case query == "%broken":
+		return nil, errors.New("cannot reach database")
+	case strings.HasPrefix(query, "%long"):
+		return []string{"long1"}, nil
+	}
+	return nil, RangeException("NO_SUCH_CLUSTER: " + query)
+}
+
+func newClient(t *testing.T, url string, configure func(*orange.Config)) *orange.Client {
+	t.Helper()
+	config := &orange.Config{Servers: []string{strings.TrimPrefix(url, "http://")}}
+	if configure != nil {
+		configure(config)
+	}
+	client, err := orange.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func ensureValues(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if g, w := strings.Join(got, ","), strings.Join(want, ","); g != w {
+		t.Errorf("GOT: %v; WANT: %v", g, w)
+	}
+}
+
+func TestNewTestServer(t *testing.T) {
+	server := NewTestServer(resolve)
+	defer server.Close()
+
+	t.Run("values", func(t *testing.T) {
+		values, err := newClient(t, server.URL, nil).Query("%web")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ensureValues(t, values, "web1", "web2")
+	})
+
+	t.Run("json", func(t *testing.T) {
+		client := newClient(t, server.URL, func(config *orange.Config) { config.ResponseFormat = orange.JSONResponse })
+		values, err := client.Query("%web")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ensureValues(t, values, "web1", "web2")
+	})
+
+	t.Run("count", func(t *testing.T) {
+		client := newClient(t, server.URL, func(config *orange.Config) { config.UseCountEndpoint = true })
+		count, err := client.Count("%web")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := count, 2; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("range exception", func(t *testing.T) {
+		_, err := newClient(t, server.URL, nil).Query("%foo")
+		if got, want := orange.IsNoSuchCluster(err), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", err, "NO_SUCH_CLUSTER")
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		_, err := newClient(t, server.URL, nil).Query("%down")
+		if got, want := errors.Is(err, orange.ErrServiceUnavailable), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", err, orange.ErrServiceUnavailable)
+		}
+		_, err = newClient(t, server.URL, nil).Query("%broken")
+		if got, want := errors.Is(err, orange.ErrInternalServerError), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", err, orange.ErrInternalServerError)
+		}
+		if got, want := fmt.Sprint(err), "cannot reach database"; !strings.Contains(got, want) {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
+
+func TestNewTestServerLongQueries(t *testing.T) {
+	var lock sync.Mutex
+	var queries []string
+	record := func(query string) ([]string, error) {
+		lock.Lock()
+		queries = append(queries, query)
+		lock.Unlock()
+		return resolve(query)
+	}
+	long := "%long" + strings.Repeat("x", 100)
+
+	t.Run("uri too long", func(t *testing.T) {
+		server := NewTestServer(record, WithMaxURILength(50))
+		defer server.Close()
+
+		client := newClient(t, server.URL, func(config *orange.Config) {
+			config.GzipLongQueries = true
+			config.GzipLongQueryThreshold = 1
+		})
+		values, err := client.Query(long)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ensureValues(t, values, "long1")
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		server := NewTestServer(record, WithMethods(http.MethodGet))
+		defer server.Close()
+
+		client := newClient(t, server.URL, func(config *orange.Config) { config.QueryLengthThreshold = 50 })
+		values, err := client.Query(long)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ensureValues(t, values, "long1")
+	})
+
+	// Each server resolved only the query it accepted.
+	ensureValues(t, queries, long, long)
+}
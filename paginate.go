@@ -0,0 +1,43 @@
+package orange
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// DefaultPaginationPrefixes are the prefixes used to paginate queries when
+// PaginateQueries is true and no PaginationPrefixes are provided: each digit
+// and lower case letter.
+var DefaultPaginationPrefixes = strings.Split("0123456789abcdefghijklmnopqrstuvwxyz", "")
+
+// paginatedLines resolves the query expression using one sub-query for each of
+// the client's pagination prefixes, which returns the values of the expression
+// that begin with that prefix, followed by a final sub-query that returns the
+// values that begin with none of the prefixes.  It returns the union of the
+// sub-query results, so each sub-query response is smaller than the response
+// to the whole expression would be.
+func (c *Client) paginatedLines(ctx context.Context, expression string) ([]string, error) {
+	var lines []string
+	for _, subquery := range paginationQueries(expression, c.paginationPrefixes) {
+		var page []string
+		if _, err := c.queryCallback(ctx, subquery, c.appendValues(&page)); err != nil {
+			return nil, err
+		}
+		lines = append(lines, page...)
+	}
+	return Union(lines, nil), nil
+}
+
+// paginationQueries returns the sub-queries that together resolve to the same
+// values as expression: one that intersects expression with each prefix, and
+// a final one that subtracts the values matching any of the prefixes.
+func paginationQueries(expression string, prefixes []string) []string {
+	quoted := make([]string, len(prefixes))
+	queries := make([]string, 0, len(prefixes)+1)
+	for i, prefix := range prefixes {
+		quoted[i] = regexp.QuoteMeta(prefix)
+		queries = append(queries, "("+expression+")&/^"+quoted[i]+"/")
+	}
+	return append(queries, "("+expression+"),-/^(?:"+strings.Join(quoted, "|")+")/")
+}
@@ -0,0 +1,93 @@
+package orange
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestClientPaginateQueries(t *testing.T) {
+	dataset := []string{"app1", "app2", "Build1", "cache1", "db1", "db2", "db10", "-odd", "web1", "web2", "x9"}
+
+	// The handler emulates a range server that supports intersecting and
+	// subtracting regular expressions, sufficient for pagination sub-queries.
+	intersect := regexp.MustCompile(`^\(%all\)&/(.*)/$`)
+	subtract := regexp.MustCompile(`^\(%all\),-/(.*)/$`)
+
+	var queries []string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		expression, err := url.QueryUnescape(r.URL.RawQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queries = append(queries, expression)
+
+		keep := func(string) bool { return true }
+		if m := intersect.FindStringSubmatch(expression); m != nil {
+			keep = regexp.MustCompile(m[1]).MatchString
+		} else if m := subtract.FindStringSubmatch(expression); m != nil {
+			re := regexp.MustCompile(m[1])
+			keep = func(s string) bool { return !re.MatchString(s) }
+		} else if expression != "%all" {
+			w.Header().Set("RangeException", "unexpected query: "+expression)
+			return
+		}
+		for _, value := range dataset {
+			if keep(value) {
+				w.Write([]byte(value + "\n"))
+			}
+		}
+	}
+
+	var single []string
+	withClient(t, h, func(client *Client) {
+		var err error
+		single, err = client.Query("%all")
+		ensureError(t, err)
+	})
+
+	t.Run("default prefixes", func(t *testing.T) {
+		queries = nil
+		configure := func(config *Config) { config.PaginateQueries = true }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("%all")
+			ensureError(t, err)
+			sort.Strings(values)
+			sort.Strings(single)
+			if !reflect.DeepEqual(values, single) {
+				t.Errorf("GOT: %v; WANT: %v", values, single)
+			}
+		})
+		if got, want := len(queries), len(DefaultPaginationPrefixes)+1; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("custom prefixes", func(t *testing.T) {
+		queries = nil
+		configure := func(config *Config) {
+			config.PaginateQueries = true
+			config.PaginationPrefixes = []string{"db", "web", "a.p"}
+			config.SortResults = true
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("%all")
+			ensureError(t, err)
+			if !reflect.DeepEqual(values, single) {
+				t.Errorf("GOT: %v; WANT: %v", values, single)
+			}
+		})
+		want := []string{`(%all)&/^db/`, `(%all)&/^web/`, `(%all)&/^a\.p/`, `(%all),-/^(?:db|web|a\.p)/`}
+		if got := queries; !reflect.DeepEqual(got, want) {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("rejects empty prefix", func(t *testing.T) {
+		_, err := NewClient(&Config{PaginateQueries: true, PaginationPrefixes: []string{""}, Servers: []string{"localhost:8080"}})
+		ensureError(t, err, "empty PaginationPrefixes")
+	})
+}
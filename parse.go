@@ -0,0 +1,108 @@
+package orange
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// maxParseErrorContent is the most bytes of an offending line included in
+// ErrParse, so a huge malformed line does not produce a huge error.
+const maxParseErrorContent = 80
+
+// ErrParse is returned when a line of a range server's response cannot be
+// parsed.
+type ErrParse struct {
+	Line    int    // Line is the 1-based number of the offending line, or 0 when the response is not parsed by line.
+	Content string // Content is the offending line, truncated when long.
+	Err     error  // Err describes why the line could not be parsed.
+}
+
+func (err ErrParse) Error() string {
+	if err.Line == 0 {
+		return fmt.Sprintf("cannot parse response: %s", err.Err)
+	}
+	return fmt.Sprintf("cannot parse response line %d: %s: %q", err.Line, err.Err, err.Content)
+}
+
+// Unwrap returns the error that describes why the line could not be parsed.
+func (err ErrParse) Unwrap() error {
+	return err.Err
+}
+
+// utf8BOM is the byte order mark some servers write at the start of a
+// response.
+const utf8BOM = "\xef\xbb\xbf"
+
+// lineScanner scans the lines of a response, counting them so errors can
+// report the number and content of the offending line.
+type lineScanner struct {
+	*bufio.Scanner
+	line    int
+	pending []byte // pending is the data of the line being scanned
+}
+
+// newLineScanner returns a lineScanner that reads lines from ior.  Unless
+// preserve is true, a byte order mark at the start of the response and the
+// carriage return of each CRLF line ending are removed from the lines.
+func newLineScanner(ior io.Reader, preserve bool) *lineScanner {
+	s := &lineScanner{Scanner: bufio.NewScanner(ior)}
+	split := bufio.ScanLines
+	if preserve {
+		split = scanRawLines
+	}
+	first := !preserve
+	s.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if advance == 0 && token == nil {
+			s.pending = data // remember partial line in case it is too long
+		} else if first && token != nil {
+			first = false
+			token = bytes.TrimPrefix(token, []byte(utf8BOM))
+		}
+		return advance, token, err
+	})
+	return s
+}
+
+// scanRawLines is like bufio.ScanLines, but does not remove the carriage
+// return of a CRLF line ending.
+func scanRawLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[0:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func (s *lineScanner) Scan() bool {
+	if !s.Scanner.Scan() {
+		return false
+	}
+	s.line++
+	return true
+}
+
+// Err returns ErrParse when the scanner stopped at a line it could not scan.
+func (s *lineScanner) Err() error {
+	err := s.Scanner.Err()
+	if err == bufio.ErrTooLong {
+		return ErrParse{Line: s.line + 1, Content: truncate(string(s.pending), maxParseErrorContent), Err: err}
+	}
+	return err
+}
+
+// truncate returns s, shortened to at most max bytes followed by an ellipsis
+// when it is longer.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "..."
+}
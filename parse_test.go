@@ -0,0 +1,109 @@
+package orange
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestClientParseError(t *testing.T) {
+	long := strings.Repeat("x", bufio.MaxScanTokenSize)
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("result1\nresult2\n" + long + "\nresult4\n"))
+	}
+	withClient(t, h, func(client *Client) {
+		_, err := client.Query("foo")
+		var e ErrParse
+		if !errors.As(err, &e) {
+			t.Fatalf("GOT: %T; WANT: %T", err, e)
+		}
+		if got, want := e.Line, 3; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := e.Content, long[:maxParseErrorContent]+"..."; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := errors.Is(err, bufio.ErrTooLong), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		ensureError(t, err, "line 3")
+	})
+}
+
+func TestClientLineEndings(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(utf8BOM + "result1\r\nresult2\r\nresult3"))
+	}
+
+	t.Run("normalized", func(t *testing.T) {
+		withClient(t, h, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			if got, want := strings.Join(values, ","), "result1,result2,result3"; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+		})
+	})
+
+	t.Run("preserved", func(t *testing.T) {
+		configure := func(config *Config) { config.PreserveLineEndings = true }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			if got, want := strings.Join(values, ","), utf8BOM+"result1\r,result2\r,result3"; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+		})
+	})
+}
+
+func TestClientEmptyResult(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"empty body", "", []string{}},
+		{"newline", "\n", []string{}},
+		{"white space body", " \t\r\n  \n", []string{}},
+		{"single element", "result1", []string{"result1"}},
+		{"trailing newline", "result1\n", []string{"result1"}},
+		{"blank lines", "\nresult1\n\n  \nresult2\n\n", []string{"result1", "result2"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(c.body))
+			}
+			withClient(t, h, func(client *Client) {
+				values, err := client.Query("foo")
+				ensureError(t, err)
+				if values == nil {
+					t.Fatalf("GOT: %v; WANT: non-nil", values)
+				}
+				if got, want := strings.Join(values, ","), strings.Join(c.want, ","); got != want {
+					t.Errorf("GOT: %q; WANT: %q", got, want)
+				}
+				if got, want := len(values), len(c.want); got != want {
+					t.Errorf("GOT: %v; WANT: %v", got, want)
+				}
+			})
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("RangeException", "some error")
+		}
+		withClient(t, h, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err, "some error")
+			if values != nil {
+				t.Errorf("GOT: %v; WANT: %v", values, nil)
+			}
+		})
+	})
+}
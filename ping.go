@@ -0,0 +1,51 @@
+package orange
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultPingTimeout is used when no PingTimeout is provided to control how
+// long Ping waits for each range server to answer.
+const DefaultPingTimeout = 5 * time.Second
+
+// pingExpression is the query Ping sends to each range server.  The empty
+// expression is trivial for a server to resolve, and resolves to nothing.
+const pingExpression = ""
+
+// Ping sends a trivial query to every range server the client is configured
+// with, concurrently, and returns the result of each, keyed by server
+// address, where a nil error means the server answered the query.  Unlike
+// other queries, Ping targets every server rather than selecting one, and
+// neither retries failed queries nor records their results with the client's
+// circuit breaker or latency tracker.  Each server is given at most the
+// client's PingTimeout to answer, or less when ctx closes first, so a dead
+// server does not stall the check.
+func (c *Client) Ping(ctx context.Context) map[string]error {
+	servers := c.servers.Values()
+	results := make(map[string]error, len(servers))
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(len(servers))
+	for _, server := range servers {
+		go func(server string) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, c.pingTimeout)
+			err := c.query(pctx, listPath, pingExpression, discardResponse, server)
+			cancel()
+			lock.Lock()
+			results[server] = err
+			lock.Unlock()
+		}(server)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// discardResponse is a callback that ignores the response body, which the
+// client drains after the callback returns.
+func discardResponse(io.Reader) error { return nil }
@@ -0,0 +1,83 @@
+package orange
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientPing(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.URL.Path, listPath; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		w.Write([]byte("\n"))
+	}))
+	defer healthy.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	stall := make(chan struct{})
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+		}
+	}))
+	defer stalled.Close()
+	defer close(stall)
+
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	deadAddress := strings.TrimLeft(dead.URL, "http://")
+	dead.Close() // connections to this address will be refused
+
+	healthyAddress := strings.TrimLeft(healthy.URL, "http://")
+	failingAddress := strings.TrimLeft(failing.URL, "http://")
+	stalledAddress := strings.TrimLeft(stalled.URL, "http://")
+
+	t.Run("negative timeout", func(t *testing.T) {
+		_, err := NewClient(&Config{Servers: []string{healthyAddress}, PingTimeout: -time.Second})
+		ensureError(t, err, "negative PingTimeout")
+	})
+
+	t.Run("every server", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			PingTimeout: 50 * time.Millisecond,
+			Servers:     []string{healthyAddress, failingAddress, stalledAddress, deadAddress},
+		})
+		ensureError(t, err)
+		defer client.Close()
+
+		results := client.Ping(context.Background())
+
+		if got, want := len(results), 4; got != want {
+			t.Fatalf("GOT: %v; WANT: %v", got, want)
+		}
+		ensureError(t, results[healthyAddress])
+		ensureError(t, results[failingAddress], "Service Unavailable")
+		ensureError(t, results[stalledAddress], "deadline exceeded")
+		ensureError(t, results[deadAddress], "refused")
+	})
+
+	t.Run("context", func(t *testing.T) {
+		client, err := NewClient(&Config{Servers: []string{stalledAddress}})
+		ensureError(t, err)
+		defer client.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		started := time.Now()
+		results := client.Ping(ctx)
+		if elapsed := time.Since(started); elapsed > DefaultPingTimeout/2 {
+			t.Errorf("GOT: %v; WANT: < %v", elapsed, DefaultPingTimeout/2)
+		}
+		ensureError(t, results[stalledAddress], "deadline exceeded")
+	})
+}
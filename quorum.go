@@ -0,0 +1,147 @@
+package orange
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// QuorumVote is the result of the query QueryQuorum sent to one range server.
+type QuorumVote struct {
+	Server string   // Server is the address of the range server.
+	Values []string // Values are the sorted and deduplicated values the server returned, or nil when Err is not nil.
+	Err    error    // Err is the error of the query, or nil when the server returned Values.
+}
+
+// ErrQuorum is returned by QueryQuorum when the range servers it queried did
+// not all return the same values.  When more than half of them agreed,
+// QueryQuorum also returns the values they agreed on, and Dissent holds the
+// vote of each other server.  Otherwise Dissent holds the vote of every
+// server.
+type ErrQuorum struct {
+	Agreed  int          // Agreed is the number of servers that returned the most common values.
+	Queried int          // Queried is the number of servers queried.
+	Dissent []QuorumVote // Dissent holds the votes of servers that failed or did not return the majority values.
+}
+
+// Majority returns true when more than half of the servers queried returned
+// the same values.
+func (err ErrQuorum) Majority() bool {
+	return err.Agreed > err.Queried/2
+}
+
+func (err ErrQuorum) Error() string {
+	messages := make([]string, len(err.Dissent))
+	for i, vote := range err.Dissent {
+		if vote.Err != nil {
+			messages[i] = fmt.Sprintf("%s: %s", vote.Server, vote.Err)
+		} else {
+			messages[i] = fmt.Sprintf("%s: %d values", vote.Server, len(vote.Values))
+		}
+	}
+	if err.Majority() {
+		return fmt.Sprintf("%d of %d servers disagree with majority: %s", err.Queried-err.Agreed, err.Queried, strings.Join(messages, "; "))
+	}
+	return fmt.Sprintf("no majority of %d servers agree: %s", err.Queried, strings.Join(messages, "; "))
+}
+
+// QueryQuorum sends the query expression concurrently to n distinct range
+// servers, and returns the values that more than half of them agree on.  The
+// values returned by each server are sorted and deduplicated before they are
+// compared, and the values returned are sorted and deduplicated regardless of
+// the client's SortResults and DedupeResults settings.  Servers are chosen as
+// for the first attempt of a query, preferring servers that are available.
+// The query sent to each server is neither retried, cached, nor coalesced,
+// because each server must answer for itself.
+//
+// When every server returns the same values, the error is nil.  When a
+// majority agree but some servers fail or return other values, it returns the
+// majority values along with ErrQuorum, whose Dissent identifies each of those
+// servers, so a caller may alert on a server whose values have drifted.  When
+// no majority agree, it returns nil values and ErrQuorum.
+//
+//     values, err := client.QueryQuorum(ctx, "%all", 3)
+//     if err != nil {
+//         var e orange.ErrQuorum
+//         if !errors.As(err, &e) || !e.Majority() {
+//             return err
+//         }
+//         for _, vote := range e.Dissent {
+//             log.Printf("range server %s disagrees with majority", vote.Server)
+//         }
+//     }
+func (c *Client) QueryQuorum(ctx context.Context, expression string, n int) ([]string, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("cannot query quorum of non-positive number of servers: %d", n)
+	}
+	servers := c.serverSequence()
+	if len(servers) < n {
+		return nil, fmt.Errorf("cannot query quorum of %d servers with only %d servers", n, len(servers))
+	}
+	servers = servers[:n]
+
+	votes := make([]QuorumVote, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i, server := range servers {
+		votes[i].Server = server
+		go func(vote *QuorumVote) {
+			defer wg.Done()
+			var values []string
+			vote.Err = c.queryServer(ctx, func(ctx context.Context, server string) error {
+				return c.query(ctx, listPath, expression, c.appendValues(&values), server)
+			}, vote.Server)
+			if vote.Err == nil {
+				vote.Values = sortedSet(values)
+			}
+		}(&votes[i])
+	}
+	wg.Wait()
+
+	return tallyVotes(votes)
+}
+
+// tallyVotes returns the values returned by more than half of votes, and
+// ErrQuorum when any vote differs from them.
+func tallyVotes(votes []QuorumVote) ([]string, error) {
+	counts := make(map[string]int)
+	var winner string
+	for _, vote := range votes {
+		if vote.Err != nil {
+			continue
+		}
+		key := strings.Join(vote.Values, "\n")
+		counts[key]++
+		if counts[key] > counts[winner] || (counts[key] == counts[winner] && key < winner) {
+			winner = key
+		}
+	}
+
+	e := ErrQuorum{Agreed: counts[winner], Queried: len(votes)}
+	var values []string
+	for _, vote := range votes {
+		if vote.Err == nil && e.Majority() && strings.Join(vote.Values, "\n") == winner {
+			values = vote.Values
+			continue
+		}
+		e.Dissent = append(e.Dissent, vote)
+	}
+	if len(e.Dissent) > 0 {
+		return values, e
+	}
+	return values, nil
+}
+
+// sortedSet returns values sorted and without duplicates, never nil.
+func sortedSet(values []string) []string {
+	sort.Strings(values)
+	unique := make([]string, 0, len(values))
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
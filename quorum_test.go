@@ -0,0 +1,124 @@
+package orange
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTallyVotes(t *testing.T) {
+	t.Run("unanimous", func(t *testing.T) {
+		values, err := tallyVotes([]QuorumVote{
+			{Server: "one", Values: []string{"a", "b"}},
+			{Server: "two", Values: []string{"a", "b"}},
+		})
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"a", "b"})
+	})
+
+	t.Run("majority", func(t *testing.T) {
+		values, err := tallyVotes([]QuorumVote{
+			{Server: "one", Values: []string{"a", "b"}},
+			{Server: "two", Values: []string{"a", "c"}},
+			{Server: "three", Values: []string{"a", "b"}},
+		})
+		ensureStringSlicesMatch(t, values, []string{"a", "b"})
+		e, ok := err.(ErrQuorum)
+		if !ok {
+			t.Fatalf("GOT: %T; WANT: %T", err, e)
+		}
+		if got, want := e.Majority(), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := len(e.Dissent), 1; got != want {
+			t.Fatalf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := e.Dissent[0].Server, "two"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		ensureError(t, err, "1 of 3 servers disagree with majority: two: 2 values")
+	})
+
+	t.Run("no majority", func(t *testing.T) {
+		failure := errors.New("connection refused")
+		values, err := tallyVotes([]QuorumVote{
+			{Server: "one", Values: []string{"a"}},
+			{Server: "two", Err: failure},
+			{Server: "three", Values: []string{"b"}},
+			{Server: "four", Values: []string{"a"}},
+		})
+		if values != nil {
+			t.Errorf("GOT: %v; WANT: %v", values, nil)
+		}
+		e, ok := err.(ErrQuorum)
+		if !ok {
+			t.Fatalf("GOT: %T; WANT: %T", err, e)
+		}
+		if got, want := e.Majority(), false; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := e.Agreed, 2; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := len(e.Dissent), 4; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		var failed int
+		for _, vote := range e.Dissent {
+			if errors.Is(vote.Err, failure) {
+				failed++
+			}
+		}
+		if got, want := failed, 1; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		ensureError(t, err, "no majority of 4 servers agree")
+	})
+}
+
+func TestClientQueryQuorum(t *testing.T) {
+	respond := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+	}
+	first := respond("b\na\n")
+	defer first.Close()
+	second := respond("a\nb\nb\n")
+	defer second.Close()
+	drifted := respond("a\n")
+	defer drifted.Close()
+
+	address := func(server *httptest.Server) string {
+		return strings.TrimPrefix(server.URL, "http://")
+	}
+
+	client, err := NewClient(&Config{Servers: []string{address(first), address(second), address(drifted)}})
+	ensureError(t, err)
+
+	values, err := client.QueryQuorum(context.Background(), "foo", 2)
+	ensureError(t, err)
+	ensureStringSlicesMatch(t, values, []string{"a", "b"})
+
+	values, err = client.QueryQuorum(context.Background(), "foo", 3)
+	ensureStringSlicesMatch(t, values, []string{"a", "b"})
+	var e ErrQuorum
+	if !errors.As(err, &e) {
+		t.Fatalf("GOT: %T; WANT: %T", err, e)
+	}
+	if got, want := len(e.Dissent), 1; got != want {
+		t.Fatalf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := e.Dissent[0].Server, address(drifted); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	ensureStringSlicesMatch(t, e.Dissent[0].Values, []string{"a"})
+
+	_, err = client.QueryQuorum(context.Background(), "foo", 4)
+	ensureError(t, err, "quorum of 4 servers with only 3 servers")
+	_, err = client.QueryQuorum(context.Background(), "foo", 0)
+	ensureError(t, err, "non-positive")
+}
@@ -0,0 +1,49 @@
+package orange
+
+import (
+	"errors"
+	"strings"
+)
+
+// RangeExceptionNoSuchCluster is the Code of an ErrRangeException returned
+// when a query refers to a cluster the range server does not know.
+const RangeExceptionNoSuchCluster = "NO_SUCH_CLUSTER"
+
+// newRangeException returns an ErrRangeException for message, the value of a
+// RangeException header.  When message follows the convention of a code and a
+// human readable detail, such as "NO_SUCH_CLUSTER: foo", its Code and Detail
+// are set.  A message consisting of only a code sets only its Code.  Other
+// messages leave both empty.
+func newRangeException(message string) ErrRangeException {
+	e := ErrRangeException{Message: message}
+	code, detail := message, ""
+	if i := strings.IndexByte(message, ':'); i >= 0 {
+		code, detail = message[:i], strings.TrimSpace(message[i+1:])
+	}
+	if isRangeExceptionCode(code) {
+		e.Code, e.Detail = code, detail
+	}
+	return e
+}
+
+// isRangeExceptionCode returns true when code is an upper case letter followed
+// by any number of upper case letters, digits, and underscores.
+func isRangeExceptionCode(code string) bool {
+	if code == "" || code[0] < 'A' || code[0] > 'Z' {
+		return false
+	}
+	for i := 1; i < len(code); i++ {
+		switch b := code[i]; {
+		case b >= 'A' && b <= 'Z', b >= '0' && b <= '9', b == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// IsNoSuchCluster returns true when err is or wraps an ErrRangeException whose
+// Code is RangeExceptionNoSuchCluster.
+func IsNoSuchCluster(err error) bool {
+	return errors.Is(err, ErrRangeException{Code: RangeExceptionNoSuchCluster})
+}
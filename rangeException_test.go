@@ -0,0 +1,84 @@
+package orange
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestNewRangeException(t *testing.T) {
+	cases := []struct {
+		message string
+		code    string
+		detail  string
+	}{
+		{"NO_SUCH_CLUSTER: foo", "NO_SUCH_CLUSTER", "foo"},
+		{"NO_SUCH_CLUSTER:foo: bar", "NO_SUCH_CLUSTER", "foo: bar"},
+		{"NO_SUCH_CLUSTER", "NO_SUCH_CLUSTER", ""},
+		{"E2BIG: too many", "E2BIG", "too many"},
+		{"cannot parse query", "", ""},
+		{"Syntax error: unexpected }", "", ""},
+		{"NO SUCH CLUSTER: foo", "", ""},
+		{": foo", "", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.message, func(t *testing.T) {
+			e := newRangeException(c.message)
+			if got, want := e.Message, c.message; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+			if got, want := e.Code, c.code; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+			if got, want := e.Detail, c.detail; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+			if got, want := e.Error(), "RangeException: "+c.message; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestIsNoSuchCluster(t *testing.T) {
+	err := fmt.Errorf("query failed: %w", newRangeException("NO_SUCH_CLUSTER: foo"))
+	if got, want := IsNoSuchCluster(err), true; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := errors.Is(err, ErrRangeException{}), true; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := errors.Is(err, ErrRangeException{Code: "OTHER"}), false; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := IsNoSuchCluster(newRangeException("no such cluster: foo")), false; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, want := IsNoSuchCluster(errors.New("NO_SUCH_CLUSTER: foo")), false; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
+
+func TestClientRangeExceptionCode(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("RangeException", "NO_SUCH_CLUSTER: foo")
+	}
+	withClient(t, h, func(client *Client) {
+		_, err := client.Query("%foo")
+		e, ok := err.(ErrRangeException)
+		if !ok {
+			t.Fatalf("GOT: %T; WANT: %T", err, ErrRangeException{})
+		}
+		if got, want := e.Code, RangeExceptionNoSuchCluster; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := e.Detail, "foo"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := IsNoSuchCluster(err), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package orange
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// newRateLimiter returns a limiter that allows limit queries per second with
+// bursts of up to burst queries, or nil when limit is 0.  A burst less than 1
+// allows bursts of a single query.
+func newRateLimiter(limit float64, burst int) *rate.Limiter {
+	if limit == 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(limit), burst)
+}
+
+// serverLimiters lazily creates a rate limiter for each range server, so
+// servers added after the client is created also receive a request budget.
+type serverLimiters struct {
+	limit float64
+	burst int
+
+	lock     sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// newServerLimiters returns a rate limiter for each range server, or nil when
+// limit is 0.
+func newServerLimiters(limit float64, burst int) *serverLimiters {
+	if limit == 0 {
+		return nil
+	}
+	return &serverLimiters{limit: limit, burst: burst, limiters: make(map[string]*rate.Limiter)}
+}
+
+// get returns the rate limiter for server.
+func (sl *serverLimiters) get(server string) *rate.Limiter {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	limiter, ok := sl.limiters[server]
+	if !ok {
+		limiter = newRateLimiter(sl.limit, sl.burst)
+		sl.limiters[server] = limiter
+	}
+	return limiter
+}
+
+// waitForRateLimit blocks until the client's rate limit allows sending another
+// query, returning the context's error when it closes first.
+func (c *Client) waitForRateLimit(ctx context.Context) error {
+	return c.waitForToken(ctx, c.limiter)
+}
+
+// waitForServerBudget blocks until the request budget of server allows sending
+// it another query, returning the context's error when it closes first.
+func (c *Client) waitForServerBudget(ctx context.Context, server string) error {
+	if c.serverLimiters == nil {
+		return nil
+	}
+	return c.waitForToken(ctx, c.serverLimiters.get(server))
+}
+
+// hasServerBudget returns true unless the request budget of server is
+// exhausted.
+func (c *Client) hasServerBudget(server string) bool {
+	return c.serverLimiters == nil || c.serverLimiters.get(server).TokensAt(c.clock.Now()) >= 1
+}
+
+// waitForToken blocks until limiter, when not nil, allows another event,
+// returning the context's error when it closes first.
+func (c *Client) waitForToken(ctx context.Context, limiter *rate.Limiter) error {
+	if limiter == nil {
+		return nil
+	}
+	now := c.clock.Now()
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return errors.New("cannot wait for rate limit with burst less than 1") // not reached
+	}
+	delay := reservation.DelayFrom(now)
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		reservation.CancelAt(c.clock.Now()) // return the token for use by other queries
+		return ctx.Err()
+	case <-c.clock.After(delay):
+		return nil
+	}
+}
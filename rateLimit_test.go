@@ -0,0 +1,130 @@
+package orange
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientRateLimit(t *testing.T) {
+	t.Run("paces queries", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1\n"))
+		}
+		configure := func(config *Config) {
+			config.RateLimit = 50
+			config.RateBurst = 2
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			// The first two queries use the burst, and each of the remaining
+			// four waits for 1/50th of a second.
+			started := time.Now()
+			for i := 0; i < 6; i++ {
+				_, err := client.Query("foo")
+				ensureError(t, err)
+			}
+			if got, want := time.Since(started), 4*time.Second/50; got < want {
+				t.Errorf("GOT: %v; WANT: >= %v", got, want)
+			}
+		})
+	})
+
+	t.Run("returns context error while waiting", func(t *testing.T) {
+		var invocations int32
+		h := func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&invocations, 1)
+			w.Write([]byte("result1\n"))
+		}
+		configure := func(config *Config) { config.RateLimit = 0.001 }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query("foo")
+			ensureError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			_, err = client.QueryCtx(ctx, "foo")
+			if got, want := err, context.DeadlineExceeded; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+		if got, want := atomic.LoadInt32(&invocations), int32(1); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("rejects negative limit", func(t *testing.T) {
+		_, err := NewClient(&Config{RateLimit: -1, Servers: []string{"localhost:8080"}})
+		ensureError(t, err, "negative RateLimit")
+	})
+}
+
+func TestClientServerRateLimit(t *testing.T) {
+	var invocations [2]int32
+	newServer := func(i int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&invocations[i], 1)
+			w.Write([]byte("result1\n"))
+		}))
+	}
+	first := newServer(0)
+	defer first.Close()
+	second := newServer(1)
+	defer second.Close()
+
+	firstAddress := strings.TrimLeft(first.URL, "http://")
+	secondAddress := strings.TrimLeft(second.URL, "http://")
+
+	newClient := func(t *testing.T) *Client {
+		client, err := NewClient(&Config{
+			Clock:           newFakeClock(), // time does not pass, so budgets never refill
+			ServerRateLimit: 1,
+			Servers:         []string{firstAddress, secondAddress},
+		})
+		ensureError(t, err)
+		return client
+	}
+
+	t.Run("skips server with exhausted budget", func(t *testing.T) {
+		client := newClient(t)
+
+		// Exhaust the budget of the server round robin would choose next.
+		if !client.serverLimiters.get(firstAddress).AllowN(client.clock.Now(), 1) {
+			t.Fatal("cannot exhaust budget")
+		}
+
+		_, server, err := client.QueryWithServer("foo")
+		ensureError(t, err)
+		if got, want := server, secondAddress; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("enforces each budget independently", func(t *testing.T) {
+		atomic.StoreInt32(&invocations[0], 0)
+		atomic.StoreInt32(&invocations[1], 0)
+		client := newClient(t)
+
+		for i := 0; i < 2; i++ {
+			_, err := client.Query("foo")
+			ensureError(t, err)
+		}
+
+		// Both budgets are exhausted, so another query waits.
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := client.QueryCtx(ctx, "foo")
+		if got, want := err, context.DeadlineExceeded; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+
+		for i := range invocations {
+			if got, want := atomic.LoadInt32(&invocations[i]), int32(1); got != want {
+				t.Errorf("server %d: GOT: %v; WANT: %v", i, got, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package orange
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+)
+
+type contentTypeKey struct{}
+
+// recordContentType stores the Content-Type of a successful response in the
+// string attached to ctx by QueryBytesTypedCtx, if any.
+func recordContentType(ctx context.Context, contentType string) {
+	if p, ok := ctx.Value(contentTypeKey{}).(*string); ok {
+		*p = contentType
+	}
+}
+
+// QueryBytesTyped sends the query expression to a range server and returns the
+// raw response body along with the value of its Content-Type header, so a
+// caching layer outside the client may store the response and later serve it
+// with the correct headers.  It is a convenience wrapper for
+// QueryBytesTypedCtx using a background context.
+func (c *Client) QueryBytesTyped(expression string) ([]byte, string, error) {
+	return c.QueryBytesTypedCtx(context.Background(), expression)
+}
+
+// QueryBytesTypedCtx sends the query expression to a range server with the
+// provided context, and returns the raw response body along with the value of
+// its Content-Type header.  Servers are selected and the query is retried just
+// as they are for QueryCtx, and it returns the same error types.  The body is
+// returned exactly as the server sent it, so it is neither sorted nor
+// deduplicated, and it is neither cached nor coalesced.  The content type is
+// empty when the server did not send one.
+func (c *Client) QueryBytesTypedCtx(ctx context.Context, expression string) ([]byte, string, error) {
+	var body []byte
+	var contentType string
+
+	// Only the attempt whose response is delivered records its content type,
+	// so other attempts cannot race with it.
+	ctx = context.WithValue(ctx, contentTypeKey{}, &contentType)
+
+	err := c.QueryCallback(ctx, expression, func(ior io.Reader) error {
+		buf, err := ioutil.ReadAll(ior)
+		if err != nil {
+			return err
+		}
+		body = buf
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return body, contentType, nil
+}
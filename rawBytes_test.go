@@ -0,0 +1,73 @@
+package orange
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientQueryBytesTyped(t *testing.T) {
+	t.Run("content type", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("b\na\na\n"))
+		}
+		configure := func(config *Config) {
+			config.DedupeResults = true
+			config.SortResults = true
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			body, contentType, err := client.QueryBytesTyped("foo")
+			ensureError(t, err)
+			if got, want := string(body), "b\na\na\n"; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+			if got, want := contentType, "text/plain; charset=utf-8"; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+		})
+	})
+
+	t.Run("content type from successful retry", func(t *testing.T) {
+		var invocations int
+		h := func(w http.ResponseWriter, r *http.Request) {
+			invocations++
+			if invocations == 1 {
+				w.Header().Set("Content-Type", "text/html")
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`["a"]`))
+		}
+		configure := func(config *Config) {
+			config.RetryCallback = func(error) bool { return true }
+			config.RetryCount = 1
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			body, contentType, err := client.QueryBytesTyped("foo")
+			ensureError(t, err)
+			if got, want := string(body), `["a"]`; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+			if got, want := contentType, "application/json"; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+		})
+	})
+
+	t.Run("error", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("RangeException", "some error")
+		}
+		withClient(t, h, func(client *Client) {
+			body, contentType, err := client.QueryBytesTyped("foo")
+			ensureError(t, err, "some error")
+			if body != nil {
+				t.Errorf("GOT: %q; WANT: %v", body, nil)
+			}
+			if got, want := contentType, ""; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+		})
+	})
+}
@@ -0,0 +1,148 @@
+package orange
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// errReaderClosed is returned when reading from a query reader after it has
+// been closed.
+var errReaderClosed = errors.New("read from closed query reader")
+
+// errReaderAbandoned is returned by the callback of a query reader when the
+// reader is closed before its entire body is read, so the query closes the
+// response body rather than reading the remainder.
+var errReaderAbandoned = errors.New("query reader closed before reading entire response")
+
+// errResponseDelivered is returned by the callback of a query reader when the
+// query produces another successful response after one was already delivered
+// to the caller, so the second is discarded.
+var errResponseDelivered = errors.New("query reader already delivered a response")
+
+// QueryReader sends the query expression to a range server and returns its
+// response body for the caller to read and close.  It is a convenience wrapper
+// for QueryReaderCtx using a background context.
+func (c *Client) QueryReader(expression string) (io.ReadCloser, error) {
+	return c.QueryReaderCtx(context.Background(), expression)
+}
+
+// QueryReaderCtx sends the query expression to a range server with the
+// provided context, and returns the body of its successful response for the
+// caller to read as it arrives, so very large responses may be processed
+// without holding all of their values in memory.  Servers are selected, long
+// queries are sent using another method, and responses are checked just as
+// they are for QueryCtx, and it returns the same error types when no attempt
+// succeeds.  The body is neither sorted, deduplicated, cached, nor coalesced.
+//
+// Retries only apply to establishing a successful response: once the body is
+// returned, an error reading it, such as a connection reset by the server, is
+// returned by Read, and the query is not retried.  The caller must always
+// close the returned reader, which releases the connection and any
+// MaxConcurrency slot held by the query.  Closing it before reading the entire
+// body abandons the remainder of the response.
+//
+//     body, err := client.QueryReaderCtx(ctx, "%all")
+//     if err != nil {
+//         return err
+//     }
+//     defer body.Close()
+//     scanner := bufio.NewScanner(body)
+//     for scanner.Scan() {
+//         fmt.Println(scanner.Text())
+//     }
+//     return scanner.Err()
+func (c *Client) QueryReaderCtx(ctx context.Context, expression string) (io.ReadCloser, error) {
+	qctx, cancel := context.WithCancel(ctx)
+	r := &queryReader{
+		cancel: cancel,
+		bodies: make(chan io.Reader, 1),
+		closed: make(chan struct{}),
+		result: make(chan error, 1),
+	}
+	go func() {
+		r.result <- c.QueryCallback(qctx, expression, r.deliver)
+	}()
+
+	select {
+	case r.body = <-r.bodies:
+		return r, nil
+	case err := <-r.result:
+		cancel()
+		return nil, err
+	}
+}
+
+// queryReader is the io.ReadCloser returned by QueryReaderCtx.  The query's
+// callback delivers the response body, and then blocks until the reader is
+// closed, so the query does not close the body while the caller reads it, and
+// Close returns only after the query has released its connection and
+// concurrency slot.
+type queryReader struct {
+	cancel context.CancelFunc // cancel releases the context of the query
+	bodies chan io.Reader     // bodies receives the first successful response body
+	closed chan struct{}      // closed is closed by Close to release the callback
+	result chan error         // result receives the error of the query
+
+	body io.Reader
+
+	lock     sync.Mutex
+	isClosed bool
+	eof      bool // eof is true after the entire body is read
+	once     sync.Once
+	err      error
+}
+
+// deliver is the callback of the query, which hands the response body to the
+// caller and waits for the caller to close the reader.
+func (r *queryReader) deliver(body io.Reader) error {
+	select {
+	case r.bodies <- body:
+	default:
+		return errResponseDelivered
+	}
+	<-r.closed
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.eof {
+		return errReaderAbandoned
+	}
+	return nil
+}
+
+func (r *queryReader) Read(p []byte) (int, error) {
+	r.lock.Lock()
+	closed := r.isClosed
+	r.lock.Unlock()
+	if closed {
+		return 0, errReaderClosed
+	}
+	n, err := r.body.Read(p)
+	if err == io.EOF {
+		r.lock.Lock()
+		r.eof = true
+		r.lock.Unlock()
+	}
+	return n, err
+}
+
+// Close releases the response body and waits for the query to finish,
+// returning an error detected after the body was read, such as the response
+// exceeding MaxResponseSize.
+func (r *queryReader) Close() error {
+	r.once.Do(func() {
+		r.lock.Lock()
+		r.isClosed = true
+		r.lock.Unlock()
+
+		close(r.closed)
+		err := <-r.result
+		r.cancel()
+		if err == errReaderAbandoned || err == errResponseDelivered {
+			err = nil // caused by this Close
+		}
+		r.err = err
+	})
+	return r.err
+}
@@ -0,0 +1,86 @@
+package orange
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestClientQueryReader(t *testing.T) {
+	t.Run("streams body", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1\nresult2\n"))
+		}
+		withClient(t, h, func(client *Client) {
+			body, err := client.QueryReader("foo")
+			ensureError(t, err)
+			buf, err := ioutil.ReadAll(body)
+			ensureError(t, err)
+			if got, want := string(buf), "result1\nresult2\n"; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+			ensureError(t, body.Close())
+			ensureError(t, body.Close())
+
+			_, err = body.Read(make([]byte, 1))
+			ensureError(t, err, "closed query reader")
+		})
+	})
+
+	t.Run("error", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not here", http.StatusNotFound)
+		}
+		withClient(t, h, func(client *Client) {
+			body, err := client.QueryReader("foo")
+			ensureError(t, err, http.StatusText(http.StatusNotFound))
+			if body != nil {
+				t.Errorf("GOT: %v; WANT: %v", body, nil)
+			}
+		})
+	})
+
+	t.Run("closed early", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			for i := 0; i < 100000; i++ {
+				if _, err := fmt.Fprintf(w, "host%d\n", i); err != nil {
+					return // client closed the connection
+				}
+			}
+		}
+		configure := func(config *Config) { config.MaxConcurrency = 1 }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			body, err := client.QueryReader("foo")
+			ensureError(t, err)
+			scanner := bufio.NewScanner(body)
+			if !scanner.Scan() {
+				t.Fatal(scanner.Err())
+			}
+			if got, want := scanner.Text(), "host0"; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			ensureError(t, body.Close())
+
+			// Closing the reader released the query's concurrency slot.
+			if got, want := client.InFlight(), 0; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+
+	t.Run("response too large", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1\nresult2\nresult3\n"))
+		}
+		configure := func(config *Config) { config.MaxResponseSize = 10 }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			body, err := client.QueryReader("foo")
+			ensureError(t, err)
+			_, err = ioutil.ReadAll(body)
+			ensureError(t, err, "response too large")
+			ensureError(t, body.Close(), "response too large")
+		})
+	})
+}
@@ -0,0 +1,221 @@
+package orange
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// RecordedExchange is a single range server response, along with the request
+// attributes used to find it again during replay.
+type RecordedExchange struct {
+	Method     string      // Method is the HTTP method of the request.
+	Path       string      // Path is the URL path of the request.
+	Expression string      // Expression is the range expression sent in the request.
+	StatusCode int         // StatusCode is the numerical HTTP status code of the response.
+	Header     http.Header // Header contains the HTTP response headers.
+	Body       []byte      // Body contains the HTTP response body.
+}
+
+func (re *RecordedExchange) key() string {
+	return re.Method + " " + re.Path + " " + re.Expression
+}
+
+// Recorder is a Doer that forwards each request to another Doer, and records
+// each response it receives, so that the session may be saved and later served
+// by a Replayer.  It is intended to capture range server responses for
+// deterministic tests of programs that use this library.
+//
+//     recorder := orange.NewRecorder(http.DefaultClient)
+//     client, err := orange.NewClient(&orange.Config{
+//         HTTPClient: recorder,
+//         Servers:    []string{"localhost:8081"},
+//     })
+//     // ... issue queries ...
+//     err = recorder.Save("testdata/session.json")
+type Recorder struct {
+	doer      Doer
+	lock      sync.Mutex
+	exchanges map[string]RecordedExchange
+}
+
+// NewRecorder returns a Recorder that forwards requests to doer.
+func NewRecorder(doer Doer) *Recorder {
+	return &Recorder{doer: doer, exchanges: make(map[string]RecordedExchange)}
+}
+
+// Do forwards the request to the underlying Doer, and records the response
+// when one is received.  Requests that result in an error are not recorded.
+func (r *Recorder) Do(request *http.Request) (*http.Response, error) {
+	expression, err := requestExpression(request)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := r.doer.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := bytesFromReadCloser(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	re := RecordedExchange{
+		Method:     request.Method,
+		Path:       request.URL.Path,
+		Expression: expression,
+		StatusCode: response.StatusCode,
+		Header:     response.Header,
+		Body:       body,
+	}
+
+	r.lock.Lock()
+	r.exchanges[re.key()] = re
+	r.lock.Unlock()
+
+	return response, nil
+}
+
+// Exchanges returns the recorded exchanges, sorted by request.
+func (r *Recorder) Exchanges() []RecordedExchange {
+	r.lock.Lock()
+	exchanges := make([]RecordedExchange, 0, len(r.exchanges))
+	for _, re := range r.exchanges {
+		exchanges = append(exchanges, re)
+	}
+	r.lock.Unlock()
+
+	sort.Slice(exchanges, func(i, j int) bool { return exchanges[i].key() < exchanges[j].key() })
+	return exchanges
+}
+
+// WriteTo writes the recorded exchanges to w as JSON.
+func (r *Recorder) WriteTo(w io.Writer) (int64, error) {
+	buf, err := json.MarshalIndent(r.Exchanges(), "", "\t")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(buf, '\n'))
+	return int64(n), err
+}
+
+// Save writes the recorded exchanges to the named file, which may later be
+// loaded by LoadReplayer.
+func (r *Recorder) Save(pathname string) error {
+	fh, err := os.Create(pathname)
+	if err != nil {
+		return err
+	}
+	if _, err = r.WriteTo(fh); err != nil {
+		_ = fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// Replayer is a Doer that serves previously recorded responses rather than
+// sending requests to a range server.  Requests for which no response was
+// recorded result in an error.
+type Replayer struct {
+	exchanges map[string]RecordedExchange
+}
+
+// NewReplayer returns a Replayer that serves the recorded exchanges.
+func NewReplayer(exchanges []RecordedExchange) *Replayer {
+	r := &Replayer{exchanges: make(map[string]RecordedExchange, len(exchanges))}
+	for _, re := range exchanges {
+		r.exchanges[re.key()] = re
+	}
+	return r
+}
+
+// ReadReplayer returns a Replayer that serves the exchanges read as JSON from
+// ior, in the format written by Recorder.
+func ReadReplayer(ior io.Reader) (*Replayer, error) {
+	var exchanges []RecordedExchange
+	if err := json.NewDecoder(ior).Decode(&exchanges); err != nil {
+		return nil, fmt.Errorf("cannot read recorded exchanges: %s", err)
+	}
+	return NewReplayer(exchanges), nil
+}
+
+// LoadReplayer returns a Replayer that serves the exchanges saved to the named
+// file by Recorder.
+func LoadReplayer(pathname string) (*Replayer, error) {
+	fh, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ReadReplayer(fh)
+	if err2 := fh.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Do returns the recorded response for the request.
+func (r *Replayer) Do(request *http.Request) (*http.Response, error) {
+	expression, err := requestExpression(request)
+	if err != nil {
+		return nil, err
+	}
+
+	key := (&RecordedExchange{Method: request.Method, Path: request.URL.Path, Expression: expression}).key()
+	re, ok := r.exchanges[key]
+	if !ok {
+		return nil, fmt.Errorf("cannot find recorded response for %s %s: %q", request.Method, request.URL.Path, expression)
+	}
+
+	header := make(http.Header, len(re.Header))
+	for k, v := range re.Header {
+		header[k] = append([]string(nil), v...)
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(re.StatusCode) + " " + http.StatusText(re.StatusCode),
+		StatusCode:    re.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(re.Body)),
+		ContentLength: int64(len(re.Body)),
+		Request:       request,
+	}, nil
+}
+
+// requestExpression returns the range expression sent in the request, either
+// in the URI of a GET request, or in the form encoded body of other requests.
+// When the body is read, it is replaced so the request may still be sent.
+func requestExpression(request *http.Request) (string, error) {
+	if request.Method == http.MethodGet {
+		return url.QueryUnescape(request.URL.RawQuery)
+	}
+	if request.Body == nil {
+		return "", nil
+	}
+	body, err := bytesFromReadCloser(request.Body)
+	if err != nil {
+		return "", err
+	}
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return "", err
+	}
+	return values.Get("query"), nil
+}
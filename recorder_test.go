@@ -0,0 +1,99 @@
+package orange
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var longExpression strings.Builder
+	for i := 0; i < defaultQueryURILengthThreshold; i++ {
+		longExpression.WriteString("a")
+	}
+
+	expressions := []string{"foo", "%bar", "bad", "down", longExpression.String()}
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		expression, err := requestExpression(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch expression {
+		case "foo":
+			w.Write([]byte("result1\nresult2\n"))
+		case "%bar":
+			w.Write([]byte("result3\n"))
+		case "bad":
+			w.Header().Set("RangeException", "NO_SUCH_CLUSTER: bad")
+		case "down":
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(r.Method + "\n"))
+		}
+	}
+
+	type outcome struct {
+		values []string
+		err    error
+	}
+
+	dir, err := ioutil.TempDir("", "orange")
+	ensureError(t, err)
+	defer os.RemoveAll(dir)
+	pathname := filepath.Join(dir, "session.json")
+
+	var recorded []outcome
+
+	withTestServer(t, h, func(server *httptest.Server) {
+		recorder := NewRecorder(server.Client())
+		client, err := NewClient(&Config{
+			HTTPClient: recorder,
+			Servers:    []string{strings.TrimLeft(server.URL, "http://")},
+		})
+		ensureError(t, err)
+
+		for _, expression := range expressions {
+			values, err := client.Query(expression)
+			recorded = append(recorded, outcome{values, err})
+		}
+
+		ensureError(t, recorder.Save(pathname))
+
+		if got, want := len(recorder.Exchanges()), len(expressions); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	// The server has been shut down, so the replayer must serve all responses.
+	replayer, err := LoadReplayer(pathname)
+	ensureError(t, err)
+
+	client, err := NewClient(&Config{
+		HTTPClient: replayer,
+		Servers:    []string{"range.example.com"},
+	})
+	ensureError(t, err)
+
+	for i, expression := range expressions {
+		values, err := client.Query(expression)
+		if got, want := values, recorded[i].values; !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: GOT: %v; WANT: %v", expression, got, want)
+		}
+		if got, want := err, recorded[i].err; !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: GOT: %v; WANT: %v", expression, got, want)
+		}
+	}
+
+	if got, want := recorded[len(recorded)-1].values, []string{http.MethodPut}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+
+	_, err = client.Query("not recorded")
+	ensureError(t, err, "cannot find recorded response")
+}
@@ -0,0 +1,16 @@
+package orange
+
+import "net/http"
+
+// limitRedirects returns a redirect policy for an http.Client that follows at
+// most max redirects for each request, or none when max is negative, after
+// which the client returns the redirect response itself rather than an error,
+// so the query fails with ErrStatusNotOK describing the redirect.
+func limitRedirects(max int) func(*http.Request, []*http.Request) error {
+	return func(request *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package orange
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientRedirects(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("result1\nresult2\n"))
+	}))
+	defer target.Close()
+
+	// redirecting redirects a query of "twice" to itself before redirecting to
+	// target, and other queries directly to target.
+	var redirecting *httptest.Server
+	redirecting = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery == "twice" {
+			http.Redirect(w, r, redirecting.URL+r.URL.Path+"?once", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, target.URL+r.URL.Path+"?"+r.URL.RawQuery, http.StatusFound)
+	}))
+	defer redirecting.Close()
+
+	newClient := func(t *testing.T, config *Config) *Client {
+		config.Servers = []string{strings.TrimPrefix(redirecting.URL, "http://")}
+		client, err := NewClient(config)
+		ensureError(t, err)
+		return client
+	}
+
+	ensureFound := func(t *testing.T, err error) {
+		t.Helper()
+		if got, want := errors.Is(err, ErrStatusNotOK{StatusCode: http.StatusFound}), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", err, http.StatusText(http.StatusFound))
+		}
+	}
+
+	t.Run("followed by default", func(t *testing.T) {
+		values, err := newClient(t, &Config{}).Query("twice")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		_, err := newClient(t, &Config{MaxRedirects: -1}).Query("foo")
+		ensureFound(t, err)
+	})
+
+	t.Run("followed", func(t *testing.T) {
+		values, err := newClient(t, &Config{MaxRedirects: 1}).Query("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+	})
+
+	t.Run("too many", func(t *testing.T) {
+		client := newClient(t, &Config{MaxRedirects: 1})
+		_, err := client.Query("twice")
+		ensureFound(t, err)
+
+		client = newClient(t, &Config{MaxRedirects: 2})
+		values, err := client.Query("twice")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+	})
+
+	t.Run("check redirect", func(t *testing.T) {
+		var hosts []string
+		client := newClient(t, &Config{
+			CheckRedirect: func(request *http.Request, via []*http.Request) error {
+				hosts = append(hosts, request.URL.Host)
+				return nil
+			},
+		})
+		_, err := client.Query("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, hosts, []string{strings.TrimPrefix(target.URL, "http://")})
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		servers := []string{"range.example.com"}
+		_, err := NewClient(&Config{HTTPClient: http.DefaultClient, MaxRedirects: 1, Servers: servers})
+		ensureError(t, err, "both HTTPClient and a redirect policy")
+		_, err = NewClient(&Config{HTTPClient: http.DefaultClient, MaxRedirects: -1, Servers: servers})
+		ensureError(t, err, "both HTTPClient and a redirect policy")
+		_, err = NewClient(&Config{CheckRedirect: limitRedirects(1), MaxRedirects: 1, Servers: servers})
+		ensureError(t, err, "both CheckRedirect and MaxRedirects")
+	})
+}
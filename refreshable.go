@@ -0,0 +1,70 @@
+package orange
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RefreshableResult is a long-lived handle to the values of a query
+// expression, such as the membership of a cluster.  It remembers the values
+// from its most recent query, and transparently queries again once they are
+// older than its staleness window.  It is safe for concurrent use.
+type RefreshableResult struct {
+	client     *Client
+	expression string
+	staleness  time.Duration
+
+	flights *flightGroup // flights coalesces the queries of concurrent callers
+
+	lock      sync.Mutex
+	values    []string
+	fetched   bool
+	fetchedAt time.Time
+}
+
+// Refreshable returns a RefreshableResult for the query expression, whose
+// values are queried again when they are older than staleness.  No query is
+// sent until the first call to its Values method.
+//
+//     members := client.Refreshable("%cluster", time.Minute)
+//
+//     // Later, and as often as needed, without querying more than once a minute.
+//     values, err := members.Values(ctx)
+func (c *Client) Refreshable(expression string, staleness time.Duration) *RefreshableResult {
+	return &RefreshableResult{
+		client:     c,
+		expression: expression,
+		staleness:  staleness,
+		flights:    newFlightGroup(),
+	}
+}
+
+// Values returns the values of the query expression, querying range servers
+// using QueryCtx when no values have been fetched yet or the fetched values are
+// older than the staleness window.  Concurrent callers wait for a single query
+// rather than each sending their own, and each stops waiting when its own
+// context closes.  When the query fails, the error is returned and the
+// previous values are kept, so a later call queries again.  The returned slice
+// is a copy, which the caller may modify.
+func (rr *RefreshableResult) Values(ctx context.Context) ([]string, error) {
+	rr.lock.Lock()
+	if rr.fetched && rr.client.clock.Now().Sub(rr.fetchedAt) < rr.staleness {
+		values := copyStrings(rr.values)
+		rr.lock.Unlock()
+		return values, nil
+	}
+	rr.lock.Unlock()
+
+	return rr.flights.Do(ctx, rr.expression, func(ctx context.Context) ([]string, error) {
+		now := rr.client.clock.Now()
+		values, err := rr.client.QueryCtx(ctx, rr.expression)
+		if err != nil {
+			return nil, err
+		}
+		rr.lock.Lock()
+		rr.values, rr.fetched, rr.fetchedAt = values, true, now
+		rr.lock.Unlock()
+		return values, nil
+	})
+}
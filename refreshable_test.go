@@ -0,0 +1,104 @@
+package orange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientRefreshable(t *testing.T) {
+	var invocations int32
+	h := func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&invocations, 1)
+		if r.URL.RawQuery == "fail" {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "result%d\n", n)
+	}
+
+	fc := newFakeClock()
+	configure := func(config *Config) { config.Clock = fc }
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		ctx := context.Background()
+		rr := client.Refreshable("foo", time.Minute)
+
+		if got, want := atomic.LoadInt32(&invocations), int32(0); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+
+		values, err := rr.Values(ctx)
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1"})
+
+		// Values are reused before the staleness window elapses.
+		values[0] = "modified"
+		fc.Advance(59 * time.Second)
+		values, err = rr.Values(ctx)
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1"})
+
+		// Values are queried again after the staleness window elapses.
+		fc.Advance(time.Second)
+		values, err = rr.Values(ctx)
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result2"})
+
+		if got, want := atomic.LoadInt32(&invocations), int32(2); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			rr := client.Refreshable("fail", time.Minute)
+			_, err := rr.Values(context.Background())
+			ensureError(t, err, "Service Unavailable")
+
+			// Errors are not remembered.
+			_, err = rr.Values(context.Background())
+			ensureError(t, err, "Service Unavailable")
+		})
+	})
+
+	t.Run("waiting caller canceled", func(t *testing.T) {
+		doer := &blockingDoer{release: make(chan struct{})}
+		client, err := NewClient(&Config{
+			HTTPClient: doer,
+			Servers:    []string{"localhost:8081"},
+		})
+		ensureError(t, err)
+		rr := client.Refreshable("foo", time.Minute)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			values, err := rr.Values(context.Background())
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+		}()
+		for i := 0; rr.flights.Waiters("foo") != 1; i++ {
+			if i == 1000 {
+				t.Fatalf("GOT: %v; WANT: %v", rr.flights.Waiters("foo"), 1)
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		// A caller does not wait for the query in flight past its own deadline.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		_, err = rr.Values(ctx)
+		ensureError(t, err, context.DeadlineExceeded.Error())
+
+		close(doer.release)
+		<-done
+		if got, want := atomic.LoadInt32(&doer.count), int32(1); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
+
@@ -0,0 +1,125 @@
+package orange
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRetryAfter is used when no MaxRetryAfter is provided to control
+// the longest Retry-After delay the client will honor.
+const DefaultMaxRetryAfter = time.Minute
+
+// jitterRand is the package-local source of randomness for retry jitter.
+// Because *rand.Rand is not safe for concurrent use, it is guarded by
+// jitterLock.
+var (
+	jitterLock sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter returns a random duration in the range [0, max].
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	n := int64(max)
+	if n < math.MaxInt64 {
+		n++ // Int63n excludes its argument, but max is a valid result
+	}
+	jitterLock.Lock()
+	d := jitterRand.Int63n(n)
+	jitterLock.Unlock()
+	return time.Duration(d)
+}
+
+// makeRetryDelay returns a function that computes how long to pause prior to
+// the specified retry attempt, where attempt 1 is the first retry.  Without
+// backoff, every retry pauses for the same duration.  With backoff, the pause
+// doubles for each successive retry, up to max when max is positive.
+func makeRetryDelay(pause time.Duration, backoff bool, max time.Duration) func(int) time.Duration {
+	if !backoff {
+		return func(int) time.Duration { return pause }
+	}
+	return func(attempt int) time.Duration {
+		delay := pause
+		for i := 1; i < attempt && delay > 0; i++ {
+			if max > 0 && delay >= max {
+				break
+			}
+			if delay > math.MaxInt64>>1 {
+				delay = math.MaxInt64 // saturate rather than overflow
+				break
+			}
+			delay <<= 1
+		}
+		if max > 0 && delay > max {
+			delay = max
+		}
+		return delay
+	}
+}
+
+// RetryCount returns the number of times the client retries a failed query,
+// unless overridden for a single query by WithRetries or WithNoRetry.
+func (c *Client) RetryCount() int {
+	return c.retryCount
+}
+
+// retryPause returns the amount of time to wait prior to the specified retry
+// attempt, randomized within the client's configured jitter window.
+func (c *Client) retryPause(attempt int) time.Duration {
+	pause := c.retryDelay(attempt)
+	if j := jitter(c.retryJitter); j > 0 {
+		if pause > math.MaxInt64-j {
+			return math.MaxInt64
+		}
+		pause += j
+	}
+	return pause
+}
+
+// pauseBefore returns the amount of time to wait prior to the specified retry
+// attempt, given the error that resulted from the previous attempt.  When the
+// server asked the client to wait longer than the configured pause by way of
+// the Retry-After header, the server's request is honored, up to the client's
+// maximum.
+func (c *Client) pauseBefore(attempt int, previous error) time.Duration {
+	pause := c.retryPause(attempt)
+	if e, ok := previous.(ErrStatusNotOK); ok && e.RetryAfter > pause {
+		pause = e.RetryAfter
+		if pause > c.maxRetryAfter {
+			pause = c.maxRetryAfter
+		}
+	}
+	return pause
+}
+
+// parseRetryAfter returns the delay requested by the value of a Retry-After
+// header, which is either a number of seconds or an HTTP date, relative to
+// now.  It returns 0 when the value is empty, malformed, or in the past.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		if seconds > int64(math.MaxInt64/time.Second) {
+			return math.MaxInt64
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if d := when.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,617 @@
+package orange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMakeRetryDelay(t *testing.T) {
+	t.Run("fixed", func(t *testing.T) {
+		delay := makeRetryDelay(10*time.Millisecond, false, 0)
+		for attempt := 1; attempt < 5; attempt++ {
+			if got, want := delay(attempt), 10*time.Millisecond; got != want {
+				t.Errorf("attempt %d: GOT: %v; WANT: %v", attempt, got, want)
+			}
+		}
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		delay := makeRetryDelay(10*time.Millisecond, true, 0)
+		for attempt, want := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 80 * time.Millisecond} {
+			if got := delay(attempt + 1); got != want {
+				t.Errorf("attempt %d: GOT: %v; WANT: %v", attempt+1, got, want)
+			}
+		}
+	})
+
+	t.Run("backoff with ceiling", func(t *testing.T) {
+		delay := makeRetryDelay(10*time.Millisecond, true, 25*time.Millisecond)
+		for attempt, want := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond, 25 * time.Millisecond} {
+			if got := delay(attempt + 1); got != want {
+				t.Errorf("attempt %d: GOT: %v; WANT: %v", attempt+1, got, want)
+			}
+		}
+	})
+
+	t.Run("backoff saturates", func(t *testing.T) {
+		delay := makeRetryDelay(time.Hour, true, 0)
+		if got, want := delay(100), time.Duration(math.MaxInt64); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
+
+func TestClientRetryDelay(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}
+
+	var attempts []int
+
+	configure := func(config *Config) {
+		config.RetryCallback = func(error) bool { return true }
+		config.RetryCount = 3
+		config.RetryDelay = func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return 0
+		}
+	}
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		_, err := client.Query("foo")
+		ensureError(t, err, http.StatusText(http.StatusServiceUnavailable))
+	})
+
+	if got, want := fmt.Sprint(attempts), "[1 2 3]"; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
+
+func TestRetryJitter(t *testing.T) {
+	const pause = 10 * time.Millisecond
+	const window = 5 * time.Millisecond
+
+	client, err := NewClient(&Config{
+		RetryPause:  pause,
+		RetryJitter: window,
+		Servers:     []string{"localhost:8081"},
+	})
+	ensureError(t, err)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	observed := make(map[time.Duration]struct{})
+
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 128; i++ {
+				d := client.retryPause(1)
+				if d < pause || d > pause+window {
+					t.Errorf("GOT: %v; WANT: [%v, %v]", d, pause, pause+window)
+				}
+				lock.Lock()
+				observed[d] = struct{}{}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, want := len(observed), 1; got <= want {
+		t.Errorf("GOT: %v distinct pauses; WANT: more than %v", got, want)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"0", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"99999999999999999", time.Duration(math.MaxInt64)},
+	}
+	for _, c := range cases {
+		if got, want := parseRetryAfter(c.value, now), c.want; got != want {
+			t.Errorf("%q: GOT: %v; WANT: %v", c.value, got, want)
+		}
+	}
+}
+
+func TestClientRetryAfter(t *testing.T) {
+	fc := newSleepingClock()
+
+	run := func(t *testing.T, status int, retryAfter string, configure func(*Config)) []time.Duration {
+		t.Helper()
+		var invocations int32
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&invocations, 1) == 1 {
+				w.Header().Set("Retry-After", retryAfter)
+				http.Error(w, "slow down", status)
+				return
+			}
+			w.Write([]byte("result1\n"))
+		}
+		sleeps := len(fc.Sleeps())
+		withConfiguredClient(t, h, func(config *Config) {
+			config.Clock = fc
+			config.RetryCallback = func(error) bool { return true }
+			config.RetryPause = time.Second
+			if configure != nil {
+				configure(config)
+			}
+		}, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1"})
+		})
+		return fc.Sleeps()[sleeps:]
+	}
+
+	t.Run("delta seconds", func(t *testing.T) {
+		sleeps := run(t, http.StatusTooManyRequests, "7", nil)
+		if got, want := fmt.Sprint(sleeps), "[7s]"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("http date", func(t *testing.T) {
+		sleeps := run(t, http.StatusServiceUnavailable, fc.Now().Add(30*time.Second).Format(http.TimeFormat), nil)
+		if got, want := fmt.Sprint(sleeps), "[30s]"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("shorter than pause", func(t *testing.T) {
+		sleeps := run(t, http.StatusTooManyRequests, "0", nil)
+		if got, want := fmt.Sprint(sleeps), "[1s]"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("capped", func(t *testing.T) {
+		sleeps := run(t, http.StatusTooManyRequests, "3600", func(config *Config) {
+			config.MaxRetryAfter = 10 * time.Second
+		})
+		if got, want := fmt.Sprint(sleeps), "[10s]"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("default cap", func(t *testing.T) {
+		sleeps := run(t, http.StatusServiceUnavailable, "86400", nil)
+		if got, want := sleeps, []time.Duration{DefaultMaxRetryAfter}; len(got) != 1 || got[0] != want[0] {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("ignored for other status codes", func(t *testing.T) {
+		sleeps := run(t, http.StatusBadGateway, "7", nil)
+		if got, want := fmt.Sprint(sleeps), "[1s]"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
+
+// cancelingClock is a sleepingClock that cancels a context whenever it sleeps,
+// simulating a context that expires during the pause between retries.
+type cancelingClock struct {
+	sleepingClock
+	cancel context.CancelFunc
+}
+
+func (cc *cancelingClock) After(d time.Duration) <-chan time.Time {
+	cc.cancel()
+	return cc.sleepingClock.After(d)
+}
+
+func TestClientRetryContextExpires(t *testing.T) {
+	var invocations int32
+	h := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&invocations, 1)
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}
+
+	t.Run("returns error of completed attempt", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		configure := func(config *Config) {
+			config.Clock = &cancelingClock{sleepingClock: newSleepingClock(), cancel: cancel}
+			config.RetryCallback = func(error) bool { return true }
+			config.RetryPause = time.Second
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.QueryCtx(ctx, "foo")
+			if _, ok := err.(ErrStatusNotOK); !ok {
+				t.Errorf("GOT: %v; WANT: %T", err, ErrStatusNotOK{})
+			}
+		})
+		if got, want := atomic.LoadInt32(&invocations), int32(1); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("returns context error before any attempt completes", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		withClient(t, h, func(client *Client) {
+			_, err := client.QueryCtx(ctx, "foo")
+			if got, want := err, context.Canceled; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+}
+
+func TestClientRetryCallbackCtx(t *testing.T) {
+	type keyType struct{}
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}
+
+	t.Run("arguments", func(t *testing.T) {
+		var calls []string
+		var values []interface{}
+
+		configure := func(config *Config) {
+			config.RetryCount = 5
+			config.RetryCallbackCtx = func(ctx context.Context, attempt int, server string, err error) bool {
+				calls = append(calls, fmt.Sprintf("%d %t %t", attempt, server == config.Servers[0], statusCode(err) == http.StatusServiceUnavailable))
+				values = append(values, ctx.Value(keyType{}))
+				return attempt < 3
+			}
+		}
+
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			ctx := context.WithValue(context.Background(), keyType{}, "value")
+			_, err := client.QueryCtx(ctx, "foo")
+			ensureError(t, err, http.StatusText(http.StatusServiceUnavailable))
+		})
+
+		if got, want := fmt.Sprint(calls), "[1 true true 2 true true 3 true true]"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := fmt.Sprint(values), "[value value value]"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("not invoked after final attempt", func(t *testing.T) {
+		var calls int
+
+		configure := func(config *Config) {
+			config.RetryCount = 2
+			config.RetryCallbackCtx = func(context.Context, int, string, error) bool {
+				calls++
+				return true
+			}
+		}
+
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query("foo")
+			ensureError(t, err, http.StatusText(http.StatusServiceUnavailable))
+		})
+
+		if got, want := calls, 2; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("both callbacks", func(t *testing.T) {
+		_, err := NewClient(&Config{
+			RetryCallback:    func(error) bool { return true },
+			RetryCallbackCtx: func(context.Context, int, string, error) bool { return true },
+			Servers:          []string{"range.example.com"},
+		})
+		ensureError(t, err, "both RetryCallback and RetryCallbackCtx")
+	})
+}
+
+func TestClientDefaultRetryCallback(t *testing.T) {
+	dnsError := &url.Error{Op: "Get", URL: "http://missing.example.com/range/list?foo", Err: &net.OpError{
+		Op:  "dial",
+		Net: "tcp",
+		Err: &net.DNSError{Err: "no such host", Name: "missing.example.com", IsNotFound: true},
+	}}
+
+	test := func(t *testing.T, config *Config, want bool) {
+		t.Helper()
+		client, err := NewClient(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if got := client.retryCallback(context.Background(), 1, "missing.example.com", dnsError); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	}
+
+	t.Run("single server", func(t *testing.T) {
+		test(t, &Config{Servers: []string{"range.example.com"}}, false)
+	})
+
+	t.Run("multiple servers", func(t *testing.T) {
+		test(t, &Config{Servers: []string{"range1.example.com", "range2.example.com"}}, true)
+	})
+
+	t.Run("weighted servers", func(t *testing.T) {
+		test(t, &Config{WeightedServers: []ServerWeight{
+			{Server: "range1.example.com", Weight: 1},
+			{Server: "range2.example.com", Weight: 2},
+		}}, true)
+	})
+
+	t.Run("srv record", func(t *testing.T) {
+		test(t, &Config{
+			SRVRecord: "_range._tcp.example.com",
+			SRVResolver: &fakeResolver{records: []*net.SRV{
+				{Target: "range1.example.com.", Port: 8081},
+				{Target: "range2.example.com.", Port: 8081},
+			}},
+		}, true)
+	})
+}
+
+func TestClientMaxQueryDuration(t *testing.T) {
+	t.Run("negative", func(t *testing.T) {
+		_, err := NewClient(&Config{MaxQueryDuration: -time.Second, Servers: []string{"range.example.com"}})
+		ensureError(t, err, "negative MaxQueryDuration")
+	})
+
+	t.Run("retries", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		}
+		configure := func(config *Config) {
+			config.MaxQueryDuration = 50 * time.Millisecond
+			config.RetryCallback = func(error) bool { return true }
+			config.RetryCount = 1000
+			config.RetryPause = 10 * time.Millisecond
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			started := time.Now()
+			_, err := client.Query("foo")
+			if elapsed := time.Since(started); elapsed > time.Second {
+				t.Errorf("GOT: %v; WANT: < %v", elapsed, time.Second)
+			}
+			var e ErrQueryBudgetExceeded
+			if !errors.As(err, &e) {
+				t.Fatalf("GOT: %T; WANT: %T", err, e)
+			}
+			if got, want := e.Budget, 50*time.Millisecond; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := errors.Is(err, ErrServiceUnavailable), true; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			ensureError(t, err, "exceeded budget of 50ms")
+		})
+	})
+
+	t.Run("hung attempt", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}
+		configure := func(config *Config) { config.MaxQueryDuration = 20 * time.Millisecond }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query("foo")
+			var e ErrQueryBudgetExceeded
+			if !errors.As(err, &e) {
+				t.Fatalf("GOT: %T; WANT: %T", err, e)
+			}
+			if got, want := errors.Is(err, context.DeadlineExceeded), true; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+
+	t.Run("context closes first", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}
+		configure := func(config *Config) { config.MaxQueryDuration = time.Minute }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			_, err := client.QueryCtx(ctx, "foo")
+			var e ErrQueryBudgetExceeded
+			if errors.As(err, &e) {
+				t.Fatalf("GOT: %v; WANT: context error", err)
+			}
+			ensureError(t, err, context.DeadlineExceeded.Error())
+		})
+	})
+
+	t.Run("success", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1\n"))
+		}
+		configure := func(config *Config) { config.MaxQueryDuration = time.Minute }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1"})
+		})
+	})
+}
+
+func TestClientPerAttemptTimeout(t *testing.T) {
+	t.Run("negative", func(t *testing.T) {
+		_, err := NewClient(&Config{PerAttemptTimeout: -time.Second, Servers: []string{"range.example.com"}})
+		ensureError(t, err, "negative PerAttemptTimeout")
+	})
+
+	t.Run("hung attempt retried", func(t *testing.T) {
+		var requests int32
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) == 1 {
+				<-r.Context().Done() // hang until the client gives up
+				return
+			}
+			w.Write([]byte("result1\nresult2\n"))
+		}
+		configure := func(config *Config) {
+			config.PerAttemptTimeout = 50 * time.Millisecond
+			config.RetryCount = 1
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			started := time.Now()
+			values, err := client.QueryCtx(ctx, "foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+			if elapsed := time.Since(started); elapsed > 5*time.Second {
+				t.Errorf("GOT: %v; WANT: < %v", elapsed, 5*time.Second)
+			}
+		})
+		if got, want := atomic.LoadInt32(&requests), int32(2); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("every attempt hangs", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}
+		configure := func(config *Config) {
+			config.PerAttemptTimeout = 20 * time.Millisecond
+			config.RetryCount = 1
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query("foo")
+			if got, want := errors.Is(err, context.DeadlineExceeded), true; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+	})
+}
+
+func TestClientRetryPauseCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var invocations int32
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&invocations, 1) == 1 {
+			time.AfterFunc(20*time.Millisecond, cancel) // cancel during the pause
+		}
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}
+
+	configure := func(config *Config) {
+		config.RetryCallback = func(error) bool { return true }
+		config.RetryPause = time.Minute
+	}
+
+	withConfiguredClient(t, h, configure, func(client *Client) {
+		started := time.Now()
+		_, err := client.QueryCtx(ctx, "foo")
+		if elapsed := time.Since(started); elapsed > 10*time.Second {
+			t.Errorf("GOT: %v; WANT: < %v", elapsed, 10*time.Second)
+		}
+		if _, ok := err.(ErrStatusNotOK); !ok {
+			t.Errorf("GOT: %v; WANT: %T", err, ErrStatusNotOK{})
+		}
+	})
+
+	if got, want := atomic.LoadInt32(&invocations), int32(1); got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+}
+
+func TestTriedServers(t *testing.T) {
+	servers := []string{"one", "two", "three"}
+
+	t.Run("unlimited", func(t *testing.T) {
+		tried := newTriedServers(0)
+		for _, server := range []string{"one", "three"} {
+			if got, want := tried.allow(server), true; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		}
+		if got, want := strings.Join(tried.preferUntried(servers), ","), "two,one,three"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("limited", func(t *testing.T) {
+		tried := newTriedServers(2)
+		tried.allow("two")
+		if got, want := strings.Join(tried.preferUntried(servers), ","), "one,three,two"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		tried.allow("three")
+		// Once the limit is reached, only servers already tried are allowed.
+		if got, want := strings.Join(tried.preferUntried(servers), ","), "two,three,one"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
+
+func TestClientRetryPrefersUntriedServer(t *testing.T) {
+	var lock sync.Mutex
+	hits := make(map[string]int)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits["failing"]++
+		lock.Unlock()
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits["healthy"]++
+		lock.Unlock()
+		w.Write([]byte("result1\n"))
+	}))
+	defer healthy.Close()
+
+	// Random selection may pick the failing server for consecutive attempts,
+	// but a retry is sent to the server not yet tried.
+	client, err := NewClient(&Config{
+		RetryCallback:     func(error) bool { return true },
+		RetryCount:        1,
+		SelectionStrategy: Random,
+		Servers:           []string{strings.TrimPrefix(failing.URL, "http://"), strings.TrimPrefix(healthy.URL, "http://")},
+	})
+	ensureError(t, err)
+
+	const queries = 50
+	for i := 0; i < queries; i++ {
+		_, err := client.Query("foo")
+		ensureError(t, err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if got, want := hits["healthy"], queries; got != want {
+		t.Errorf("GOT: %v; WANT: %v", got, want)
+	}
+	if got, max := hits["failing"], queries; got > max {
+		t.Errorf("GOT: %v; WANT: <= %v", got, max)
+	}
+}
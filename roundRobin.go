@@ -2,44 +2,219 @@ package orange
 
 import (
 	"errors"
+	"sync"
 	"sync/atomic"
 )
 
 // roundRobinStrings returns a structure that on each invocation of its Next()
 // method, returns the next string value from the list of values when it was
-// initialized.  On rollover, it returns the first value from the list.
+// initialized.  On rollover, it returns the first value from the list.  The
+// list of values may be replaced or changed while in use.
+//
+// Each value may be given a weight, and values are returned in proportion to
+// their weights, interleaved so that a heavily weighted value is not returned
+// many times in a row.  When every weight is 1, values are returned in the
+// order they were provided.
+//
+// When random is true, each invocation of Next instead returns a value chosen
+// at random, still in proportion to the weights.
 type roundRobinStrings struct {
-	values []string
+	lock   sync.Mutex   // lock serializes changes to state
+	state  atomic.Value // state holds a *roundRobinState that is replaced, never modified
 	i      uint32
+	random bool // random is set before the structure is shared, and never changed
+}
+
+// roundRobinState is an immutable snapshot of the values of a
+// roundRobinStrings structure.
+type roundRobinState struct {
+	values   []string
+	weights  []int
+	schedule []int // schedule holds the index of the value for each turn of the rotation
 }
 
 func newRoundRobinStrings(someStrings []string) (*roundRobinStrings, error) {
+	rrs := new(roundRobinStrings)
+	if err := rrs.Set(someStrings); err != nil {
+		return nil, err
+	}
+	return rrs, nil
+}
+
+// newWeightedRoundRobinStrings returns a roundRobinStrings structure whose
+// strings are returned in proportion to the corresponding weights.
+func newWeightedRoundRobinStrings(someStrings []string, weights []int) (*roundRobinStrings, error) {
+	rrs := new(roundRobinStrings)
+	if err := rrs.SetWeighted(someStrings, weights); err != nil {
+		return nil, err
+	}
+	return rrs, nil
+}
+
+// Set replaces the strings in the roundRobinStrings structure, giving each of
+// them a weight of 1.
+func (rr *roundRobinStrings) Set(someStrings []string) error {
+	return rr.SetWeighted(someStrings, nil)
+}
+
+// SetWeighted replaces the strings in the roundRobinStrings structure, along
+// with their weights.  When weights is nil, each string is given a weight of
+// 1.
+func (rr *roundRobinStrings) SetWeighted(someStrings []string, weights []int) error {
 	l := len(someStrings)
 	if l == 0 {
-		return nil, errors.New("cannot create a round robin strings structure without at least one string")
+		return errors.New("cannot create a round robin strings structure without at least one string")
+	}
+	if weights != nil && len(weights) != l {
+		return errors.New("cannot create a round robin strings structure without one weight for each string")
+	}
+	for _, w := range weights {
+		if w <= 0 {
+			return errors.New("cannot create a round robin strings structure with non-positive weight")
+		}
 	}
 
-	// Create the data structure
-	rrs := &roundRobinStrings{
-		values: make([]string, l),
+	// Populate data structure with a copy of values.
+	values := make([]string, l)
+	copy(values, someStrings)
+	rr.lock.Lock()
+	rr.state.Store(newRoundRobinState(values, copyWeights(weights, l)))
+	rr.lock.Unlock()
+
+	return nil
+}
+
+// copyWeights returns a copy of weights, or l weights of 1 when weights is
+// nil.
+func copyWeights(weights []int, l int) []int {
+	copied := make([]int, l)
+	if weights == nil {
+		for i := range copied {
+			copied[i] = 1
+		}
+		return copied
 	}
+	copy(copied, weights)
+	return copied
+}
 
-	// Populate data structure with values.
-	copy(rrs.values, someStrings)
+// newRoundRobinState returns the state for values with the corresponding
+// weights, neither of which it copies.
+func newRoundRobinState(values []string, weights []int) *roundRobinState {
+	divisor := weights[0]
+	for _, w := range weights[1:] {
+		divisor = gcd(divisor, w)
+	}
+	var total int
+	for _, w := range weights {
+		total += w / divisor
+	}
 
-	return rrs, nil
+	// Interleave the values using smooth weighted round robin: on each turn,
+	// every value earns its weight, and the value with the most earned is
+	// chosen and pays back the total of all weights.
+	schedule := make([]int, total)
+	current := make([]int, len(weights))
+	for turn := range schedule {
+		best := 0
+		for i, w := range weights {
+			current[i] += w / divisor
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		schedule[turn] = best
+	}
+
+	return &roundRobinState{values: values, weights: weights, schedule: schedule}
+}
+
+// gcd returns the greatest common divisor of two positive integers.
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Add appends s, with a weight of 1, to the strings in the roundRobinStrings
+// structure, and returns true, unless s is already one of its strings.
+func (rr *roundRobinStrings) Add(s string) bool {
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+
+	state := rr.load()
+	for _, v := range state.values {
+		if v == s {
+			return false
+		}
+	}
+	l := len(state.values)
+	values := make([]string, l, l+1)
+	copy(values, state.values)
+	weights := make([]int, l, l+1)
+	copy(weights, state.weights)
+	rr.state.Store(newRoundRobinState(append(values, s), append(weights, 1)))
+	return true
+}
+
+// Remove removes s from the strings in the roundRobinStrings structure, and
+// returns true, unless s is not one of its strings.  It returns an error
+// rather than removing the only string.
+func (rr *roundRobinStrings) Remove(s string) (bool, error) {
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+
+	state := rr.load()
+	values := make([]string, 0, len(state.values))
+	weights := make([]int, 0, len(state.weights))
+	for i, v := range state.values {
+		if v != s {
+			values = append(values, v)
+			weights = append(weights, state.weights[i])
+		}
+	}
+	if len(values) == len(state.values) {
+		return false, nil
+	}
+	if len(values) == 0 {
+		return false, errors.New("cannot remove the only string from a round robin strings structure")
+	}
+	rr.state.Store(newRoundRobinState(values, weights))
+	return true, nil
+}
+
+func (rr *roundRobinStrings) load() *roundRobinState {
+	return rr.state.Load().(*roundRobinState)
 }
 
 // Len returns the number of strings in the roundRobinStrings structure.
-func (rr *roundRobinStrings) Len() int { return len(rr.values) }
+func (rr *roundRobinStrings) Len() int { return len(rr.Values()) }
+
+// Period returns the number of invocations of Next after which the rotation
+// repeats, which is the number of strings when every weight is 1.
+func (rr *roundRobinStrings) Period() int { return len(rr.load().schedule) }
+
+// Values returns the strings in the roundRobinStrings structure.  The caller
+// must not modify the returned slice.
+func (rr *roundRobinStrings) Values() []string { return rr.load().values }
 
 // Next returns the next string in the roundRobinStrings structure.
 func (rr *roundRobinStrings) Next() string {
-	l := uint32(len(rr.values))
+	state := rr.load()
+	return state.values[state.schedule[rr.next(uint32(len(state.schedule)))]]
+}
 
+// next advances the rotation, and returns the index of the next of l turns.
+func (rr *roundRobinStrings) next(l uint32) uint32 {
 	// Fast case when only a single value in list.
 	if l == 1 {
-		return rr.values[0]
+		return 0
+	}
+
+	if rr.random {
+		return uint32(randomIntn(int(l)))
 	}
 
 	var i, ni uint32
@@ -48,12 +223,31 @@ func (rr *roundRobinStrings) Next() string {
 		i = atomic.LoadUint32(&rr.i)
 		ni = (i + 1) % l
 		if atomic.CompareAndSwapUint32(&rr.i, i, ni) {
-			return rr.values[i]
+			return i % l // list may have shrunk since i was stored
 		}
 	}
 
 	// During high contention, give up and send back the string corresponding to
 	// our last attempt.  This use-case does not require absolute perfect round
 	// robin order.  Do not let perfect be the enemy of good enough.
-	return rr.values[i]
+	return i % l
+}
+
+// Sequence returns every string in the roundRobinStrings structure, beginning
+// with the string Next would have returned, and continuing in round robin
+// order, each string only once.  It advances the rotation just as a single
+// call to Next would.
+func (rr *roundRobinStrings) Sequence() []string {
+	state := rr.load()
+	l := uint32(len(state.schedule))
+	i := rr.next(l)
+	sequence := make([]string, 0, len(state.values))
+	seen := make([]bool, len(state.values))
+	for j := uint32(0); j < l && len(sequence) < len(state.values); j++ {
+		if k := state.schedule[(i+j)%l]; !seen[k] {
+			seen[k] = true
+			sequence = append(sequence, state.values[k])
+		}
+	}
+	return sequence
 }
@@ -1,6 +1,10 @@
 package orange
 
-import "testing"
+import (
+	"strings"
+	"sync"
+	"testing"
+)
 
 func TestRoundRobin(t *testing.T) {
 	t.Run("empty", func(t *testing.T) {
@@ -58,4 +62,170 @@ func TestRoundRobin(t *testing.T) {
 			t.Errorf("GOT: %v; WANT: %v", got, want)
 		}
 	})
+	t.Run("sequence", func(t *testing.T) {
+		rrs, err := newRoundRobinStrings([]string{"one", "two", "three"})
+		ensureError(t, err)
+
+		ensureStringSlicesMatch(t, rrs.Sequence(), []string{"one", "two", "three"})
+
+		if got, want := strings.Join(rrs.Sequence(), ","), "two,three,one"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+
+		if got, want := rrs.Next(), "three"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+	t.Run("add and remove", func(t *testing.T) {
+		rrs, err := newRoundRobinStrings([]string{"one", "two"})
+		ensureError(t, err)
+
+		if got, want := rrs.Add("three"), true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := rrs.Add("two"), false; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := strings.Join(rrs.Values(), ","), "one,two,three"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+
+		removed, err := rrs.Remove("one")
+		ensureError(t, err)
+		if got, want := removed, true; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		removed, err = rrs.Remove("four")
+		ensureError(t, err)
+		if got, want := removed, false; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := strings.Join(rrs.Values(), ","), "two,three"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+
+		_, err = rrs.Remove("two")
+		ensureError(t, err)
+		_, err = rrs.Remove("three")
+		ensureError(t, err, "cannot remove the only string")
+		if got, want := rrs.Next(), "three"; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("remove while rotating", func(t *testing.T) {
+		rrs, err := newRoundRobinStrings([]string{"one", "two", "three", "four"})
+		ensureError(t, err)
+
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						if rrs.Next() == "" {
+							t.Error("GOT: empty string; WANT: value")
+							return
+						}
+						_ = rrs.Sequence()
+					}
+				}
+			}()
+		}
+
+		for i := 0; i < 1000; i++ {
+			_, _ = rrs.Remove("four")
+			_, _ = rrs.Remove("three")
+			rrs.Add("three")
+			rrs.Add("four")
+		}
+		close(stop)
+		wg.Wait()
+
+		ensureStringSlicesMatch(t, rrs.Values(), []string{"one", "two", "three", "four"})
+	})
+	t.Run("weighted", func(t *testing.T) {
+		_, err := newWeightedRoundRobinStrings([]string{"one", "two"}, []int{1, 0})
+		ensureError(t, err, "non-positive weight")
+
+		_, err = newWeightedRoundRobinStrings([]string{"one", "two"}, []int{1})
+		ensureError(t, err, "one weight for each string")
+
+		rrs, err := newWeightedRoundRobinStrings([]string{"small", "medium", "large"}, []int{10, 20, 70})
+		ensureError(t, err)
+
+		if got, want := rrs.Period(), 10; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+
+		const calls = 10000
+		counts := make(map[string]int)
+		var previous string
+		var run, longestRun int
+		for i := 0; i < calls; i++ {
+			value := rrs.Next()
+			counts[value]++
+			if value == previous {
+				run++
+			} else {
+				previous, run = value, 1
+			}
+			if run > longestRun {
+				longestRun = run
+			}
+		}
+
+		for value, weight := range map[string]int{"small": 10, "medium": 20, "large": 70} {
+			want := calls * weight / 100
+			if got := counts[value]; got < want*95/100 || got > want*105/100 {
+				t.Errorf("%s: GOT: %v; WANT: %v", value, got, want)
+			}
+		}
+
+		// The heavily weighted value is interleaved with the others rather than
+		// returned many times in a row.
+		if got, want := longestRun, 3; got > want {
+			t.Errorf("GOT: %v; WANT: <= %v", got, want)
+		}
+
+		ensureStringSlicesMatch(t, rrs.Sequence(), []string{"small", "medium", "large"})
+	})
+
+	t.Run("weighted add and remove", func(t *testing.T) {
+		rrs, err := newWeightedRoundRobinStrings([]string{"one", "two"}, []int{3, 1})
+		ensureError(t, err)
+
+		rrs.Add("three")
+		if got, want := rrs.Period(), 5; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+
+		_, err = rrs.Remove("one")
+		ensureError(t, err)
+		if got, want := rrs.Period(), 2; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+	t.Run("random", func(t *testing.T) {
+		rrs, err := newRoundRobinStrings([]string{"one", "two", "three", "four"})
+		ensureError(t, err)
+		rrs.random = true
+
+		counts := make(map[string]int)
+		for i := 0; i < 1000; i++ {
+			counts[rrs.Next()]++
+		}
+		for _, value := range rrs.Values() {
+			if counts[value] == 0 {
+				t.Errorf("%s: GOT: %v; WANT: > 0", value, counts[value])
+			}
+		}
+
+		ensureStringSlicesMatch(t, rrs.Sequence(), []string{"one", "two", "three", "four"})
+	})
 }
@@ -0,0 +1,208 @@
+package orange
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SelectionStrategy controls how the client chooses the range server to send
+// each query to.
+type SelectionStrategy int
+
+const (
+	// RoundRobin sends queries to each range server in turn.  It is the
+	// default strategy.
+	RoundRobin SelectionStrategy = iota
+
+	// Random sends each query to a range server chosen at random, so many
+	// clients started at the same time do not send their queries to the same
+	// servers in the same order.
+	Random
+
+	// LeastLatency sends queries to the range server with the lowest moving
+	// average latency.  Servers that have not yet answered a query are tried
+	// first so their latency is measured, and when TryAllServers is also true,
+	// servers are tried in order of increasing latency.  Every
+	// LatencyProbeEvery-th query attempt is sent to the slower server measured
+	// least recently, so a server that recovers from a period of high latency
+	// is preferred again.
+	LeastLatency
+)
+
+func (s SelectionStrategy) String() string {
+	switch s {
+	case RoundRobin:
+		return "RoundRobin"
+	case Random:
+		return "Random"
+	case LeastLatency:
+		return "LeastLatency"
+	}
+	return fmt.Sprintf("SelectionStrategy(%d)", int(s))
+}
+
+// selectionRand is the package-local source of randomness for the Random
+// selection strategy.  Because *rand.Rand is not safe for concurrent use, it
+// is guarded by selectionLock.
+var (
+	selectionLock sync.Mutex
+	selectionRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// randomIntn returns a random integer in the range [0, n).
+func randomIntn(n int) int {
+	selectionLock.Lock()
+	i := selectionRand.Intn(n)
+	selectionLock.Unlock()
+	return i
+}
+
+// nextServer returns the range server to send the next query to.  Servers are
+// chosen in round robin order, or in order of lowest latency when the client
+// prefers low latency servers, skipping servers that are not available.  When
+// no server is available, it returns the next server anyway, because
+// attempting a query is better than failing without trying.
+func (c *Client) nextServer() string {
+	if c.latencies != nil {
+		ranked := c.latencies.Ranked(c.servers.Values())
+		if len(ranked) > 1 && c.latencies.ProbeDue() {
+			var slower []string
+			for _, server := range ranked[1:] {
+				if c.isAvailable(server) {
+					slower = append(slower, server)
+				}
+			}
+			if server, ok := c.latencies.Stalest(slower); ok {
+				return server
+			}
+		}
+		for _, server := range ranked {
+			if c.isAvailable(server) {
+				return server
+			}
+		}
+		return ranked[0]
+	}
+	if c.breaker == nil && c.serverLimiters == nil {
+		return c.servers.Next()
+	}
+	for i := c.servers.Period(); i > 0; i-- {
+		if server := c.servers.Next(); c.isAvailable(server) {
+			return server
+		}
+	}
+	return c.servers.Next()
+}
+
+// serverSequence returns every available range server, in the order they ought
+// to be tried.  When no server is available, it returns every server.
+func (c *Client) serverSequence() []string {
+	var sequence []string
+	if c.latencies != nil {
+		sequence = c.latencies.Ranked(c.servers.Values())
+	} else {
+		sequence = c.servers.Sequence()
+	}
+	if c.breaker == nil && c.serverLimiters == nil {
+		return sequence
+	}
+	allowed := make([]string, 0, len(sequence))
+	for _, server := range sequence {
+		if c.isAvailable(server) {
+			allowed = append(allowed, server)
+		}
+	}
+	if len(allowed) == 0 {
+		return sequence
+	}
+	return allowed
+}
+
+// isAvailable returns true unless the circuit of server is open, or its
+// request budget is exhausted.
+func (c *Client) isAvailable(server string) bool {
+	if c.breaker != nil && !c.breaker.Allow(server) {
+		return false
+	}
+	return c.hasServerBudget(server)
+}
+
+// errServerLimit is returned when a query attempt would be sent to more
+// distinct range servers than the client's MaxServersPerQuery allows.
+var errServerLimit = errors.New("query already sent to maximum number of servers")
+
+// triedServers records the distinct range servers a single query has been
+// sent to, so retries may prefer servers the query has not yet been sent to,
+// and so a query can be limited to a maximum number of them.  A nil
+// *triedServers allows every server.
+type triedServers struct {
+	lock    sync.Mutex
+	max     int // max is the most distinct servers allowed, or 0 for no limit
+	servers map[string]struct{}
+}
+
+// newTriedServers returns a structure that allows a query to be sent to at
+// most max distinct servers, or to any number of them when max is not
+// positive.
+func newTriedServers(max int) *triedServers {
+	if max < 0 {
+		max = 0
+	}
+	return &triedServers{max: max, servers: make(map[string]struct{}, max)}
+}
+
+// has returns true when the query has already been sent to server.
+func (ts *triedServers) has(server string) bool {
+	if ts == nil {
+		return false
+	}
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	_, ok := ts.servers[server]
+	return ok
+}
+
+// preferUntried returns servers reordered so the servers the query has not yet
+// been sent to come first, and otherwise in their original order, so a retry
+// is not sent to a server that already failed while another remains.  Once
+// the query has been sent to the maximum number of servers, servers it has
+// already been sent to come first instead, because only they are allowed.
+func (ts *triedServers) preferUntried(servers []string) []string {
+	if ts == nil {
+		return servers
+	}
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	full := ts.max > 0 && len(ts.servers) >= ts.max
+	preferred := make([]string, 0, len(servers))
+	var others []string
+	for _, server := range servers {
+		if _, ok := ts.servers[server]; ok == full {
+			preferred = append(preferred, server)
+		} else {
+			others = append(others, server)
+		}
+	}
+	return append(preferred, others...)
+}
+
+// allow returns true and records server when the query has already been sent
+// to server, or has not yet been sent to the maximum number of servers.
+func (ts *triedServers) allow(server string) bool {
+	if ts == nil {
+		return true
+	}
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if _, ok := ts.servers[server]; ok {
+		return true
+	}
+	if ts.max > 0 && len(ts.servers) == ts.max {
+		return false
+	}
+	ts.servers[server] = struct{}{}
+	return true
+}
@@ -0,0 +1,137 @@
+package orange
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// endpoint returns the URI of the endpoint path of the range server.  Servers
+// provided as base URLs already include their scheme and any path prefix, and
+// other servers use the client's scheme.
+func (c *Client) endpoint(server, path string) string {
+	if strings.Contains(server, "://") {
+		return server + path
+	}
+	return c.scheme + "://" + server + path
+}
+
+// normalizeBaseURL returns the range server base URL normalized as by
+// normalizeServer, without a trailing slash, so endpoint paths may be
+// appended to it.
+func normalizeBaseURL(server string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "https":
+	default:
+		return "", fmt.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("base URL may only include scheme, host, port, and path")
+	}
+	host, err := normalizeServer(u.Host)
+	if err != nil {
+		return "", err
+	}
+	return u.Scheme + "://" + host + strings.TrimRight(u.EscapedPath(), "/"), nil
+}
+
+// normalizeServer returns the range server address in the form used to build
+// the URIs of queries, wrapping an IPv6 address in brackets so its colons are
+// not mistaken for the separator of a port.  An IPv6 address followed by a
+// port must already be wrapped in brackets, as in "[::1]:8081", because
+// otherwise the port cannot be distinguished from the address.  It returns an
+// error when the address cannot be used as the host and optional port of a
+// URI.  A server provided as a base URL, such as
+// "https://range.example.com:9443/proxy", is normalized by normalizeBaseURL.
+func normalizeServer(server string) (string, error) {
+	if server == "" {
+		return "", errors.New("empty address")
+	}
+	if strings.Contains(server, "://") {
+		return normalizeBaseURL(server)
+	}
+	if host, port, err := net.SplitHostPort(server); err == nil {
+		if host == "" {
+			return "", errors.New("missing host")
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return "", fmt.Errorf("invalid port: %q", port)
+		}
+		return net.JoinHostPort(host, port), nil
+	}
+	host := server
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil {
+			return "[" + host + "]", nil
+		}
+		return host, nil
+	}
+	if host != server || strings.ContainsAny(host, ":/[]@ ") {
+		return "", errors.New("invalid host")
+	}
+	return host, nil
+}
+
+// normalizeServers returns a copy of servers with each address normalized by
+// normalizeServer, or an error that names the first invalid address.
+func normalizeServers(servers []string) ([]string, error) {
+	normalized := make([]string, len(servers))
+	for i, server := range servers {
+		n, err := normalizeServer(server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range server address: %q: %s", server, err)
+		}
+		normalized[i] = n
+	}
+	return normalized, nil
+}
+
+// Servers returns the addresses of the range servers the client currently
+// sends queries to.  The returned slice is a copy, which the caller may
+// modify.
+func (c *Client) Servers() []string {
+	return append([]string(nil), c.servers.Values()...)
+}
+
+// AddServer adds the address of a range server to those the client sends
+// queries to, normalized as for Config.Servers.  Adding a server the client
+// already uses has no effect.  When the client was created with SRVRecord, the
+// next refresh of the record replaces the servers, including those added by
+// this method.
+func (c *Client) AddServer(server string) error {
+	if server == "" {
+		return errors.New("cannot add empty range server address")
+	}
+	normalized, err := normalizeServer(server)
+	if err != nil {
+		return fmt.Errorf("cannot add invalid range server address: %q: %s", server, err)
+	}
+	c.servers.Add(normalized)
+	return nil
+}
+
+// RemoveServer removes the address of a range server from those the client
+// sends queries to.  Queries already sent to the server are not interrupted,
+// and later attempts select from the remaining servers.  Removing a server the
+// client does not use has no effect, and the client refuses to remove its only
+// server.  When the client was created with SRVRecord, the next refresh of the
+// record replaces the servers, including any that were removed by this method.
+func (c *Client) RemoveServer(server string) error {
+	if normalized, err := normalizeServer(server); err == nil {
+		server = normalized
+	}
+	if _, err := c.servers.Remove(server); err != nil {
+		return fmt.Errorf("cannot remove only range server address: %q", server)
+	}
+	return nil
+}
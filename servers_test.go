@@ -0,0 +1,257 @@
+package orange
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClientServers(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + "\n"))
+	}
+	first := httptest.NewServer(http.HandlerFunc(h))
+	defer first.Close()
+	second := httptest.NewServer(http.HandlerFunc(h))
+	defer second.Close()
+
+	firstAddress := strings.TrimLeft(first.URL, "http://")
+	secondAddress := strings.TrimLeft(second.URL, "http://")
+
+	client, err := NewClient(&Config{Servers: []string{firstAddress}})
+	ensureError(t, err)
+	defer client.Close()
+
+	ensureError(t, client.AddServer(""), "empty")
+
+	ensureError(t, client.AddServer(secondAddress))
+	ensureError(t, client.AddServer(secondAddress))
+	ensureStringSlicesMatch(t, client.Servers(), []string{firstAddress, secondAddress})
+
+	// Modifying the returned slice does not modify the client's servers.
+	client.Servers()[0] = "modified"
+	ensureStringSlicesMatch(t, client.Servers(), []string{firstAddress, secondAddress})
+
+	ensureError(t, client.RemoveServer(firstAddress))
+	ensureError(t, client.RemoveServer(firstAddress))
+	ensureStringSlicesMatch(t, client.Servers(), []string{secondAddress})
+
+	for i := 0; i < 3; i++ {
+		values, err := client.Query("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{secondAddress})
+	}
+
+	ensureError(t, client.RemoveServer(secondAddress), "cannot remove only range server")
+	ensureStringSlicesMatch(t, client.Servers(), []string{secondAddress})
+}
+
+func TestClientWeightedServers(t *testing.T) {
+	var lock sync.Mutex
+	hits := make(map[string]int)
+	h := func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits[r.Host]++
+		lock.Unlock()
+		w.Write([]byte("result\n"))
+	}
+	small := httptest.NewServer(http.HandlerFunc(h))
+	defer small.Close()
+	large := httptest.NewServer(http.HandlerFunc(h))
+	defer large.Close()
+
+	smallAddress := strings.TrimLeft(small.URL, "http://")
+	largeAddress := strings.TrimLeft(large.URL, "http://")
+
+	t.Run("non-positive weight", func(t *testing.T) {
+		_, err := NewClient(&Config{WeightedServers: []ServerWeight{{Server: largeAddress}}})
+		ensureError(t, err, "non-positive Weight")
+	})
+
+	t.Run("excessive weight", func(t *testing.T) {
+		_, err := NewClient(&Config{WeightedServers: []ServerWeight{
+			{Server: largeAddress, Weight: 1e9},
+			{Server: smallAddress, Weight: 1},
+		}})
+		ensureError(t, err, "Weight greater than 100")
+	})
+
+	t.Run("distribution", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			Servers:         []string{smallAddress},
+			WeightedServers: []ServerWeight{{Server: largeAddress, Weight: 3}},
+		})
+		ensureError(t, err)
+		defer client.Close()
+
+		ensureStringSlicesMatch(t, client.Servers(), []string{smallAddress, largeAddress})
+
+		for i := 0; i < 100; i++ {
+			_, err := client.Query("foo")
+			ensureError(t, err)
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if got, want := hits[smallAddress], 25; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := hits[largeAddress], 75; got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
+
+func TestClientSelectionStrategy(t *testing.T) {
+	t.Run("unknown", func(t *testing.T) {
+		_, err := NewClient(&Config{Servers: []string{"one"}, SelectionStrategy: SelectionStrategy(42)})
+		ensureError(t, err, "unknown SelectionStrategy: SelectionStrategy(42)")
+	})
+
+	t.Run("random", func(t *testing.T) {
+		var lock sync.Mutex
+		hits := make(map[string]int)
+		h := func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			hits[r.Host]++
+			lock.Unlock()
+			w.Write([]byte("result\n"))
+		}
+
+		var servers []string
+		for i := 0; i < 4; i++ {
+			server := httptest.NewServer(http.HandlerFunc(h))
+			defer server.Close()
+			servers = append(servers, strings.TrimLeft(server.URL, "http://"))
+		}
+
+		client, err := NewClient(&Config{Servers: servers, SelectionStrategy: Random})
+		ensureError(t, err)
+		defer client.Close()
+
+		for i := 0; i < 200; i++ {
+			_, err := client.Query("foo")
+			ensureError(t, err)
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		for _, server := range servers {
+			if hits[server] == 0 {
+				t.Errorf("%s: GOT: %v; WANT: > 0", server, hits[server])
+			}
+		}
+	})
+}
+
+func TestNormalizeServer(t *testing.T) {
+	cases := []struct {
+		server string
+		want   string
+		err    string
+	}{
+		{server: "range.example.com", want: "range.example.com"},
+		{server: "range.example.com:8081", want: "range.example.com:8081"},
+		{server: "127.0.0.1", want: "127.0.0.1"},
+		{server: "127.0.0.1:8081", want: "127.0.0.1:8081"},
+		{server: "::1", want: "[::1]"},
+		{server: "[::1]", want: "[::1]"},
+		{server: "[::1]:8081", want: "[::1]:8081"},
+		{server: "fe80::1", want: "[fe80::1]"},
+		{server: "2001:db8::8081", want: "[2001:db8::8081]"},
+		{server: "http://range.example.com", want: "http://range.example.com"},
+		{server: "https://range.example.com:9443/proxy/", want: "https://range.example.com:9443/proxy"},
+		{server: "http://[::1]:8081/proxy", want: "http://[::1]:8081/proxy"},
+		{server: "ftp://range.example.com", err: "unsupported scheme"},
+		{server: "http://", err: "empty address"},
+		{server: "http://range.example.com/proxy?q=1", err: "only include scheme, host, port, and path"},
+		{server: "http://user@range.example.com", err: "only include scheme, host, port, and path"},
+		{server: "http://range.example.com:http", err: "invalid port"},
+		{server: "", err: "empty address"},
+		{server: ":8081", err: "missing host"},
+		{server: "range.example.com:http", err: "invalid port"},
+		{server: "range.example.com:99999", err: "invalid port"},
+		{server: "[range.example.com]", err: "invalid host"},
+		{server: "range.example.com:8081:8082", err: "invalid host"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.server, func(t *testing.T) {
+			got, err := normalizeServer(c.server)
+			ensureError(t, err, c.err)
+			if got != c.want {
+				t.Errorf("GOT: %q; WANT: %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestClientIPv6Server(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewClient(&Config{Servers: []string{"range.example.com:8081:8082"}})
+		ensureError(t, err, "invalid range server address")
+		_, err = NewClient(&Config{WeightedServers: []ServerWeight{{Server: ":8081", Weight: 1}}})
+		ensureError(t, err, "invalid range server address")
+	})
+
+	t.Run("normalized", func(t *testing.T) {
+		client, err := NewClient(&Config{Servers: []string{"::1", "127.0.0.1:8081"}})
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, client.Servers(), []string{"[::1]", "127.0.0.1:8081"})
+		ensureError(t, client.AddServer("fe80::1"))
+		ensureError(t, client.AddServer("[fe80::1"), "invalid")
+		ensureStringSlicesMatch(t, client.Servers(), []string{"[::1]", "127.0.0.1:8081", "[fe80::1]"})
+		ensureError(t, client.RemoveServer("::1"))
+		ensureStringSlicesMatch(t, client.Servers(), []string{"127.0.0.1:8081", "[fe80::1]"})
+	})
+
+	t.Run("query", func(t *testing.T) {
+		listener, err := net.Listen("tcp6", "[::1]:0")
+		if err != nil {
+			t.Skip(err)
+		}
+		server := &httptest.Server{Listener: listener, Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1\n"))
+		})}}
+		server.Start()
+		defer server.Close()
+
+		client, err := NewClient(&Config{Servers: []string{strings.TrimPrefix(server.URL, "http://")}})
+		ensureError(t, err)
+		values, err := client.Query("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1"})
+	})
+}
+
+func TestClientBaseURLServers(t *testing.T) {
+	var lock sync.Mutex
+	var paths []string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		paths = append(paths, r.URL.Path)
+		lock.Unlock()
+		w.Write([]byte("result1\n"))
+	}
+	withTestServer(t, h, func(server *httptest.Server) {
+		address := strings.TrimPrefix(server.URL, "http://")
+		client, err := NewClient(&Config{
+			Servers: []string{server.URL + "/proxy/", address, "http://" + address + "/other"},
+		})
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, client.Servers(), []string{server.URL + "/proxy", address, "http://" + address + "/other"})
+
+		for i := 0; i < 3; i++ {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1"})
+		}
+	})
+	ensureStringSlicesMatch(t, paths, []string{"/proxy/range/list", "/range/list", "/other/range/list"})
+
+	_, err := NewClient(&Config{Servers: []string{"ftp://range.example.com"}})
+	ensureError(t, err, "invalid range server address", "unsupported scheme")
+}
@@ -0,0 +1,78 @@
+package orange
+
+import "sort"
+
+// Union returns the values that are in either a or b, treating each as a set.
+// Values are returned in the order they first appear in a followed by b, with
+// duplicates removed.
+func Union(a, b []string) []string {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	var union []string
+	for _, values := range [][]string{a, b} {
+		for _, v := range values {
+			if _, ok := seen[v]; !ok {
+				seen[v] = struct{}{}
+				union = append(union, v)
+			}
+		}
+	}
+	return union
+}
+
+// Intersection returns the values that are in both a and b, treating each as a
+// set.  Values are returned in the order they first appear in a, with
+// duplicates removed.
+func Intersection(a, b []string) []string {
+	return filter(a, b, true)
+}
+
+// Subtract returns the values that are in a but not in b, treating each as a
+// set, which is the set difference range expresses as "a,-b".  Values are
+// returned in the order they first appear in a, with duplicates removed.
+func Subtract(a, b []string) []string {
+	return filter(a, b, false)
+}
+
+// filter returns the values of a whose membership in b matches keep, in the
+// order they first appear in a, with duplicates removed.
+func filter(a, b []string, keep bool) []string {
+	inB := make(map[string]struct{}, len(b))
+	for _, v := range b {
+		inB[v] = struct{}{}
+	}
+	seen := make(map[string]struct{}, len(a))
+	var filtered []string
+	for _, v := range a {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		if _, ok := inB[v]; ok == keep {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
+// processResults sorts and removes duplicates from lines, as configured, and
+// returns an empty slice rather than nil when there are no lines.
+func (c *Client) processResults(lines []string) []string {
+	if lines == nil {
+		return []string{}
+	}
+	if c.sortResults {
+		sort.Strings(lines)
+		if c.dedupeResults {
+			unique := lines[:0]
+			for i, line := range lines {
+				if i == 0 || line != lines[i-1] {
+					unique = append(unique, line)
+				}
+			}
+			lines = unique
+		}
+	} else if c.dedupeResults {
+		lines = Union(lines, nil)
+	}
+	return lines
+}
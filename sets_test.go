@@ -0,0 +1,115 @@
+package orange
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSetOperations(t *testing.T) {
+	cases := []struct {
+		name                            string
+		a, b                            []string
+		union, intersection, difference []string
+	}{
+		{
+			name: "both empty",
+		},
+		{
+			name:  "first empty",
+			b:     []string{"b1", "b2"},
+			union: []string{"b1", "b2"},
+		},
+		{
+			name:       "second empty",
+			a:          []string{"a1", "a2"},
+			union:      []string{"a1", "a2"},
+			difference: []string{"a1", "a2"},
+		},
+		{
+			name:       "disjoint",
+			a:          []string{"a2", "a1"},
+			b:          []string{"b1"},
+			union:      []string{"a2", "a1", "b1"},
+			difference: []string{"a2", "a1"},
+		},
+		{
+			name:         "overlapping",
+			a:            []string{"host3", "host1", "host2"},
+			b:            []string{"host4", "host2", "host3"},
+			union:        []string{"host3", "host1", "host2", "host4"},
+			intersection: []string{"host3", "host2"},
+			difference:   []string{"host1"},
+		},
+		{
+			name:         "duplicates",
+			a:            []string{"host1", "host1", "host2"},
+			b:            []string{"host2", "host2", "host3", "host3"},
+			union:        []string{"host1", "host2", "host3"},
+			intersection: []string{"host2"},
+			difference:   []string{"host1"},
+		},
+		{
+			name:         "identical",
+			a:            []string{"host1", "host2"},
+			b:            []string{"host2", "host1"},
+			union:        []string{"host1", "host2"},
+			intersection: []string{"host1", "host2"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got, want := Union(c.a, c.b), c.union; !reflect.DeepEqual(got, want) {
+				t.Errorf("Union: GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := Intersection(c.a, c.b), c.intersection; !reflect.DeepEqual(got, want) {
+				t.Errorf("Intersection: GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := Subtract(c.a, c.b), c.difference; !reflect.DeepEqual(got, want) {
+				t.Errorf("Subtract: GOT: %v; WANT: %v", got, want)
+			}
+		})
+	}
+}
+
+func TestClientProcessResults(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("host3\nhost1\nhost3\nhost2\nhost1\n"))
+	}
+
+	cases := []struct {
+		name         string
+		sort, dedupe bool
+		want         []string
+	}{
+		{"neither", false, false, []string{"host3", "host1", "host3", "host2", "host1"}},
+		{"sort", true, false, []string{"host1", "host1", "host2", "host3", "host3"}},
+		{"dedupe", false, true, []string{"host3", "host1", "host2"}},
+		{"sort and dedupe", true, true, []string{"host1", "host2", "host3"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			configure := func(config *Config) {
+				config.SortResults = c.sort
+				config.DedupeResults = c.dedupe
+			}
+			withConfiguredClient(t, h, configure, func(client *Client) {
+				values, err := client.Query("foo")
+				ensureError(t, err)
+				if got, want := values, c.want; !reflect.DeepEqual(got, want) {
+					t.Errorf("GOT: %v; WANT: %v", got, want)
+				}
+
+				// QueryCallback streams the response unchanged.
+				var raw []string
+				ensureError(t, client.QueryCallback(context.Background(), "foo", appendLines(&raw, false)))
+				if got, want := len(raw), 5; got != want {
+					t.Errorf("GOT: %v; WANT: %v", got, want)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package orange
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// flightGroup coalesces concurrent queries for the same expression, so that
+// only one query is sent to a range server while others wait for and share its
+// result.
+type flightGroup struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is a query in flight, shared by one or more waiting callers.
+type flightCall struct {
+	done      chan struct{}
+	cancel    context.CancelFunc
+	waiters   int
+	deadline  time.Time // deadline is the latest deadline of the waiting callers
+	unbounded bool      // unbounded is true when a waiting caller has no deadline
+	values    []string
+	err       error
+}
+
+// join adds a caller whose query context is ctx to the waiters of the call.
+// The caller must hold the lock of the flightGroup.
+func (call *flightCall) join(ctx context.Context) {
+	call.waiters++
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		call.unbounded = true
+	} else if deadline.After(call.deadline) {
+		call.deadline = deadline
+	}
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// Do invokes query for key unless a query for key is already in flight, in
+// which case it waits for and returns a copy of that query's result.
+//
+// The shared query runs with a context that carries the values of the context
+// of the caller that started it, and whose deadline is the latest deadline of
+// the waiting callers, or none when a waiting caller has none.  It is only
+// canceled after every waiting caller's context has closed, so one caller
+// canceling does not abort the query for the others.
+func (g *flightGroup) Do(ctx context.Context, key string, query func(context.Context) ([]string, error)) ([]string, error) {
+	g.lock.Lock()
+	call, ok := g.calls[key]
+	if !ok {
+		detached, cancel := context.WithCancel(detachedContext{ctx})
+		call = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		shared := flightContext{Context: detached, group: g, call: call}
+
+		go func() {
+			values, err := query(shared)
+			g.lock.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.lock.Unlock()
+			call.values, call.err = values, err
+			close(call.done)
+			cancel()
+		}()
+	}
+	call.join(ctx)
+	g.lock.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return nil, call.err
+		}
+		return copyStrings(call.values), nil
+	case <-ctx.Done():
+		g.lock.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody remains to receive the result, so abort the query, and
+			// ensure later callers start a new one.
+			call.cancel()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.lock.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Waiters returns the number of callers waiting for the query for key.
+func (g *flightGroup) Waiters(key string) int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if call, ok := g.calls[key]; ok {
+		return call.waiters
+	}
+	return 0
+}
+
+// flightContext is the context of a shared query, which reports the latest
+// deadline of the query's waiting callers.
+type flightContext struct {
+	context.Context
+	group *flightGroup
+	call  *flightCall
+}
+
+func (fc flightContext) Deadline() (time.Time, bool) {
+	fc.group.lock.Lock()
+	defer fc.group.lock.Unlock()
+	if fc.call.unbounded {
+		return time.Time{}, false
+	}
+	return fc.call.deadline, true
+}
+
+// detachedContext carries the values of its parent context, but neither its
+// deadline nor its cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
@@ -0,0 +1,219 @@
+package orange
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingDoer counts requests, and blocks each until released or until the
+// request's context closes.
+type blockingDoer struct {
+	count   int32
+	release chan struct{}
+}
+
+func (d *blockingDoer) Do(request *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&d.count, 1)
+	select {
+	case <-d.release:
+	case <-request.Context().Done():
+		return nil, request.Context().Err()
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader("result1\nresult2\n")),
+	}, nil
+}
+
+// waitForWaiters polls until the client has n callers waiting on expression.
+func waitForWaiters(tb testing.TB, client *Client, expression string, n int) {
+	tb.Helper()
+	for i := 0; client.flights.Waiters(expression) != n; i++ {
+		if i == 1000 {
+			tb.Fatalf("GOT: %v; WANT: %v", client.flights.Waiters(expression), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalesceQueries(t *testing.T) {
+	const callers = 16
+
+	t.Run("share one request", func(t *testing.T) {
+		doer := &blockingDoer{release: make(chan struct{})}
+		client, err := NewClient(&Config{
+			CoalesceQueries: true,
+			HTTPClient:      doer,
+			Servers:         []string{"localhost:8081"},
+		})
+		ensureError(t, err)
+
+		var wg sync.WaitGroup
+		wg.Add(callers)
+		for i := 0; i < callers; i++ {
+			go func() {
+				defer wg.Done()
+				values, err := client.QueryCtx(context.Background(), "foo")
+				ensureError(t, err)
+				ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+			}()
+		}
+
+		waitForWaiters(t, client, "foo", callers)
+		close(doer.release)
+		wg.Wait()
+
+		if got, want := atomic.LoadInt32(&doer.count), int32(1); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("one caller canceling does not abort others", func(t *testing.T) {
+		doer := &blockingDoer{release: make(chan struct{})}
+		client, err := NewClient(&Config{
+			CoalesceQueries: true,
+			HTTPClient:      doer,
+			Servers:         []string{"localhost:8081"},
+		})
+		ensureError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		canceled := make(chan error)
+		go func() {
+			_, err := client.QueryCtx(ctx, "foo")
+			canceled <- err
+		}()
+		waitForWaiters(t, client, "foo", 1)
+
+		var wg sync.WaitGroup
+		wg.Add(callers - 1)
+		for i := 1; i < callers; i++ {
+			go func() {
+				defer wg.Done()
+				values, err := client.QueryCtx(context.Background(), "foo")
+				ensureError(t, err)
+				ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+			}()
+		}
+		waitForWaiters(t, client, "foo", callers)
+
+		cancel()
+		ensureError(t, <-canceled, context.Canceled.Error())
+
+		close(doer.release)
+		wg.Wait()
+
+		if got, want := atomic.LoadInt32(&doer.count), int32(1); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("last caller canceling aborts request", func(t *testing.T) {
+		doer := &blockingDoer{release: make(chan struct{})}
+		client, err := NewClient(&Config{
+			CoalesceQueries: true,
+			HTTPClient:      doer,
+			Servers:         []string{"localhost:8081"},
+		})
+		ensureError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			waitForWaiters(t, client, "foo", 1)
+			cancel()
+		}()
+		_, err = client.QueryCtx(ctx, "foo")
+		ensureError(t, err, context.Canceled.Error())
+
+		// A subsequent query must not join the aborted one.
+		close(doer.release)
+		values, err := client.Query("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+	})
+
+	t.Run("options not shared", func(t *testing.T) {
+		doer := &blockingDoer{release: make(chan struct{})}
+		client, err := NewClient(&Config{
+			CoalesceQueries: true,
+			HTTPClient:      doer,
+			Servers:         []string{"localhost:8081"},
+		})
+		ensureError(t, err)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := client.QueryCtx(context.Background(), "foo")
+			ensureError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := client.QueryWithOptions(context.Background(), "foo", WithNoRetry())
+			ensureError(t, err)
+		}()
+		waitForWaiters(t, client, "foo", 1)
+		waitForWaiters(t, client, flightKey(context.WithValue(context.Background(), retryCountKey{}, 0), "foo"), 1)
+		close(doer.release)
+		wg.Wait()
+
+		if got, want := atomic.LoadInt32(&doer.count), int32(2); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("latest deadline", func(t *testing.T) {
+		doer := &blockingDoer{release: make(chan struct{})}
+		var deadline time.Time
+		var bounded bool
+		client, err := NewClient(&Config{
+			CoalesceQueries: true,
+			HTTPClient: DoerFunc(func(request *http.Request) (*http.Response, error) {
+				response, err := doer.Do(request)
+				deadline, bounded = request.Context().Deadline()
+				return response, err
+			}),
+			Servers: []string{"localhost:8081"},
+		})
+		ensureError(t, err)
+
+		now := time.Now()
+		query := func(timeout time.Duration) {
+			ctx, cancel := context.WithDeadline(context.Background(), now.Add(timeout))
+			defer cancel()
+			_, err := client.QueryCtx(ctx, "foo")
+			ensureError(t, err)
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			query(time.Minute)
+		}()
+		waitForWaiters(t, client, "foo", 1)
+		go func() {
+			defer wg.Done()
+			query(time.Hour)
+		}()
+		waitForWaiters(t, client, "foo", 2)
+		close(doer.release)
+		wg.Wait()
+
+		if got, want := bounded, true; got != want {
+			t.Fatalf("GOT: %v; WANT: %v", got, want)
+		}
+		if got, want := deadline, now.Add(time.Hour); !got.Equal(want) {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package orange
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// streamError wraps an error that occurs once QueryStream has started
+// delivering values to its callback.  Such queries are never retried, because
+// values already delivered cannot be taken back.
+type streamError struct {
+	err    error
+	caller bool // caller is true when err was returned by the callback
+}
+
+func (e streamError) Error() string { return e.err.Error() }
+
+func (e streamError) Unwrap() error { return e.err }
+
+// isStreamError returns true when err occurred while streaming values to the
+// callback of QueryStream.
+func isStreamError(err error) bool {
+	_, ok := err.(streamError)
+	return ok
+}
+
+// QueryStream sends the query expression to a range server with the provided
+// context, and invokes callback with each value of its successful response as
+// the value is read, so very large responses may be processed without holding
+// all of their values in memory.  Blank lines are skipped, and when the client
+// asks for JSON, a JSON array is decoded one element at a time.  The values
+// are neither sorted, deduplicated, cached, nor coalesced.
+//
+// When callback returns an error, QueryStream stops reading, closes the
+// response body, and returns that error.  Once the first value is delivered,
+// the query is not retried, so callback never sees a value twice.
+//
+//     err := client.QueryStream(ctx, "%all", func(host string) error {
+//         if host == "needle.example.com" {
+//             return errFound
+//         }
+//         return nil
+//     })
+func (c *Client) QueryStream(ctx context.Context, expression string, callback func(string) error) error {
+	err := c.QueryCallback(ctx, expression, c.streamValues(callback))
+	if e, ok := err.(streamError); ok {
+		return e.err
+	}
+	return err
+}
+
+// streamValues returns a callback that invokes callback with each value of the
+// response body.
+func (c *Client) streamValues(callback func(string) error) func(io.Reader) error {
+	return func(ior io.Reader) error {
+		var delivered bool
+		deliver := func(value string) error {
+			delivered = true
+			if err := callback(value); err != nil {
+				return streamError{err: err, caller: true}
+			}
+			return nil
+		}
+
+		var err error
+		if c.responseFormat == JSONResponse {
+			br := bufio.NewReader(ior)
+			if startsJSONArray(br) {
+				err = streamJSON(br, deliver)
+			} else {
+				err = streamLines(br, c.preserveLineEndings, deliver)
+			}
+		} else {
+			err = streamLines(ior, c.preserveLineEndings, deliver)
+		}
+		if err != nil && delivered && !isStreamError(err) {
+			err = streamError{err: err}
+		}
+		return err
+	}
+}
+
+// streamLines invokes deliver with each line of ior that is not blank.  Each
+// line is held back until the following line is read, so the final line is
+// not delivered when a read error may have truncated it.
+func streamLines(ior io.Reader, preserve bool, deliver func(string) error) error {
+	s := newLineScanner(ior, preserve)
+	var pending string
+	for s.Scan() {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if pending != "" {
+			if err := deliver(pending); err != nil {
+				return err
+			}
+		}
+		pending = line
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if pending != "" {
+		return deliver(pending)
+	}
+	return nil
+}
+
+// streamJSON invokes deliver with each string of the JSON array read from ior.
+func streamJSON(ior io.Reader, deliver func(string) error) error {
+	decoder := json.NewDecoder(ior)
+	if _, err := decoder.Token(); err != nil {
+		return ErrParse{Err: err}
+	}
+	for decoder.More() {
+		var value string
+		if err := decoder.Decode(&value); err != nil {
+			return ErrParse{Err: err}
+		}
+		if err := deliver(value); err != nil {
+			return err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return ErrParse{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package orange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClientQueryStream(t *testing.T) {
+	t.Run("values", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1\n\nresult2\n"))
+		}
+		withClient(t, h, func(client *Client) {
+			var values []string
+			err := client.QueryStream(context.Background(), "foo", func(value string) error {
+				values = append(values, value)
+				return nil
+			})
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+		})
+	})
+
+	t.Run("json", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`["result1", "result2"]`))
+		}
+		configure := func(config *Config) { config.ResponseFormat = JSONResponse }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			var values []string
+			err := client.QueryStream(context.Background(), "foo", func(value string) error {
+				values = append(values, value)
+				return nil
+			})
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+		})
+	})
+
+	t.Run("callback error", func(t *testing.T) {
+		var requests int32
+		h := func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			for i := 0; i < 100000; i++ {
+				if _, err := fmt.Fprintf(w, "host%d\n", i); err != nil {
+					return // client closed the connection
+				}
+			}
+		}
+		configure := func(config *Config) {
+			config.MaxConcurrency = 1
+			config.RetryCallback = func(error) bool { return true }
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			errFound := errors.New("found")
+			var count int
+			err := client.QueryStream(context.Background(), "foo", func(value string) error {
+				count++
+				if value == "host2" {
+					return errFound
+				}
+				return nil
+			})
+			if got, want := err, errFound; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			if got, want := count, 3; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+			// The query released its concurrency slot.
+			if got, want := client.InFlight(), 0; got != want {
+				t.Errorf("GOT: %v; WANT: %v", got, want)
+			}
+		})
+		if got, want := atomic.LoadInt32(&requests), int32(1); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("not retried after delivery", func(t *testing.T) {
+		var requests int32
+		h := func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.Write([]byte("result1\nresult2\nresult3\n"))
+		}
+		configure := func(config *Config) {
+			config.MaxResponseSize = 10
+			config.RetryCallback = func(error) bool { return true }
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			var values []string
+			err := client.QueryStream(context.Background(), "foo", func(value string) error {
+				values = append(values, value)
+				return nil
+			})
+			ensureError(t, err, "response too large")
+			ensureStringSlicesMatch(t, values, []string{"result1"})
+		})
+		if got, want := atomic.LoadInt32(&requests), int32(1); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not here", http.StatusNotFound)
+		}
+		withClient(t, h, func(client *Client) {
+			err := client.QueryStream(context.Background(), "foo", func(value string) error {
+				t.Errorf("GOT: %v; WANT: no values", value)
+				return nil
+			})
+			ensureError(t, err, http.StatusText(http.StatusNotFound))
+		})
+	})
+}
@@ -0,0 +1,237 @@
+package orange
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("result1\n"))
+	}))
+	defer server.Close()
+
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	t.Run("unverified certificate", func(t *testing.T) {
+		client, err := NewClient(&Config{Servers: []string{address}, TLSConfig: &tls.Config{}})
+		ensureError(t, err)
+		defer client.Close()
+
+		_, err = client.Query("foo")
+		ensureError(t, err, "certificate")
+	})
+
+	t.Run("trusted certificate", func(t *testing.T) {
+		pool := x509.NewCertPool()
+		pool.AddCert(server.Certificate())
+
+		client, err := NewClient(&Config{Servers: []string{address}, TLSConfig: &tls.Config{RootCAs: pool}})
+		ensureError(t, err)
+		defer client.Close()
+
+		values, err := client.Query("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1"})
+	})
+
+	t.Run("insecure skip verify", func(t *testing.T) {
+		client, err := NewClient(&Config{Servers: []string{address}, TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+		ensureError(t, err)
+		defer client.Close()
+
+		values, err := client.Query("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1"})
+	})
+
+	t.Run("provided http client", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			HTTPClient: server.Client(),
+			Servers:    []string{address},
+			TLSConfig:  &tls.Config{ServerName: "not.example.com"},
+		})
+		ensureError(t, err)
+		defer client.Close()
+
+		values, err := client.Query("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"result1"})
+	})
+}
+
+func TestClientEnableHTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto + "\n"))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	for _, enable := range []bool{false, true} {
+		client, err := NewClient(&Config{
+			EnableHTTP2: enable,
+			Servers:     []string{address},
+			TLSConfig:   &tls.Config{RootCAs: pool},
+		})
+		ensureError(t, err)
+
+		values, err := client.Query("foo")
+		ensureError(t, err)
+		want := "HTTP/1.1"
+		if enable {
+			want = "HTTP/2.0"
+		}
+		ensureStringSlicesMatch(t, values, []string{want})
+		client.Close()
+	}
+}
+
+// writeClientCertificate writes a newly generated self-signed client
+// certificate and its private key to PEM-encoded files in dir, and returns the
+// names of the files along with the certificate.
+func writeClientCertificate(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "orange test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, certificate
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile, certificate := writeClientCertificate(t, dir)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(certificate)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName + "\n"))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	address := strings.TrimPrefix(server.URL, "https://")
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+
+	t.Run("files", func(t *testing.T) {
+		client, err := NewClient(&Config{
+			ClientCertFile: certFile,
+			ClientKeyFile:  keyFile,
+			Servers:        []string{address},
+			TLSConfig:      &tls.Config{RootCAs: rootCAs},
+		})
+		ensureError(t, err)
+		defer client.Close()
+
+		values, err := client.Query("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"orange test client"})
+	})
+
+	t.Run("tls config certificates", func(t *testing.T) {
+		pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		ensureError(t, err)
+
+		client, err := NewClient(&Config{
+			Servers:   []string{address},
+			TLSConfig: &tls.Config{RootCAs: rootCAs, Certificates: []tls.Certificate{pair}},
+		})
+		ensureError(t, err)
+		defer client.Close()
+
+		values, err := client.Query("foo")
+		ensureError(t, err)
+		ensureStringSlicesMatch(t, values, []string{"orange test client"})
+	})
+
+	t.Run("no certificate", func(t *testing.T) {
+		client, err := NewClient(&Config{Servers: []string{address}, TLSConfig: &tls.Config{RootCAs: rootCAs}})
+		ensureError(t, err)
+		defer client.Close()
+
+		_, err = client.Query("foo")
+		if err == nil {
+			t.Errorf("GOT: %v; WANT: %v", err, "certificate required")
+		}
+	})
+
+	t.Run("missing key file", func(t *testing.T) {
+		_, err := NewClient(&Config{ClientCertFile: certFile, Servers: []string{address}})
+		ensureError(t, err, "only one of ClientCertFile and ClientKeyFile")
+	})
+
+	t.Run("unloadable key pair", func(t *testing.T) {
+		_, err := NewClient(&Config{ClientCertFile: certFile, ClientKeyFile: certFile, Servers: []string{address}})
+		ensureError(t, err, "cannot create Client without loading client certificate")
+	})
+
+	t.Run("with http client", func(t *testing.T) {
+		_, err := NewClient(&Config{
+			ClientCertFile: certFile,
+			ClientKeyFile:  keyFile,
+			HTTPClient:     server.Client(),
+			Servers:        []string{address},
+		})
+		ensureError(t, err, "both HTTPClient and ClientCertFile")
+	})
+}
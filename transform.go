@@ -0,0 +1,20 @@
+package orange
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+// transformResponse reads the entire body of a successful response, and
+// invokes callback with the body returned by the client's ResponseTransform.
+func (c *Client) transformResponse(body io.Reader, callback func(io.Reader) error) error {
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if buf, err = c.responseTransform(buf); err != nil {
+		return err
+	}
+	return callback(bytes.NewReader(buf))
+}
@@ -0,0 +1,100 @@
+package orange
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClientResponseTransform(t *testing.T) {
+	commas := func(buf []byte) ([]byte, error) {
+		return bytes.Replace(buf, []byte(","), []byte("\n"), -1), nil
+	}
+
+	t.Run("transformed", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1,result2,result3"))
+		}
+		configure := func(config *Config) { config.ResponseTransform = commas }
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2", "result3"})
+		})
+	})
+
+	t.Run("only successful responses", func(t *testing.T) {
+		var invocations int32
+		h := func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.RawQuery == "exception" {
+				w.Header().Set("RangeException", "bad query")
+				return
+			}
+			http.Error(w, "a,b", http.StatusInternalServerError)
+		}
+		configure := func(config *Config) {
+			config.ResponseTransform = func(buf []byte) ([]byte, error) {
+				atomic.AddInt32(&invocations, 1)
+				return buf, nil
+			}
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			_, err := client.Query("foo")
+			e, ok := err.(ErrStatusNotOK)
+			if !ok {
+				t.Fatalf("GOT: %v; WANT: %T", err, e)
+			}
+			if got, want := string(e.Body), "a,b\n"; got != want {
+				t.Errorf("GOT: %q; WANT: %q", got, want)
+			}
+			_, err = client.Query("exception")
+			ensureError(t, err, "bad query")
+		})
+		if got, want := atomic.LoadInt32(&invocations), int32(0); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("error retried", func(t *testing.T) {
+		var invocations int32
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1,result2"))
+		}
+		errQuirk := errors.New("unexpected format")
+		configure := func(config *Config) {
+			config.ResponseTransform = func(buf []byte) ([]byte, error) {
+				if atomic.AddInt32(&invocations, 1) == 1 {
+					return nil, errQuirk
+				}
+				return commas(buf)
+			}
+			config.RetryCallback = func(err error) bool { return err == errQuirk }
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+		})
+		if got, want := atomic.LoadInt32(&invocations), int32(2); got != want {
+			t.Errorf("GOT: %v; WANT: %v", got, want)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("result1"))
+		}
+		configure := func(config *Config) {
+			config.ResponseTransform = func([]byte) ([]byte, error) { return nil, errors.New("unexpected format") }
+		}
+		withConfiguredClient(t, h, configure, func(client *Client) {
+			values, err := client.Query("foo")
+			ensureError(t, err, "unexpected format")
+			if values != nil {
+				t.Errorf("GOT: %v; WANT: %v", values, nil)
+			}
+		})
+	})
+}
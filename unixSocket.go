@@ -0,0 +1,23 @@
+package orange
+
+import (
+	"context"
+	"net"
+)
+
+// unixSocketHost is the range server address used as the Host of each request
+// when the client is configured with a UnixSocket but no servers.
+const unixSocketHost = "localhost"
+
+// dialUnixSocket returns a function for the DialContext of an http.Transport
+// that connects to the Unix domain socket at path, regardless of the network
+// and address of the request, so requests may name any host.
+func dialUnixSocket(path string) func(context.Context, string, string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   DefaultDialTimeout,
+		KeepAlive: DefaultDialKeepAlive,
+	}
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", path)
+	}
+}
@@ -0,0 +1,67 @@
+package orange
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withUnixSocketServer serves h on a Unix domain socket in a temporary
+// directory, and invokes callback with the path of the socket.
+func withUnixSocketServer(tb testing.TB, h func(w http.ResponseWriter, r *http.Request), callback func(string)) {
+	dir, err := ioutil.TempDir("", "orange")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "range.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		tb.Skip(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(h)}
+	go server.Serve(listener)
+	defer server.Close()
+
+	callback(path)
+}
+
+func TestClientUnixSocket(t *testing.T) {
+	var hosts []string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		w.Write([]byte("result1\nresult2\n"))
+	}
+
+	t.Run("without servers", func(t *testing.T) {
+		hosts = nil
+		withUnixSocketServer(t, h, func(path string) {
+			client, err := NewClient(&Config{UnixSocket: path})
+			ensureError(t, err)
+			values, err := client.Query("foo")
+			ensureError(t, err)
+			ensureStringSlicesMatch(t, values, []string{"result1", "result2"})
+		})
+		ensureStringSlicesMatch(t, hosts, []string{"localhost"})
+	})
+
+	t.Run("with servers", func(t *testing.T) {
+		hosts = nil
+		withUnixSocketServer(t, h, func(path string) {
+			client, err := NewClient(&Config{Servers: []string{"range.example.com"}, UnixSocket: path})
+			ensureError(t, err)
+			_, err = client.Query("foo")
+			ensureError(t, err)
+		})
+		ensureStringSlicesMatch(t, hosts, []string{"range.example.com"})
+	})
+
+	t.Run("with HTTPClient", func(t *testing.T) {
+		_, err := NewClient(&Config{HTTPClient: http.DefaultClient, UnixSocket: "/tmp/range.sock"})
+		ensureError(t, err, "both HTTPClient and UnixSocket")
+	})
+}